@@ -0,0 +1,228 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns the content of a file named name, decompressing it when
+// it is gzip-compressed, together with the name of the content: data.csv for
+// data.csv.gz. Gzip streams are recognised by extension or content and count
+// against budget as they are read.
+func decompress(name string, r io.Reader, budget *byteBudget) (string, *bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return name, nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	gzipExt := strings.EqualFold(path.Ext(name), ".gz")
+	if !gzipExt && !bytes.Equal(head, gzipMagic) {
+		return name, br, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return name, nil, Permanent(fmt.Errorf("open gzip stream: %w", err))
+	}
+	if gzipExt {
+		name = name[:len(name)-len(".gz")]
+	}
+	return name, bufio.NewReader(&budgetReader{r: &gzipReader{gz}, budget: budget}), nil
+}
+
+// gzipReader marks corrupt gzip data as permanent.
+type gzipReader struct {
+	gz *gzip.Reader
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	n, err := g.gz.Read(p)
+	var corrupt flate.CorruptInputError
+	if errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt) {
+		err = Permanent(fmt.Errorf("read gzip stream: %w", err))
+	}
+	return n, err
+}
+
+// byteBudget is the number of uncompressed bytes an upload may expand to.
+// A limit of zero or less disables the check.
+type byteBudget struct {
+	limit int64
+	used  int64
+}
+
+// budgetReader counts the bytes read from r against budget. It does not trust
+// the sizes recorded in archive headers, which an attacker controls.
+type budgetReader struct {
+	r      io.Reader
+	budget *byteBudget
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.budget.used += int64(n)
+	if b.budget.limit > 0 && b.budget.used > b.budget.limit {
+		return n, Permanent(fmt.Errorf("upload expands to more than %d bytes", b.budget.limit))
+	}
+	return n, err
+}
+
+// isArchive reports whether the file named name is a zip archive to expand.
+func isArchive(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".zip")
+}
+
+// importArchive expands the zip archive in r and imports each file in it as
+// its own import, linked to the returned import of the archive itself. The archive is
+// rejected as a whole when it holds more than archiveMaxFiles files or
+// declares more than archiveMaxBytes of content, and extraction stops once
+// the content actually read exceeds archiveMaxBytes. Directories, hidden files
+// and nested zip archives are skipped.
+//
+// Tenants in snapshot mode may only upload archives holding a single file,
+// since each file would otherwise delete the records of the others.
+func (w *Worker) importArchive(ctx context.Context, jobID int64, file upload, r io.Reader, budget *byteBudget, settings TenantSettings, schema TenantSchema) (*Import, error) {
+	parent, err := w.db.StartImport(jobID, file.tenantID, file.objectKey, file.size, file.etag, file.dryRun)
+	if err != nil {
+		return nil, err
+	}
+	err = w.importArchiveEntries(ctx, jobID, parent, file, r, budget, settings, schema)
+	parent.Status, parent.DryRun = ImportCompleted, file.dryRun
+	if err != nil {
+		parent.Status, parent.Error = ImportFailed, err.Error()
+	}
+	if finishErr := w.db.FinishImport(parent); finishErr != nil {
+		log.Printf("worker: %v", finishErr)
+	}
+	w.broker.Publish(jobID, ImportEventImport, *parent)
+	if err != nil {
+		return parent, fmt.Errorf("import %s: %w", file.objectKey, err)
+	}
+	log.Printf("worker: import %d of archive %s completed", parent.ID, file.objectKey)
+	return parent, nil
+}
+
+func (w *Worker) importArchiveEntries(ctx context.Context, jobID int64, parent *Import, file upload, r io.Reader, budget *byteBudget, settings TenantSettings, schema TenantSchema) error {
+	// zip needs random access, so the archive is spooled to disk.
+	tmp, err := os.CreateTemp("", "upload-*.zip")
+	if err != nil {
+		return fmt.Errorf("spool archive: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return fmt.Errorf("spool archive: %w", err)
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return Permanent(fmt.Errorf("open zip archive: %w", err))
+	}
+
+	var entries []*zip.File
+	var declared uint64
+	for _, f := range zr.File {
+		if skipArchiveEntry(f) {
+			continue
+		}
+		entries = append(entries, f)
+		declared += f.UncompressedSize64
+	}
+	switch {
+	case w.archiveMaxFiles > 0 && len(entries) > w.archiveMaxFiles:
+		return Permanent(fmt.Errorf("archive holds %d files, more than the limit of %d", len(entries), w.archiveMaxFiles))
+	case budget.limit > 0 && declared > uint64(budget.limit):
+		return Permanent(fmt.Errorf("archive expands to %d bytes, more than the limit of %d", declared, budget.limit))
+	case settings.IngestMode == IngestSnapshot && len(entries) > 1:
+		return Permanent(fmt.Errorf("archive holds %d files, but snapshot imports need one file per archive", len(entries)))
+	}
+
+	failed := 0
+	var retry error
+	for _, f := range entries {
+		entry := upload{
+			jobID:     file.jobID,
+			tenantID:  file.tenantID,
+			name:      f.Name,
+			objectKey: file.objectKey + "/" + f.Name,
+			reportKey: file.objectKey + "." + strings.ReplaceAll(f.Name, "/", "_") + errorReportSuffix,
+			size:      int64(f.UncompressedSize64),
+			etag:      fmt.Sprintf("%08x", f.CRC32),
+			dryRun:    file.dryRun,
+		}
+		err := w.importEntry(ctx, jobID, parent, entry, f, budget, settings, schema)
+		if err == nil {
+			continue
+		}
+		log.Printf("worker: %v", err)
+		failed++
+		if !IsPermanent(err) && retry == nil {
+			retry = err
+		}
+	}
+	if retry != nil {
+		// A retry imports the whole archive again; files that already
+		// loaded are upserted to the same records.
+		return retry
+	}
+	if failed > 0 {
+		return Permanent(fmt.Errorf("%d of %d files in the archive failed", failed, len(entries)))
+	}
+	return nil
+}
+
+func (w *Worker) importEntry(ctx context.Context, jobID int64, parent *Import, entry upload, f *zip.File, budget *byteBudget, settings TenantSettings, schema TenantSchema) error {
+	rc, err := f.Open()
+	if err != nil {
+		return Permanent(fmt.Errorf("open %s: %w", entry.objectKey, err))
+	}
+	defer func() { _ = rc.Close() }()
+	_, err = w.importFile(ctx, jobID, parent, entry, &zipEntryReader{r: &budgetReader{r: rc, budget: budget}}, budget, settings, schema)
+	return err
+}
+
+// zipEntryReader marks corrupt archive data as permanent.
+type zipEntryReader struct {
+	r io.Reader
+}
+
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	var corrupt flate.CorruptInputError
+	if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt) {
+		err = Permanent(fmt.Errorf("read zip entry: %w", err))
+	}
+	return n, err
+}
+
+// skipArchiveEntry reports whether an entry is not a file to import:
+// directories, hidden and macOS metadata files, and nested zip archives.
+func skipArchiveEntry(f *zip.File) bool {
+	if f.FileInfo().IsDir() {
+		return true
+	}
+	for _, part := range strings.Split(f.Name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	if strings.EqualFold(path.Ext(f.Name), ".zip") {
+		log.Printf("worker: skipping nested archive %s", f.Name)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(gz, s); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// zipBytes builds a zip archive from name and content pairs; names ending in
+// a slash are directories.
+func zipBytes(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		f, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		if _, err := io.WriteString(f, files[i+1]); err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func uploadEvent(path string) map[string]any {
+	return map[string]any{"username": "user1", "virtual_path": path}
+}
+
+func TestProcessUploadEventDecompressesGzip(t *testing.T) {
+	w := newTenantTestWorker(t)
+	store := w.store.(memStore)
+	store["tid1/records.csv.gz"] = gzipBytes(t, "key,title,value\nR1,First,1\nR2,Second,oops\n")
+	// Compressed files are recognised by content too.
+	store["tid1/export.ndjson"] = gzipBytes(t, `{"key":"N1","title":"NDJSON","value":2}`+"\n")
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/records.csv.gz")); !IsPermanent(err) {
+		t.Fatalf("expected permanent error for the rejected row, got %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/export.ndjson")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "N1" {
+		t.Errorf("records = %+v, want N1 only after the atomic gzip import rolled back", records)
+	}
+	if _, ok := store["tid1/records.csv.gz.errors.csv"]; !ok {
+		t.Errorf("expected an error report next to the compressed upload")
+	}
+	imports, err := w.db.ListJobImports(2)
+	if err != nil {
+		t.Fatalf("ListJobImports: %v", err)
+	}
+	if len(imports) != 1 || imports[0].Status != ImportCompleted || imports[0].RowsInserted != 1 {
+		t.Errorf("imports = %+v, want one completed import", imports)
+	}
+}
+
+func TestProcessUploadEventRejectsBadGzip(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.archiveMaxBytes = 100
+	store := w.store.(memStore)
+	store["tid1/big.csv.gz"] = gzipBytes(t, "key,title,value\n"+strings.Repeat("R1,Title,1\n", 100))
+	corrupt := gzipBytes(t, "key,title,value\nR1,First,1\n")
+	corrupt[len(corrupt)-6] ^= 0xff
+	store["tid1/corrupt.csv.gz"] = corrupt
+	store["tid1/notgzip.csv.gz"] = []byte("key,title,value\n")
+
+	for i, name := range []string{"/big.csv.gz", "/corrupt.csv.gz", "/notgzip.csv.gz"} {
+		jobID := int64(i + 1)
+		if err := w.ProcessUploadEvent(context.Background(), jobID, uploadEvent(name)); !IsPermanent(err) {
+			t.Errorf("%s: expected permanent error, got %v", name, err)
+		}
+		imports, err := w.db.ListJobImports(jobID)
+		if err != nil {
+			t.Fatalf("ListJobImports: %v", err)
+		}
+		if len(imports) != 1 || imports[0].Status != ImportFailed {
+			t.Errorf("%s: imports = %+v, want one failed import", name, imports)
+		}
+	}
+}
+
+func TestProcessUploadEventExpandsZip(t *testing.T) {
+	w := newTenantTestWorker(t)
+	store := w.store.(memStore)
+	store["tid1/batch.zip"] = zipBytes(t,
+		"a.csv", "key,title,value\nA1,First,1\n",
+		"nested/", "",
+		"nested/b.csv.gz", string(gzipBytes(t, "key,title,value\nB1,Second,2\nB2,Bad,x\n")),
+		"__MACOSX/._a.csv", "junk",
+		"inner.zip", "PK",
+		"readme.txt", "not data",
+	)
+
+	err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/batch.zip"))
+	if !IsPermanent(err) || !strings.Contains(err.Error(), "1 of 3 files") {
+		t.Fatalf("expected permanent error for the failed entry, got %v", err)
+	}
+
+	imports, err := w.db.ListJobImports(1)
+	if err != nil {
+		t.Fatalf("ListJobImports: %v", err)
+	}
+	if len(imports) != 3 {
+		t.Fatalf("expected the archive and two entry imports, got %+v", imports)
+	}
+	parent := imports[0]
+	if parent.ObjectKey != "tid1/batch.zip" || parent.ParentID != nil || parent.Status != ImportFailed {
+		t.Errorf("parent = %+v, want failed import of tid1/batch.zip", parent)
+	}
+	for i, want := range []struct{ key, status string }{
+		{"tid1/batch.zip/a.csv", ImportCompleted},
+		{"tid1/batch.zip/nested/b.csv.gz", ImportFailed},
+	} {
+		child := imports[i+1]
+		if child.ObjectKey != want.key || child.Status != want.status || child.ParentID == nil || *child.ParentID != parent.ID {
+			t.Errorf("child %d = %+v, want %s %s under import %d", i, child, want.status, want.key, parent.ID)
+		}
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "A1" {
+		t.Errorf("records = %+v, want A1 only", records)
+	}
+	if _, ok := store["tid1/batch.zip.nested_b.csv.gz.errors.csv"]; !ok {
+		t.Errorf("expected an error report for the failed entry, got keys %v", keys(store))
+	}
+}
+
+func TestProcessUploadEventRejectsOversizedZip(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.archiveMaxFiles = 2
+	w.archiveMaxBytes = 1000
+	store := w.store.(memStore)
+	row := "key,title,value\nR1,First,1\n"
+	store["tid1/many.zip"] = zipBytes(t, "a.csv", row, "b.csv", row, "c.csv", row)
+	store["tid1/large.zip"] = zipBytes(t, "a.csv", "key,title,value\n"+strings.Repeat("R1,First,1\n", 100))
+	store["tid1/garbage.zip"] = []byte("not a zip")
+
+	for i, name := range []string{"/many.zip", "/large.zip", "/garbage.zip"} {
+		jobID := int64(i + 1)
+		if err := w.ProcessUploadEvent(context.Background(), jobID, uploadEvent(name)); !IsPermanent(err) {
+			t.Errorf("%s: expected permanent error, got %v", name, err)
+		}
+		imports, err := w.db.ListJobImports(jobID)
+		if err != nil {
+			t.Fatalf("ListJobImports: %v", err)
+		}
+		if len(imports) != 1 || imports[0].Status != ImportFailed {
+			t.Errorf("%s: imports = %+v, want only the failed archive import", name, imports)
+		}
+	}
+	if records, _ := w.db.ListRecords("tid1"); len(records) != 0 {
+		t.Errorf("expected no records, got %+v", records)
+	}
+}
+
+func TestProcessUploadEventSnapshotNeedsSingleFileArchive(t *testing.T) {
+	w := newTenantTestWorker(t)
+	settings := DefaultTenantSettings()
+	settings.IngestMode = IngestSnapshot
+	if err := w.db.SaveTenantSettings("tid1", settings); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	row := "key,title,value\nR1,First,1\n"
+	store := w.store.(memStore)
+	store["tid1/two.zip"] = zipBytes(t, "a.csv", row, "b.csv", row)
+	store["tid1/one.zip"] = zipBytes(t, "a.csv", row)
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/two.zip")); !IsPermanent(err) {
+		t.Errorf("expected permanent error for a multi-file snapshot archive, got %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/one.zip")); err != nil {
+		t.Errorf("ProcessUploadEvent: %v", err)
+	}
+}
+
+func TestBudgetReaderIgnoresDeclaredSizes(t *testing.T) {
+	budget := &byteBudget{limit: 10}
+	r := &budgetReader{r: strings.NewReader("0123456789"), budget: budget}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("reading up to the limit: %v", err)
+	}
+	r = &budgetReader{r: strings.NewReader("x"), budget: budget}
+	if _, err := io.ReadAll(r); !IsPermanent(err) {
+		t.Errorf("expected permanent error past the shared limit, got %v", err)
+	}
+}
+
+func keys(m memStore) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+)
+
+// Import event names, sent as the event field of server-sent events.
+const (
+	// ImportEventProgress carries the row counts of the file being imported,
+	// every Config.ProgressRows rows.
+	ImportEventProgress = "progress"
+	// ImportEventError carries a row of the file that was rejected, once the
+	// file was read.
+	ImportEventError = "error"
+	// ImportEventImport carries the finished import of a file.
+	ImportEventImport = "import"
+	// ImportEventStatus carries the status of the job after an attempt.
+	ImportEventStatus = "status"
+)
+
+// brokerBuffer is how many events a subscriber may fall behind by before it
+// misses progress and error events.
+const brokerBuffer = 256
+
+// ImportEvent is a progress update of an ingestion job.
+type ImportEvent struct {
+	Name string
+	Data any
+}
+
+// ImportProgress is the data of progress events.
+type ImportProgress struct {
+	ObjectKey    string `json:"object_key"`
+	RowsRead     int    `json:"rows_read"`
+	RowsInserted int    `json:"rows_inserted"`
+	RowsUpdated  int    `json:"rows_updated"`
+	RowsRejected int    `json:"rows_rejected"`
+}
+
+// JobStatus is the data of status events: the state of a job once an attempt
+// finished. A pending job will be retried.
+type JobStatus struct {
+	JobID    int64  `json:"job_id"`
+	Status   string `json:"status" enums:"pending,done,dead"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// final reports whether the job will not run again.
+func (s JobStatus) final() bool {
+	return s.Status == JobDone || s.Status == JobDead
+}
+
+// Broker passes the events of ingestion jobs from the worker to the clients
+// following them, in process. Publishing never blocks the import.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[int64]map[*Subscription]struct{}
+}
+
+// NewBroker creates a Broker without subscribers.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[int64]map[*Subscription]struct{})}
+}
+
+// Subscription holds the events of a job published for one subscriber until
+// it takes them. A subscriber that falls brokerBuffer events behind misses
+// progress and error events, but never import and status events, so it
+// always learns how each file and attempt ended.
+type Subscription struct {
+	mu     sync.Mutex
+	events []ImportEvent
+	ready  chan struct{}
+}
+
+// Ready receives a value when events are waiting to be taken.
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Take returns the events published since the previous call, in order.
+func (s *Subscription) Take() []ImportEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+func (s *Subscription) push(ev ImportEvent) {
+	s.mu.Lock()
+	lagging := len(s.events) >= brokerBuffer
+	if lagging && (ev.Name == ImportEventProgress || ev.Name == ImportEventError) {
+		s.mu.Unlock()
+		return
+	}
+	s.events = append(s.events, ev)
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Subscribe returns a subscription to the events of job jobID published from
+// now on, and a function ending it.
+func (b *Broker) Subscribe(jobID int64) (*Subscription, func()) {
+	sub := &Subscription{ready: make(chan struct{}, 1)}
+	b.mu.Lock()
+	if b.subs[jobID] == nil {
+		b.subs[jobID] = make(map[*Subscription]struct{})
+	}
+	b.subs[jobID][sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs[jobID], sub)
+			if len(b.subs[jobID]) == 0 {
+				delete(b.subs, jobID)
+			}
+		})
+	}
+}
+
+// Publish sends an event of job jobID to its subscribers. It does nothing on
+// a nil Broker.
+func (b *Broker) Publish(jobID int64, name string, data any) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[jobID] {
+		sub.push(ImportEvent{Name: name, Data: data})
+	}
+}
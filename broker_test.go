@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBrokerFansOutEvents(t *testing.T) {
+	b := NewBroker()
+	first, unsubscribeFirst := b.Subscribe(1)
+	second, unsubscribeSecond := b.Subscribe(1)
+	other, unsubscribeOther := b.Subscribe(2)
+	defer unsubscribeSecond()
+	defer unsubscribeOther()
+
+	b.Publish(1, ImportEventProgress, ImportProgress{RowsRead: 10})
+	for _, sub := range []*Subscription{first, second} {
+		<-sub.Ready()
+		events := sub.Take()
+		if len(events) != 1 || events[0].Name != ImportEventProgress || events[0].Data.(ImportProgress).RowsRead != 10 {
+			t.Errorf("events = %+v, want progress with 10 rows", events)
+		}
+	}
+	if events := other.Take(); len(events) != 0 {
+		t.Errorf("subscriber of job 2 got %d events", len(events))
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	b.Publish(1, ImportEventStatus, JobStatus{JobID: 1, Status: JobDone})
+	if n, m := len(first.Take()), len(second.Take()); n != 0 || m != 1 {
+		t.Errorf("got %d and %d events, want only the remaining subscriber's", n, m)
+	}
+
+	// Publishing never blocks on a subscriber that fell behind, which then
+	// misses progress and error events but keeps the others.
+	for i := 0; i < brokerBuffer+10; i++ {
+		b.Publish(2, ImportEventError, ImportError{Line: i})
+	}
+	b.Publish(2, ImportEventProgress, ImportProgress{})
+	b.Publish(2, ImportEventImport, Import{ID: 3})
+	b.Publish(2, ImportEventStatus, JobStatus{JobID: 2, Status: JobDone})
+	events := other.Take()
+	if len(events) != brokerBuffer+2 || events[brokerBuffer].Name != ImportEventImport || events[brokerBuffer+1].Name != ImportEventStatus {
+		t.Errorf("kept %d events, want %d errors, the import and the status", len(events), brokerBuffer)
+	}
+	var nilBroker *Broker
+	nilBroker.Publish(1, ImportEventProgress, nil)
+}
+
+func TestProcessJobPublishesProgress(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.broker, w.progressRows = NewBroker(), 2
+	store := w.store.(memStore)
+	store["tid1/data.csv"] = []byte("key,title,value\nR1,First,1\nR2,Second,x\nR3,Third,3\nR4,Fourth,y\nR5,Fifth,5\n")
+	settings := DefaultTenantSettings()
+	settings.ImportPolicy = ImportBestEffort
+	if err := w.db.SaveTenantSettings("tid1", settings); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	sub, unsubscribe := w.broker.Subscribe(7)
+	defer unsubscribe()
+
+	job := &Job{ID: 7, Payload: uploadEvent("/data.csv"), Attempts: 1, MaxAttempts: 3}
+	if err := w.ProcessJob(context.Background(), job); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+
+	var names []string
+	got := sub.Take()
+	for _, ev := range got {
+		names = append(names, ev.Name)
+	}
+	want := []string{"progress", "progress", "error", "error", "import", "status"}
+	if len(names) != len(want) {
+		t.Fatalf("events = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("events = %v, want %v", names, want)
+		}
+	}
+	if p := got[1].Data.(ImportProgress); p.ObjectKey != "tid1/data.csv" || p.RowsRead != 4 || p.RowsInserted != 2 || p.RowsRejected != 2 {
+		t.Errorf("progress = %+v, want 4 rows read, 2 inserted and 2 rejected", p)
+	}
+	imp := got[4].Data.(Import)
+	if rowErr := got[2].Data.(ImportError); rowErr.Line != 3 || rowErr.ImportID != imp.ID {
+		t.Errorf("error = %+v, want line 3 of import %d", rowErr, imp.ID)
+	}
+	if imp.Status != ImportCompleted || imp.RowsInserted != 3 {
+		t.Errorf("import = %+v, want 3 rows inserted", imp)
+	}
+	if status := got[5].Data.(JobStatus); status != (JobStatus{JobID: 7, Status: JobDone, Attempts: 1}) {
+		t.Errorf("status = %+v, want job 7 done", status)
+	}
+}
+
+func TestProcessJobKeepsFinalEventsOfLargeImports(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.broker, w.progressRows = NewBroker(), 100
+	var csv strings.Builder
+	csv.WriteString("key,title,value\n")
+	rows := brokerBuffer + 100
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&csv, "R%d,Row,x\n", i)
+	}
+	w.store.(memStore)["tid1/data.csv"] = []byte(csv.String())
+	settings := DefaultTenantSettings()
+	settings.ImportPolicy = ImportBestEffort
+	if err := w.db.SaveTenantSettings("tid1", settings); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	sub, unsubscribe := w.broker.Subscribe(7)
+	defer unsubscribe()
+
+	// The subscriber reads nothing until the job is over.
+	job := &Job{ID: 7, Payload: uploadEvent("/data.csv"), Attempts: 1, MaxAttempts: 3}
+	if err := w.ProcessJob(context.Background(), job); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	got := sub.Take()
+	if len(got) != brokerBuffer+2 {
+		t.Fatalf("got %d events, want %d and the final two", len(got), brokerBuffer)
+	}
+	if imp, ok := got[len(got)-2].Data.(Import); !ok || imp.RowsRejected != rows {
+		t.Errorf("import event = %+v, want %d rows rejected", got[len(got)-2], rows)
+	}
+	if status, ok := got[len(got)-1].Data.(JobStatus); !ok || status.Status != JobDone {
+		t.Errorf("last event = %+v, want job done", got[len(got)-1])
+	}
+}
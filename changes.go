@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ensureChangeLog creates record_changes, the change feed of records, and
+// the triggers that append to it whenever a record is inserted, deleted or
+// has its data updated, in the transaction that changed the record. It is
+// filled with an insert for every existing record when it is first created.
+//
+// Sequence numbers are never reused, and as SQLite runs one write
+// transaction at a time, changes are committed in sequence order: a reader
+// that saw sequence n never sees a change below n appear later.
+func ensureChangeLog(conn *sql.DB) error {
+	var exists bool
+	if err := conn.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'record_changes')").Scan(&exists); err != nil {
+		return fmt.Errorf("migrate record_changes: %w", err)
+	}
+	if _, err := conn.Exec(`
+		CREATE TABLE IF NOT EXISTS record_changes (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			tenant_id TEXT NOT NULL,
+			record_key TEXT NOT NULL,
+			op TEXT NOT NULL,
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS record_changes_tenant ON record_changes (tenant_id, seq);
+		CREATE INDEX IF NOT EXISTS record_changes_key ON record_changes (tenant_id, record_key, seq);
+		CREATE TRIGGER IF NOT EXISTS record_changes_insert AFTER INSERT ON records BEGIN
+			INSERT INTO record_changes (tenant_id, record_key, op) VALUES (new.tenant_id, new.record_key, 'insert');
+		END;
+		CREATE TRIGGER IF NOT EXISTS record_changes_delete AFTER DELETE ON records BEGIN
+			INSERT INTO record_changes (tenant_id, record_key, op) VALUES (old.tenant_id, old.record_key, 'delete');
+		END;
+		-- Uploads that repeat a record only touch its provenance and
+		-- updated_at, which is not a change.
+		CREATE TRIGGER IF NOT EXISTS record_changes_update AFTER UPDATE ON records
+		WHEN old.title IS NOT new.title OR old.description IS NOT new.description OR old.category IS NOT new.category
+			OR old.value IS NOT new.value OR old.value_decimal IS NOT new.value_decimal OR old.attributes IS NOT new.attributes
+		BEGIN
+			INSERT INTO record_changes (tenant_id, record_key, op) VALUES (new.tenant_id, new.record_key, 'update');
+		END;
+	`); err != nil {
+		return fmt.Errorf("migrate record_changes: %w", err)
+	}
+	if !exists {
+		if _, err := conn.Exec(`
+			INSERT INTO record_changes (tenant_id, record_key, op, changed_at)
+			SELECT tenant_id, record_key, 'insert', updated_at FROM records ORDER BY id`); err != nil {
+			return fmt.Errorf("migrate record_changes: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// changeOps returns the operation and key of each change, as "op key".
+func changeOps(changes []RecordChange) []string {
+	var ops []string
+	for _, c := range changes {
+		ops = append(ops, c.Op+" "+c.RecordKey)
+	}
+	return ops
+}
+
+func listChanges(t *testing.T, db *DB, tenantID string, since int64, limit int) []RecordChange {
+	t.Helper()
+	changes, err := db.ListRecordChanges(tenantID, since, limit)
+	if err != nil {
+		t.Fatalf("ListRecordChanges: %v", err)
+	}
+	return changes
+}
+
+func TestListRecordChanges(t *testing.T) {
+	db := newTestDB(t)
+	upsertSearchRecords(t, db,
+		Record{RecordKey: "R1", Title: "First", Value: 1},
+		Record{RecordKey: "R2", Title: "Second", Value: 2},
+		Record{RecordKey: "R3", Title: "Third", Value: 3},
+	)
+	if err := db.UpsertRecord("tid2", "R1", "Other tenant", "", "", 1); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	changes := listChanges(t, db, "tid1", 0, 10)
+	if got := changeOps(changes); len(got) != 3 || got[0] != "insert R1" || got[2] != "insert R3" {
+		t.Fatalf("changes = %v, want the three inserts", got)
+	}
+	if r := changes[1].Record; r == nil || r.Title != "Second" || r.Value != 2 {
+		t.Errorf("record = %+v, want R2", r)
+	}
+	synced := changes[2].Seq
+
+	// Repeating a record from another upload changes only its provenance.
+	upsertSearchRecords(t, db,
+		Record{RecordKey: "R1", Title: "First", Value: 1, SourceKey: "tid1/again.csv", JobID: 9},
+		Record{RecordKey: "R2", Title: "Second", Value: 20},
+	)
+	tx, err := db.BeginImport("tid1")
+	if err != nil {
+		t.Fatalf("BeginImport: %v", err)
+	}
+	if _, err := tx.DeleteMissing(map[string]struct{}{"R1": {}, "R2": {}}, 0); err != nil {
+		t.Fatalf("DeleteMissing: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	changes = listChanges(t, db, "tid1", synced, 10)
+	if got := changeOps(changes); len(got) != 2 || got[0] != "update R2" || got[1] != "delete R3" {
+		t.Fatalf("changes since %d = %v, want the update of R2 and the delete of R3", synced, got)
+	}
+	if r := changes[0].Record; r == nil || r.Value != 20 {
+		t.Errorf("record = %+v, want the updated R2", r)
+	}
+	if changes[1].Record != nil || changes[1].Seq <= changes[0].Seq || changes[0].Seq <= synced {
+		t.Errorf("changes = %+v, want a tombstone after the update, both after %d", changes, synced)
+	}
+
+	// Records are listed once, at their latest change, and pages follow
+	// each other.
+	page := listChanges(t, db, "tid1", 0, 2)
+	if got := changeOps(page); len(got) != 2 || got[0] != "insert R1" || got[1] != "update R2" {
+		t.Fatalf("first page = %v", got)
+	}
+	if got := changeOps(listChanges(t, db, "tid1", page[1].Seq, 2)); len(got) != 1 || got[0] != "delete R3" {
+		t.Errorf("second page = %v", got)
+	}
+}
+
+func TestNewDBFillsChangeLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	upsertSearchRecords(t, db, Record{RecordKey: "R1", Title: "First"})
+	// A database from before the change log existed.
+	if _, err := db.conn.Exec("DROP TABLE record_changes; DROP TRIGGER record_changes_insert"); err != nil {
+		t.Fatalf("drop change log: %v", err)
+	}
+	_ = db.Close()
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if got := changeOps(listChanges(t, db, "tid1", 0, 10)); len(got) != 1 || got[0] != "insert R1" {
+		t.Errorf("changes = %v, want R1 filled in on migration", got)
+	}
+}
+
+func TestListRecordChangesHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	upsertSearchRecords(t, h.db, Record{RecordKey: "R1", Title: "First"}, Record{RecordKey: "R2", Title: "Second"})
+
+	var resp struct {
+		Changes []RecordChange `json:"changes"`
+		Next    int64          `json:"next"`
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/records/changes?limit=1", nil)
+	rec := httptest.NewRecorder()
+	h.ListRecordChanges(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Changes) != 1 || resp.Changes[0].RecordKey != "R1" || resp.Next != resp.Changes[0].Seq {
+		t.Fatalf("response = %+v, want R1 and its sequence", resp)
+	}
+
+	since := resp.Next
+	for _, want := range []string{"R2", ""} {
+		req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/records/changes?since="+strconv.FormatInt(since, 10), nil)
+		rec = httptest.NewRecorder()
+		h.ListRecordChanges(rec, req)
+		resp.Changes = nil
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if want == "" {
+			if len(resp.Changes) != 0 || resp.Next != since {
+				t.Errorf("response = %+v, want no changes after %d", resp, since)
+			}
+			continue
+		}
+		if len(resp.Changes) != 1 || resp.Changes[0].RecordKey != want {
+			t.Fatalf("response = %+v, want %s", resp, want)
+		}
+		since = resp.Next
+	}
+
+	for _, target := range []string{"/api/tenants/1/records/changes?since=-1", "/api/tenants/1/records/changes?since=x", "/api/tenants/1/records/changes?limit=0"} {
+		req = httptest.NewRequest(http.MethodGet, target, nil)
+		rec = httptest.NewRecorder()
+		h.ListRecordChanges(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/9/records/changes", nil)
+	rec = httptest.NewRecorder()
+	h.ListRecordChanges(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config holds all application configuration loaded from environment variables.
 type Config struct {
@@ -16,6 +21,36 @@ type Config struct {
 	S3AccessKey string
 	S3SecretKey string
 	S3UseSSL    bool
+
+	WorkerConcurrency int
+	JobMaxAttempts    int
+	JobRetryBase      time.Duration
+	JobRetryMax       time.Duration
+
+	// ArchiveMaxFiles and ArchiveMaxBytes bound what one compressed upload
+	// may expand to: the number of files in a zip archive and the total
+	// uncompressed size of its files or of a gzip stream.
+	ArchiveMaxFiles int
+	ArchiveMaxBytes int64
+
+	// PGPMasterKey encrypts the tenants' PGP private keys at rest: 32 bytes
+	// encoded as hex or base64. PGP uploads cannot be read without it.
+	PGPMasterKey string
+
+	// ScanInterval is how often every tenant's prefix is listed to find
+	// uploads the hook missed; zero disables the scanner. ScanInclude and
+	// ScanExclude are glob patterns selecting the files it picks up.
+	ScanInterval time.Duration
+	ScanInclude  []string
+	ScanExclude  []string
+
+	// S3Notifications subscribes to the bucket's object created
+	// notifications, a MinIO extension, as a second ingestion trigger.
+	S3Notifications bool
+
+	// ProgressRows is how many rows an import reads between the progress
+	// events streamed to clients; zero sends only the final counts.
+	ProgressRows int
 }
 
 // LoadConfig reads configuration from environment variables with sensible defaults.
@@ -32,6 +67,24 @@ func LoadConfig() Config {
 		S3AccessKey: envOr("S3_ACCESS_KEY", ""),
 		S3SecretKey: envOr("S3_SECRET_KEY", ""),
 		S3UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+
+		WorkerConcurrency: envInt("WORKER_CONCURRENCY", 2),
+		JobMaxAttempts:    envInt("JOB_MAX_ATTEMPTS", 5),
+		JobRetryBase:      envDuration("JOB_RETRY_BASE", 10*time.Second),
+		JobRetryMax:       envDuration("JOB_RETRY_MAX", 10*time.Minute),
+
+		ArchiveMaxFiles: envInt("ARCHIVE_MAX_FILES", 100),
+		ArchiveMaxBytes: int64(envInt("ARCHIVE_MAX_BYTES", 1<<30)),
+
+		PGPMasterKey: envOr("PGP_MASTER_KEY", ""),
+
+		ScanInterval: envDuration("SCAN_INTERVAL", 0),
+		ScanInclude:  envList("SCAN_INCLUDE"),
+		ScanExclude:  envList("SCAN_EXCLUDE"),
+
+		S3Notifications: os.Getenv("S3_NOTIFICATIONS") == "true",
+
+		ProgressRows: envInt("PROGRESS_ROWS", 1000),
 	}
 }
 
@@ -41,3 +94,33 @@ func envOr(key, fallback string) string {
 	}
 	return fallback
 }
+
+// envInt parses key as an integer, falling back when it is unset or invalid.
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// envList splits key on commas, dropping empty items.
+func envList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envDuration parses key with time.ParseDuration, falling back when it is
+// unset or invalid.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return d
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadConfigDefaults(t *testing.T) {
@@ -32,6 +33,8 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	t.Setenv("LISTEN_ADDR", ":3000")
 	t.Setenv("S3_ENDPOINT", "http://minio:9000")
 	t.Setenv("S3_USE_SSL", "true")
+	t.Setenv("S3_NOTIFICATIONS", "true")
+	t.Setenv("PROGRESS_ROWS", "250")
 
 	cfg := LoadConfig()
 
@@ -47,6 +50,12 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	if !cfg.S3UseSSL {
 		t.Error("S3UseSSL should be true")
 	}
+	if !cfg.S3Notifications {
+		t.Error("S3Notifications should be true")
+	}
+	if cfg.ProgressRows != 250 {
+		t.Errorf("ProgressRows = %d, want 250", cfg.ProgressRows)
+	}
 }
 
 func TestEnvOr(t *testing.T) {
@@ -62,3 +71,75 @@ func TestEnvOr(t *testing.T) {
 		t.Errorf("envOr = %q, want %q", got, "custom")
 	}
 }
+
+func TestLoadConfigQueueDefaults(t *testing.T) {
+	for _, k := range []string{"WORKER_CONCURRENCY", "JOB_MAX_ATTEMPTS", "JOB_RETRY_BASE", "JOB_RETRY_MAX"} {
+		t.Setenv(k, "")
+	}
+
+	cfg := LoadConfig()
+
+	if cfg.WorkerConcurrency != 2 {
+		t.Errorf("WorkerConcurrency = %d, want 2", cfg.WorkerConcurrency)
+	}
+	if cfg.JobMaxAttempts != 5 {
+		t.Errorf("JobMaxAttempts = %d, want 5", cfg.JobMaxAttempts)
+	}
+	if cfg.JobRetryBase != 10*time.Second {
+		t.Errorf("JobRetryBase = %s, want 10s", cfg.JobRetryBase)
+	}
+	if cfg.JobRetryMax != 10*time.Minute {
+		t.Errorf("JobRetryMax = %s, want 10m", cfg.JobRetryMax)
+	}
+}
+
+func TestLoadConfigArchiveDefaults(t *testing.T) {
+	t.Setenv("ARCHIVE_MAX_FILES", "")
+	t.Setenv("ARCHIVE_MAX_BYTES", "")
+
+	cfg := LoadConfig()
+
+	if cfg.ArchiveMaxFiles != 100 {
+		t.Errorf("ArchiveMaxFiles = %d, want 100", cfg.ArchiveMaxFiles)
+	}
+	if cfg.ArchiveMaxBytes != 1<<30 {
+		t.Errorf("ArchiveMaxBytes = %d, want 1 GiB", cfg.ArchiveMaxBytes)
+	}
+}
+
+func TestLoadConfigScanner(t *testing.T) {
+	t.Setenv("SCAN_INTERVAL", "")
+	t.Setenv("SCAN_INCLUDE", "*.csv, in/*.json,,")
+	t.Setenv("SCAN_EXCLUDE", "")
+
+	cfg := LoadConfig()
+
+	if cfg.ScanInterval != 0 {
+		t.Errorf("ScanInterval = %s, want the scanner disabled by default", cfg.ScanInterval)
+	}
+	if len(cfg.ScanInclude) != 2 || cfg.ScanInclude[0] != "*.csv" || cfg.ScanInclude[1] != "in/*.json" {
+		t.Errorf("ScanInclude = %q, want [*.csv in/*.json]", cfg.ScanInclude)
+	}
+	if cfg.ScanExclude != nil {
+		t.Errorf("ScanExclude = %q, want none", cfg.ScanExclude)
+	}
+}
+
+func TestEnvIntAndDuration(t *testing.T) {
+	t.Setenv("TEST_ENV_INT", "7")
+	t.Setenv("TEST_ENV_BAD_INT", "seven")
+	t.Setenv("TEST_ENV_DURATION", "90s")
+
+	if got := envInt("TEST_ENV_INT", 1); got != 7 {
+		t.Errorf("envInt = %d, want 7", got)
+	}
+	if got := envInt("TEST_ENV_BAD_INT", 1); got != 1 {
+		t.Errorf("envInt with invalid value = %d, want fallback 1", got)
+	}
+	if got := envDuration("TEST_ENV_DURATION", time.Second); got != 90*time.Second {
+		t.Errorf("envDuration = %s, want 90s", got)
+	}
+	if got := envDuration("TEST_ENV_UNSET_DURATION", time.Second); got != time.Second {
+		t.Errorf("envDuration unset = %s, want fallback 1s", got)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "modernc.org/sqlite"
 )
@@ -34,21 +38,282 @@ type Tenant struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// Record represents a data entry parsed from a CSV upload, keyed by (tenant_id, record_key).
+// Record represents a data entry parsed from an upload, keyed by (tenant_id, record_key).
 type Record struct {
-	ID          int64     `json:"id"`
-	TenantID    string    `json:"tenant_id"`
-	RecordKey   string    `json:"record_key"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Category    string    `json:"category"`
-	Value       float64   `json:"value"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int64   `json:"id"`
+	TenantID    string  `json:"tenant_id"`
+	RecordKey   string  `json:"record_key"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Category    string  `json:"category"`
+	Value       float64 `json:"value"`
+	// ValueDecimal is the exact decimal text of Value, stored for tenants
+	// with exact decimals enabled.
+	ValueDecimal string `json:"value_decimal,omitempty" example:"1234.50"`
+	// Attributes holds the CSV columns that are not mapped to a field above.
+	Attributes map[string]string `json:"attributes"`
+	// SourceKey is the object key of the upload that last wrote the record;
+	// records from an archive name the archive entry, as in batch.zip/a.csv.
+	SourceKey string `json:"source_key,omitempty"`
+	// JobID is the ingestion job of that upload and SourceLine the line of
+	// the file the record was read from.
+	JobID      int64     `json:"job_id,omitempty"`
+	SourceLine int       `json:"source_line,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// Snippet is the matching text of a search result, with matches
+	// enclosed in <mark> tags.
+	Snippet string `json:"snippet,omitempty" example:"Annual <mark>invoice</mark> for EMEA"`
 }
 
-// NewDB opens a SQLite database at path and runs migrations.
+// RecordVersion is an earlier state of a record, saved when an import
+// changed or deleted it. UpdatedAt is when the version was written,
+// ReplacedAt when job ReplacedByJobID replaced or deleted it.
+type RecordVersion struct {
+	ID              int64             `json:"id"`
+	RecordKey       string            `json:"record_key"`
+	Title           string            `json:"title"`
+	Description     string            `json:"description"`
+	Category        string            `json:"category"`
+	Value           float64           `json:"value"`
+	ValueDecimal    string            `json:"value_decimal,omitempty"`
+	Attributes      map[string]string `json:"attributes"`
+	SourceKey       string            `json:"source_key,omitempty"`
+	JobID           int64             `json:"job_id,omitempty"`
+	SourceLine      int               `json:"source_line,omitempty"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	ReplacedAt      time.Time         `json:"replaced_at"`
+	ReplacedByJobID int64             `json:"replaced_by_job_id,omitempty"`
+}
+
+// Record change operations in the change feed.
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// RecordChange is the latest change of a record in its tenant's change feed.
+type RecordChange struct {
+	// Seq orders the changes of all tenants and only ever grows.
+	Seq       int64     `json:"seq"`
+	Op        string    `json:"op" enums:"insert,update,delete"`
+	RecordKey string    `json:"record_key"`
+	ChangedAt time.Time `json:"changed_at"`
+	// Record is the current state of an inserted or updated record, and
+	// nil for a deleted one.
+	Record *Record `json:"record,omitempty"`
+}
+
+// Groupings of record statistics.
+const (
+	GroupTenant   = "tenant"
+	GroupCategory = "category"
+	GroupDay      = "day"
+	GroupWeek     = "week"
+	GroupMonth    = "month"
+)
+
+// statsGroups maps each grouping to the SQL expression naming its groups.
+// Time buckets use the UTC time of the last update; weeks start on Monday.
+var statsGroups = map[string]string{
+	GroupTenant:   "tenant_id",
+	GroupCategory: "category",
+	GroupDay:      "date(updated_at)",
+	GroupWeek:     "date(updated_at, 'weekday 0', '-6 days')",
+	GroupMonth:    "strftime('%Y-%m', updated_at)",
+}
+
+// RecordStats summarizes the values of a group of records.
+type RecordStats struct {
+	// Group is the tenant ID or category of the group, or the first day
+	// (2024-01-29) or month (2024-01) of its time bucket.
+	Group string  `json:"group,omitempty"`
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+}
+
+// RecordFilter narrows a record listing. Every attribute must match exactly.
+type RecordFilter struct {
+	// Key selects the record with this key.
+	Key        string
+	Attributes map[string]string
+	// Query is a full-text search of the title and description in FTS5
+	// syntax; see ftsQuery. Matches are ordered by relevance.
+	Query string
+}
+
+// Job statuses. A job that failed but will be retried goes back to pending
+// with a later run_at.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Job is a persisted unit of background work, such as ingesting an upload.
+type Job struct {
+	ID int64 `json:"id"`
+	// Kind picks the handler of the job; it is empty for ingestion jobs.
+	// It is set when the job is enqueued, never from its payload.
+	Kind        string         `json:"kind,omitempty" example:"webhook"`
+	Payload     map[string]any `json:"payload"`
+	Status      string         `json:"status"`
+	Attempts    int            `json:"attempts"`
+	MaxAttempts int            `json:"max_attempts"`
+	LastError   string         `json:"last_error,omitempty"`
+	RunAt       time.Time      `json:"run_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// Import statuses.
+const (
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+	// ImportSkipped marks an upload whose content was already ingested.
+	ImportSkipped = "skipped"
+)
+
+// Import records one attempt at ingesting an uploaded object.
+type Import struct {
+	ID    int64 `json:"id"`
+	JobID int64 `json:"job_id"`
+	// ParentID is the import of the archive this file was extracted from.
+	ParentID *int64 `json:"parent_id,omitempty"`
+	// DuplicateOf is the import that already ingested the content of a
+	// skipped upload.
+	DuplicateOf *int64 `json:"duplicate_of,omitempty"`
+	// DryRun marks an import that validated the file without writing
+	// records; its row counts say what the import would have done.
+	DryRun       bool       `json:"dry_run,omitempty"`
+	TenantID     string     `json:"tenant_id"`
+	ObjectKey    string     `json:"object_key"`
+	Size         int64      `json:"size"`
+	ETag         string     `json:"etag"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+	RowsRead     int        `json:"rows_read"`
+	RowsInserted int        `json:"rows_inserted"`
+	RowsUpdated  int        `json:"rows_updated"`
+	RowsRejected int        `json:"rows_rejected"`
+	RowsDeleted  int        `json:"rows_deleted"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// IngestedObject is the last content of an object that was imported
+// successfully. An upload event for the same key is a duplicate when the
+// object still has this ETag or this content.
+type IngestedObject struct {
+	TenantID   string    `json:"tenant_id"`
+	ObjectKey  string    `json:"object_key"`
+	ETag       string    `json:"etag"`
+	SHA256     string    `json:"sha256"`
+	ImportID   int64     `json:"import_id"`
+	IngestedAt time.Time `json:"ingested_at"`
+}
+
+// Transfer actions recorded in the transfer log.
+const (
+	TransferUpload   = "upload"
+	TransferDownload = "download"
+	TransferDelete   = "delete"
+	TransferRename   = "rename"
+)
+
+// Transfer is an entry of a tenant's transfer log: a file that was
+// uploaded, downloaded, deleted or renamed over SFTP.
+type Transfer struct {
+	ID       int64  `json:"id"`
+	TenantID string `json:"tenant_id"`
+	Action   string `json:"action" enums:"upload,download,delete,rename"`
+	Path     string `json:"path" example:"/in/data.csv"`
+	// TargetPath is the new path of a renamed file.
+	TargetPath string `json:"target_path,omitempty"`
+	Size       int64  `json:"size"`
+	// Status is SFTPGo's outcome: 1 for success, 2 for an error and 3 for
+	// an exceeded quota.
+	Status    int       `json:"status"`
+	Protocol  string    `json:"protocol,omitempty" example:"SFTP"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook delivery statuses. A delivery that failed but will be retried
+// stays pending.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a subscription of a URL to events, of one tenant or of all of
+// them.
+type Webhook struct {
+	ID int64 `json:"id"`
+	// TenantID restricts the webhook to the events of one tenant; empty
+	// subscribes to the events of every tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+	URL      string `json:"url" example:"https://example.com/hooks/sftpgo"`
+	// Secret signs the deliveries. It is only returned when the webhook is
+	// created.
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events" example:"import.completed,import.failed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent, or still to be sent, to a webhook.
+type WebhookDelivery struct {
+	ID        int64  `json:"id"`
+	WebhookID int64  `json:"webhook_id"`
+	Event     string `json:"event" example:"import.completed"`
+	// Payload is the JSON body posted to the webhook.
+	Payload  json.RawMessage `json:"payload" swaggertype:"object"`
+	Status   string          `json:"status" enums:"pending,delivered,failed"`
+	Attempts int             `json:"attempts"`
+	// ResponseStatus is the HTTP status of the last attempt, zero when no
+	// response was received.
+	ResponseStatus int       `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TenantPGPKeys holds the keys used to read a tenant's PGP-encrypted uploads.
+type TenantPGPKeys struct {
+	TenantID string `json:"-"`
+	// PrivateKey is the armored decryption key, sealed with the master key.
+	PrivateKey string `json:"-"`
+	// PublicKey is the public half of the decryption key, which partners
+	// encrypt their uploads to.
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	// VerifyKey is the partner's public key that uploads must be signed with.
+	VerifyKey         string    `json:"verify_key,omitempty"`
+	VerifyFingerprint string    `json:"verify_fingerprint,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ImportError describes one rejected row of an import.
+type ImportError struct {
+	ID       int64  `json:"id"`
+	ImportID int64  `json:"import_id"`
+	Line     int    `json:"line"`
+	Column   string `json:"column,omitempty"`
+	Reason   string `json:"reason"`
+	Raw      string `json:"raw"`
+}
+
+// NewDB opens a SQLite database at path and runs migrations. Writers wait
+// for each other instead of failing immediately, since the job queue writes
+// from several goroutines.
 func NewDB(path string) (*DB, error) {
-	conn, err := sql.Open("sqlite", path)
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
@@ -76,15 +341,197 @@ func NewDB(path string) (*DB, error) {
 			description TEXT NOT NULL DEFAULT '',
 			category TEXT NOT NULL DEFAULT '',
 			value REAL NOT NULL DEFAULT 0,
+			value_decimal TEXT NOT NULL DEFAULT '',
+			attributes TEXT NOT NULL DEFAULT '{}',
+			source_key TEXT NOT NULL DEFAULT '',
+			job_id INTEGER NOT NULL DEFAULT 0,
+			source_line INTEGER NOT NULL DEFAULT 0,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(tenant_id, record_key)
 		);
+		CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY,
+			kind TEXT NOT NULL DEFAULT '',
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			run_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS jobs_status_run_at ON jobs (status, run_at);
+		CREATE TABLE IF NOT EXISTS imports (
+			id INTEGER PRIMARY KEY,
+			job_id INTEGER NOT NULL,
+			tenant_id TEXT NOT NULL,
+			object_key TEXT NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			etag TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'running',
+			error TEXT NOT NULL DEFAULT '',
+			rows_read INTEGER NOT NULL DEFAULT 0,
+			rows_inserted INTEGER NOT NULL DEFAULT 0,
+			rows_updated INTEGER NOT NULL DEFAULT 0,
+			rows_rejected INTEGER NOT NULL DEFAULT 0,
+			rows_deleted INTEGER NOT NULL DEFAULT 0,
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME
+		);
+		CREATE INDEX IF NOT EXISTS imports_tenant ON imports (tenant_id, id);
+		CREATE INDEX IF NOT EXISTS imports_job ON imports (job_id);
+		CREATE TABLE IF NOT EXISTS import_errors (
+			id INTEGER PRIMARY KEY,
+			import_id INTEGER NOT NULL,
+			line INTEGER NOT NULL,
+			column_name TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL,
+			raw TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS import_errors_import ON import_errors (import_id, line);
+		CREATE TABLE IF NOT EXISTS tenant_settings (
+			tenant_id TEXT PRIMARY KEY,
+			settings TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS tenant_schemas (
+			tenant_id TEXT PRIMARY KEY,
+			schema TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS tenant_pgp_keys (
+			tenant_id TEXT PRIMARY KEY,
+			private_key TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			verify_key TEXT NOT NULL DEFAULT '',
+			verify_fingerprint TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS ingested_objects (
+			tenant_id TEXT NOT NULL,
+			object_key TEXT NOT NULL,
+			etag TEXT NOT NULL,
+			sha256 TEXT NOT NULL,
+			import_id INTEGER NOT NULL,
+			ingested_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (tenant_id, object_key)
+		);
+		CREATE TABLE IF NOT EXISTS transfer_log (
+			id INTEGER PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			path TEXT NOT NULL,
+			target_path TEXT NOT NULL DEFAULT '',
+			size INTEGER NOT NULL DEFAULT 0,
+			status INTEGER NOT NULL DEFAULT 0,
+			protocol TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS transfer_log_tenant ON transfer_log (tenant_id, id);
+		CREATE TABLE IF NOT EXISTS record_history (
+			id INTEGER PRIMARY KEY,
+			record_id INTEGER NOT NULL,
+			tenant_id TEXT NOT NULL,
+			record_key TEXT NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			category TEXT NOT NULL DEFAULT '',
+			value REAL NOT NULL DEFAULT 0,
+			value_decimal TEXT NOT NULL DEFAULT '',
+			attributes TEXT NOT NULL DEFAULT '{}',
+			source_key TEXT NOT NULL DEFAULT '',
+			job_id INTEGER NOT NULL DEFAULT 0,
+			source_line INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME,
+			replaced_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			replaced_by_job_id INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS record_history_key ON record_history (tenant_id, record_key, id);
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY,
+			tenant_id TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '[]',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_status INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+	`); err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	for _, c := range []struct{ table, column, definition string }{
+		{"records", "attributes", "TEXT NOT NULL DEFAULT '{}'"},
+		{"records", "value_decimal", "TEXT NOT NULL DEFAULT ''"},
+		{"imports", "parent_id", "INTEGER"},
+		{"imports", "duplicate_of", "INTEGER"},
+		{"imports", "dry_run", "INTEGER NOT NULL DEFAULT 0"},
+		{"jobs", "dedupe_key", "TEXT"},
+		{"jobs", "kind", "TEXT NOT NULL DEFAULT ''"},
+		{"records", "source_key", "TEXT NOT NULL DEFAULT ''"},
+		{"records", "job_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"records", "source_line", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if err := ensureColumn(conn, c.table, c.column, c.definition); err != nil {
+			return nil, err
+		}
+	}
+	// Indexes on migrated columns can only be created once the columns exist.
+	if _, err := conn.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS jobs_dedupe_key ON jobs (dedupe_key) WHERE dedupe_key IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS imports_object ON imports (tenant_id, object_key);
+		CREATE INDEX IF NOT EXISTS records_source ON records (tenant_id, source_key);
 	`); err != nil {
 		return nil, fmt.Errorf("migrate: %w", err)
 	}
+	if err := ensureSearchIndex(conn); err != nil {
+		return nil, err
+	}
+	if err := ensureChangeLog(conn); err != nil {
+		return nil, err
+	}
 	return &DB{conn: conn}, nil
 }
 
+// ensureColumn adds a column to a table created before the column existed.
+func ensureColumn(conn *sql.DB, table, column, definition string) error {
+	rows, err := conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", table, err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("migrate %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("migrate %s: %w", table, err)
+	}
+	if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("migrate %s: add column %s: %w", table, column, err)
+	}
+	return nil
+}
+
 // CreateAPIKey generates and stores a new random 64-char hex API key.
 func (db *DB) CreateAPIKey(label string) (*APIKey, error) {
 	b := make([]byte, 32)
@@ -176,6 +623,19 @@ func (db *DB) GetTenantByUsername(username string) (*Tenant, error) {
 	return &t, nil
 }
 
+// GetTenantByTenantID retrieves a tenant by the tenant ID that prefixes its
+// objects in the bucket.
+func (db *DB) GetTenantByTenantID(tenantID string) (*Tenant, error) {
+	var t Tenant
+	err := db.conn.QueryRow(
+		"SELECT id, tenant_id, username, password, public_key, home_dir, created_at FROM tenants WHERE tenant_id = ?", tenantID,
+	).Scan(&t.ID, &t.TenantID, &t.Username, &t.Password, &t.PublicKey, &t.HomeDir, &t.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get tenant by tenant id %q: %w", tenantID, err)
+	}
+	return &t, nil
+}
+
 // UpdateTenantPublicKey sets a new SSH public key for the given tenant.
 func (db *DB) UpdateTenantPublicKey(id int64, publicKey string) error {
 	_, err := db.conn.Exec("UPDATE tenants SET public_key = ? WHERE id = ?", publicKey, id)
@@ -185,42 +645,234 @@ func (db *DB) UpdateTenantPublicKey(id int64, publicKey string) error {
 	return nil
 }
 
-// DeleteTenant removes a tenant by ID and returns their SFTP username.
+// DeleteTenant removes a tenant by ID, together with its settings, schema and
+// PGP keys, in one transaction, and returns their SFTP username. The
+// tenant's webhooks and their deliveries are removed too, except for webhooks
+// that still have deliveries to make or subscribe to tenant.deleted, which
+// the caller emits next; DeleteRetiredWebhook removes those once their
+// deliveries are done.
 func (db *DB) DeleteTenant(id int64) (string, error) {
-	var username string
-	if err := db.conn.QueryRow("SELECT username FROM tenants WHERE id = ?", id).Scan(&username); err != nil {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", fmt.Errorf("delete tenant %d: %w", id, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var username, tenantID string
+	if err := tx.QueryRow("SELECT username, tenant_id FROM tenants WHERE id = ?", id).Scan(&username, &tenantID); err != nil {
 		return "", fmt.Errorf("find tenant %d: %w", id, err)
 	}
-	if _, err := db.conn.Exec("DELETE FROM tenants WHERE id = ?", id); err != nil {
+	if _, err := tx.Exec("DELETE FROM tenants WHERE id = ?", id); err != nil {
+		return "", fmt.Errorf("delete tenant %d: %w", id, err)
+	}
+	// Private keys and webhook secrets must not outlive their tenant.
+	retired := "SELECT id FROM webhooks WHERE tenant_id = ? AND " + retiredWebhook +
+		" AND NOT EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = ?)"
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{"DELETE FROM webhook_deliveries WHERE webhook_id IN (" + retired + ")", []any{tenantID, EventTenantDeleted}},
+		{"DELETE FROM webhooks WHERE id IN (" + retired + ")", []any{tenantID, EventTenantDeleted}},
+		{"DELETE FROM tenant_settings WHERE tenant_id = ?", []any{tenantID}},
+		{"DELETE FROM tenant_schemas WHERE tenant_id = ?", []any{tenantID}},
+		{"DELETE FROM tenant_pgp_keys WHERE tenant_id = ?", []any{tenantID}},
+	} {
+		if _, err := tx.Exec(q.query, q.args...); err != nil {
+			return "", fmt.Errorf("delete data of tenant %d: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return "", fmt.Errorf("delete tenant %d: %w", id, err)
 	}
 	return username, nil
 }
 
-// UpsertRecord inserts or updates a record identified by (tenantID, recordKey).
+// UpsertRecord inserts or updates a single record identified by
+// (tenantID, recordKey). It goes through an ImportTx of its own so the change
+// is kept in the record's history and the change feed like any import.
 func (db *DB) UpsertRecord(tenantID, recordKey, title, description, category string, value float64) error {
-	_, err := db.conn.Exec(`
-		INSERT INTO records (tenant_id, record_key, title, description, category, value, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-		ON CONFLICT(tenant_id, record_key) DO UPDATE SET
-			title=excluded.title,
-			description=excluded.description,
-			category=excluded.category,
-			value=excluded.value,
-			updated_at=CURRENT_TIMESTAMP`,
-		tenantID, recordKey, title, description, category, value,
-	)
+	tx, err := db.BeginImport(tenantID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.UpsertRecord(Record{
+		RecordKey:   recordKey,
+		Title:       title,
+		Description: description,
+		Category:    category,
+		Value:       value,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ImportTx applies the records of an import, or of one batch of a
+// best-effort import, inside one transaction so they are either all applied
+// or all discarded.
+type ImportTx struct {
+	tx       *sql.Tx
+	tenantID string
+	lookup   *sql.Stmt
+	insert   *sql.Stmt
+	update   *sql.Stmt
+	history  *sql.Stmt
+}
+
+// BeginImport starts a transaction for importing records into tenantID.
+func (db *DB) BeginImport(tenantID string) (*ImportTx, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin import: %w", err)
+	}
+	t := &ImportTx{tx: tx, tenantID: tenantID}
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&t.lookup, "SELECT id FROM records WHERE tenant_id = ? AND record_key = ?"},
+		{&t.insert, `
+			INSERT INTO records (tenant_id, record_key, title, description, category, value, value_decimal, attributes,
+				source_key, job_id, source_line, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`},
+		{&t.update, `
+			UPDATE records SET title = ?, description = ?, category = ?, value = ?, value_decimal = ?, attributes = ?,
+				source_key = ?, job_id = ?, source_line = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`},
+		// Only versions whose data changes are kept, not every upload that
+		// repeats a record.
+		{&t.history, archiveRecords + `
+			WHERE id = ? AND NOT (title = ? AND description = ? AND category = ? AND value = ? AND value_decimal = ? AND attributes = ?)`},
+	} {
+		if *p.stmt, err = tx.Prepare(p.query); err != nil {
+			_ = t.Rollback()
+			return nil, fmt.Errorf("prepare import statement: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// UpsertRecord inserts or updates a record of the import's tenant, keyed by
+// r.RecordKey, and reports whether it was newly inserted. The attributes of
+// an existing record are replaced, not merged. When an update changes the
+// record's data, its previous version is saved in record_history.
+func (t *ImportTx) UpsertRecord(r Record) (bool, error) {
+	attrs, err := encodeAttributes(r.Attributes)
+	if err != nil {
+		return false, err
+	}
+	var id int64
+	err = t.lookup.QueryRow(t.tenantID, r.RecordKey).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if _, err := t.insert.Exec(t.tenantID, r.RecordKey, r.Title, r.Description, r.Category, r.Value, r.ValueDecimal, attrs, r.SourceKey, r.JobID, r.SourceLine); err != nil {
+			return false, fmt.Errorf("insert record %q: %w", r.RecordKey, err)
+		}
+		return true, nil
+	case err != nil:
+		return false, fmt.Errorf("look up record %q: %w", r.RecordKey, err)
+	}
+	if _, err := t.history.Exec(r.JobID, id, r.Title, r.Description, r.Category, r.Value, r.ValueDecimal, attrs); err != nil {
+		return false, fmt.Errorf("save history of record %q: %w", r.RecordKey, err)
+	}
+	if _, err := t.update.Exec(r.Title, r.Description, r.Category, r.Value, r.ValueDecimal, attrs, r.SourceKey, r.JobID, r.SourceLine, id); err != nil {
+		return false, fmt.Errorf("update record %q: %w", r.RecordKey, err)
+	}
+	return false, nil
+}
+
+// archiveRecords saves the records matched by the WHERE clause it is
+// completed with in record_history, as replaced by the job of its first
+// parameter.
+const archiveRecords = `
+	INSERT INTO record_history (record_id, tenant_id, record_key, title, description, category, value, value_decimal,
+		attributes, source_key, job_id, source_line, updated_at, replaced_by_job_id)
+	SELECT id, tenant_id, record_key, title, description, category, value, value_decimal,
+		attributes, source_key, job_id, source_line, updated_at, ?
+	FROM records`
+
+// DeleteMissing removes every record of the tenant whose key is not in keep,
+// saving its last version in record_history as replaced by jobID, and returns
+// the number of deleted records.
+func (t *ImportTx) DeleteMissing(keep map[string]struct{}, jobID int64) (int64, error) {
+	rows, err := t.tx.Query("SELECT id, record_key FROM records WHERE tenant_id = ?", t.tenantID)
 	if err != nil {
-		return fmt.Errorf("upsert record: %w", err)
+		return 0, fmt.Errorf("list record keys: %w", err)
+	}
+	var stale []int64
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scan record key: %w", err)
+		}
+		if _, ok := keep[key]; !ok {
+			stale = append(stale, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("list record keys: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("list record keys: %w", err)
+	}
+
+	for _, id := range stale {
+		if _, err := t.tx.Exec(archiveRecords+" WHERE id = ?", jobID, id); err != nil {
+			return 0, fmt.Errorf("save history of record %d: %w", id, err)
+		}
+		if _, err := t.tx.Exec("DELETE FROM records WHERE id = ?", id); err != nil {
+			return 0, fmt.Errorf("delete record %d: %w", id, err)
+		}
+	}
+	return int64(len(stale)), nil
+}
+
+func (t *ImportTx) closeStmts() {
+	for _, stmt := range []*sql.Stmt{t.lookup, t.insert, t.update, t.history} {
+		if stmt != nil {
+			_ = stmt.Close()
+		}
+	}
+}
+
+// Commit makes the import's changes permanent.
+func (t *ImportTx) Commit() error {
+	t.closeStmts()
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("commit import: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards the import's changes. It is safe to call after Commit.
+func (t *ImportTx) Rollback() error {
+	t.closeStmts()
+	if err := t.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("rollback import: %w", err)
 	}
 	return nil
 }
 
 // ListRecords returns all records for the given tenant_id, ordered by ID.
 func (db *DB) ListRecords(tenantID string) ([]Record, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, tenant_id, record_key, title, description, category, value, updated_at FROM records WHERE tenant_id = ? ORDER BY id", tenantID,
-	)
+	return db.FindRecords(tenantID, RecordFilter{})
+}
+
+// FindRecords returns the records of tenantID that match filter, ordered by ID.
+func (db *DB) FindRecords(tenantID string, filter RecordFilter) ([]Record, error) {
+	snippet, order := "''", " ORDER BY records.id"
+	if filter.Query != "" {
+		snippet = "snippet(records_fts, -1, '<mark>', '</mark>', '…', 12)"
+		order = " ORDER BY bm25(records_fts), records.id"
+	}
+	source, args := recordSource(tenantID, filter)
+	query := `SELECT records.id, tenant_id, record_key, records.title, records.description, category, value, value_decimal,
+			attributes, source_key, job_id, source_line, updated_at, ` + snippet + source
+	rows, err := db.conn.Query(query+order, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list records: %w", err)
 	}
@@ -229,14 +881,956 @@ func (db *DB) ListRecords(tenantID string) ([]Record, error) {
 	var records []Record
 	for rows.Next() {
 		var r Record
-		if err := rows.Scan(&r.ID, &r.TenantID, &r.RecordKey, &r.Title, &r.Description, &r.Category, &r.Value, &r.UpdatedAt); err != nil {
+		var attrs string
+		if err := rows.Scan(&r.ID, &r.TenantID, &r.RecordKey, &r.Title, &r.Description, &r.Category, &r.Value, &r.ValueDecimal, &attrs, &r.SourceKey, &r.JobID, &r.SourceLine, &r.UpdatedAt, &r.Snippet); err != nil {
 			return nil, fmt.Errorf("scan record: %w", err)
 		}
+		if err := json.Unmarshal([]byte(attrs), &r.Attributes); err != nil {
+			return nil, fmt.Errorf("decode attributes of record %d: %w", r.ID, err)
+		}
 		records = append(records, r)
 	}
 	return records, rows.Err()
 }
 
+// RecordStats returns the statistics of the records of tenantID matching
+// filter, or of those of every tenant when tenantID is empty: in total, and
+// per group of groupBy, ordered by group, unless groupBy is empty.
+func (db *DB) RecordStats(tenantID string, filter RecordFilter, groupBy string) (RecordStats, []RecordStats, error) {
+	source, args := recordSource(tenantID, filter)
+	query := func(group string) ([]RecordStats, error) {
+		// TOTAL is 0 rather than NULL without rows.
+		q := "SELECT " + group + ", COUNT(*), TOTAL(value), COALESCE(MIN(value), 0), COALESCE(MAX(value), 0), COALESCE(AVG(value), 0)" + source
+		if group != "''" {
+			q += " GROUP BY 1 ORDER BY 1"
+		}
+		rows, err := db.conn.Query(q, args...)
+		if err != nil {
+			return nil, fmt.Errorf("record stats: %w", err)
+		}
+		defer func() { _ = rows.Close() }()
+		var stats []RecordStats
+		for rows.Next() {
+			var s RecordStats
+			if err := rows.Scan(&s.Group, &s.Count, &s.Sum, &s.Min, &s.Max, &s.Avg); err != nil {
+				return nil, fmt.Errorf("scan record stats: %w", err)
+			}
+			stats = append(stats, s)
+		}
+		return stats, rows.Err()
+	}
+
+	total, err := query("''")
+	if err != nil {
+		return RecordStats{}, nil, err
+	}
+	if groupBy == "" {
+		return total[0], nil, nil
+	}
+	group, ok := statsGroups[groupBy]
+	if !ok {
+		return RecordStats{}, nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+	groups, err := query(group)
+	return total[0], groups, err
+}
+
+// recordSource returns the FROM and WHERE clauses, with their arguments,
+// that select the records of tenantID matching filter, or those of every
+// tenant when tenantID is empty.
+func recordSource(tenantID string, filter RecordFilter) (string, []any) {
+	from := " FROM records"
+	var conds []string
+	var args []any
+	if tenantID != "" {
+		conds = append(conds, "tenant_id = ?")
+		args = append(args, tenantID)
+	}
+	if filter.Query != "" {
+		from += " JOIN records_fts ON records_fts.rowid = records.id"
+		conds = append(conds, "records_fts MATCH ?")
+		args = append(args, filter.Query)
+	}
+	if filter.Key != "" {
+		conds = append(conds, "record_key = ?")
+		args = append(args, filter.Key)
+	}
+	for name, value := range filter.Attributes {
+		// json_each matches the name literally, so it needs no JSON path quoting.
+		conds = append(conds, "EXISTS (SELECT 1 FROM json_each(records.attributes) WHERE key = ? AND value = ?)")
+		args = append(args, name, value)
+	}
+	if len(conds) == 0 {
+		return from, args
+	}
+	return from + " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// ListRecordHistory returns the earlier versions of a tenant's record,
+// newest first.
+func (db *DB) ListRecordHistory(tenantID, recordKey string) ([]RecordVersion, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, record_key, title, description, category, value, value_decimal, attributes,
+			source_key, job_id, source_line, updated_at, replaced_at, replaced_by_job_id
+		FROM record_history WHERE tenant_id = ? AND record_key = ? ORDER BY id DESC`, tenantID, recordKey)
+	if err != nil {
+		return nil, fmt.Errorf("list history of record %q: %w", recordKey, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var versions []RecordVersion
+	for rows.Next() {
+		var v RecordVersion
+		var attrs string
+		if err := rows.Scan(&v.ID, &v.RecordKey, &v.Title, &v.Description, &v.Category, &v.Value, &v.ValueDecimal, &attrs,
+			&v.SourceKey, &v.JobID, &v.SourceLine, &v.UpdatedAt, &v.ReplacedAt, &v.ReplacedByJobID); err != nil {
+			return nil, fmt.Errorf("scan record version: %w", err)
+		}
+		if err := json.Unmarshal([]byte(attrs), &v.Attributes); err != nil {
+			return nil, fmt.Errorf("decode attributes of record version %d: %w", v.ID, err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// ListRecordChanges returns the records of a tenant that changed after
+// sequence since, in sequence order, up to limit of them. Each record is
+// listed once, at its latest change, so a record changed again later moves
+// to the end of the feed.
+func (db *DB) ListRecordChanges(tenantID string, since int64, limit int) ([]RecordChange, error) {
+	rows, err := db.conn.Query(`
+		SELECT c.seq, c.op, c.record_key, c.changed_at, r.id, r.title, r.description, r.category, r.value,
+			r.value_decimal, r.attributes, r.source_key, r.job_id, r.source_line, r.updated_at
+		FROM record_changes c
+		LEFT JOIN records r ON c.op != 'delete' AND r.tenant_id = c.tenant_id AND r.record_key = c.record_key
+		WHERE c.tenant_id = ? AND c.seq > ?
+			AND c.seq = (SELECT MAX(seq) FROM record_changes l WHERE l.tenant_id = c.tenant_id AND l.record_key = c.record_key)
+		ORDER BY c.seq LIMIT ?`, tenantID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list record changes: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var changes []RecordChange
+	for rows.Next() {
+		var c RecordChange
+		var id, jobID, sourceLine sql.NullInt64
+		var title, description, category, valueDecimal, attrs, sourceKey sql.NullString
+		var value sql.NullFloat64
+		var updated sql.NullTime
+		if err := rows.Scan(&c.Seq, &c.Op, &c.RecordKey, &c.ChangedAt, &id, &title, &description, &category, &value,
+			&valueDecimal, &attrs, &sourceKey, &jobID, &sourceLine, &updated); err != nil {
+			return nil, fmt.Errorf("scan record change: %w", err)
+		}
+		if id.Valid {
+			r := &Record{
+				ID: id.Int64, TenantID: tenantID, RecordKey: c.RecordKey,
+				Title: title.String, Description: description.String, Category: category.String,
+				Value: value.Float64, ValueDecimal: valueDecimal.String, SourceKey: sourceKey.String,
+				JobID: jobID.Int64, SourceLine: int(sourceLine.Int64), UpdatedAt: updated.Time,
+			}
+			if err := json.Unmarshal([]byte(attrs.String), &r.Attributes); err != nil {
+				return nil, fmt.Errorf("decode attributes of record %d: %w", r.ID, err)
+			}
+			c.Record = r
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// encodeAttributes returns the JSON stored in the attributes column.
+func encodeAttributes(attributes map[string]string) (string, error) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return "", fmt.Errorf("encode attributes: %w", err)
+	}
+	return string(raw), nil
+}
+
+// GetTenantSettings returns the ingestion settings stored for tenantID, or
+// the defaults when none have been saved.
+func (db *DB) GetTenantSettings(tenantID string) (TenantSettings, error) {
+	var raw string
+	err := db.conn.QueryRow("SELECT settings FROM tenant_settings WHERE tenant_id = ?", tenantID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultTenantSettings(), nil
+	}
+	if err != nil {
+		return TenantSettings{}, fmt.Errorf("get settings for tenant %s: %w", tenantID, err)
+	}
+	var s TenantSettings
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return TenantSettings{}, fmt.Errorf("decode settings for tenant %s: %w", tenantID, err)
+	}
+	return s.withDefaults(), nil
+}
+
+// SaveTenantSettings replaces the ingestion settings for tenantID.
+func (db *DB) SaveTenantSettings(tenantID string, s TenantSettings) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encode settings: %w", err)
+	}
+	_, err = db.conn.Exec(`
+		INSERT INTO tenant_settings (tenant_id, settings, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(tenant_id) DO UPDATE SET
+			settings=excluded.settings,
+			updated_at=CURRENT_TIMESTAMP`,
+		tenantID, string(raw),
+	)
+	if err != nil {
+		return fmt.Errorf("save settings for tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+// GetTenantSchema returns the column mapping stored for tenantID, or the
+// default schema when none has been saved.
+func (db *DB) GetTenantSchema(tenantID string) (TenantSchema, error) {
+	var raw string
+	err := db.conn.QueryRow("SELECT schema FROM tenant_schemas WHERE tenant_id = ?", tenantID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultTenantSchema(), nil
+	}
+	if err != nil {
+		return TenantSchema{}, fmt.Errorf("get schema for tenant %s: %w", tenantID, err)
+	}
+	var s TenantSchema
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return TenantSchema{}, fmt.Errorf("decode schema for tenant %s: %w", tenantID, err)
+	}
+	return s, nil
+}
+
+// SaveTenantSchema replaces the column mapping for tenantID.
+func (db *DB) SaveTenantSchema(tenantID string, s TenantSchema) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encode schema: %w", err)
+	}
+	_, err = db.conn.Exec(`
+		INSERT INTO tenant_schemas (tenant_id, schema, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(tenant_id) DO UPDATE SET
+			schema=excluded.schema,
+			updated_at=CURRENT_TIMESTAMP`,
+		tenantID, string(raw),
+	)
+	if err != nil {
+		return fmt.Errorf("save schema for tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+// DeleteTenantSchema removes the column mapping for tenantID so its files
+// are read with the default schema again.
+func (db *DB) DeleteTenantSchema(tenantID string) error {
+	if _, err := db.conn.Exec("DELETE FROM tenant_schemas WHERE tenant_id = ?", tenantID); err != nil {
+		return fmt.Errorf("delete schema for tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+// GetTenantPGPKeys returns the PGP keys of tenantID, or nil when it has none.
+func (db *DB) GetTenantPGPKeys(tenantID string) (*TenantPGPKeys, error) {
+	k := TenantPGPKeys{TenantID: tenantID}
+	err := db.conn.QueryRow(`
+		SELECT private_key, public_key, fingerprint, verify_key, verify_fingerprint, updated_at
+		FROM tenant_pgp_keys WHERE tenant_id = ?`, tenantID,
+	).Scan(&k.PrivateKey, &k.PublicKey, &k.Fingerprint, &k.VerifyKey, &k.VerifyFingerprint, &k.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get PGP keys for tenant %s: %w", tenantID, err)
+	}
+	return &k, nil
+}
+
+// SaveTenantPGPKeys replaces the PGP keys of k.TenantID.
+func (db *DB) SaveTenantPGPKeys(k *TenantPGPKeys) error {
+	err := db.conn.QueryRow(`
+		INSERT INTO tenant_pgp_keys (tenant_id, private_key, public_key, fingerprint, verify_key, verify_fingerprint, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(tenant_id) DO UPDATE SET
+			private_key=excluded.private_key,
+			public_key=excluded.public_key,
+			fingerprint=excluded.fingerprint,
+			verify_key=excluded.verify_key,
+			verify_fingerprint=excluded.verify_fingerprint,
+			updated_at=CURRENT_TIMESTAMP
+		RETURNING updated_at`,
+		k.TenantID, k.PrivateKey, k.PublicKey, k.Fingerprint, k.VerifyKey, k.VerifyFingerprint,
+	).Scan(&k.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("save PGP keys for tenant %s: %w", k.TenantID, err)
+	}
+	return nil
+}
+
+// DeleteTenantPGPKeys removes the PGP keys of tenantID.
+func (db *DB) DeleteTenantPGPKeys(tenantID string) error {
+	if _, err := db.conn.Exec("DELETE FROM tenant_pgp_keys WHERE tenant_id = ?", tenantID); err != nil {
+		return fmt.Errorf("delete PGP keys for tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+// GetIngestedObject returns what was last ingested from objectKey, or nil
+// when nothing was.
+func (db *DB) GetIngestedObject(tenantID, objectKey string) (*IngestedObject, error) {
+	o := IngestedObject{TenantID: tenantID, ObjectKey: objectKey}
+	err := db.conn.QueryRow(`
+		SELECT etag, sha256, import_id, ingested_at FROM ingested_objects
+		WHERE tenant_id = ? AND object_key = ?`, tenantID, objectKey,
+	).Scan(&o.ETag, &o.SHA256, &o.ImportID, &o.IngestedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get ingested object %s: %w", objectKey, err)
+	}
+	return &o, nil
+}
+
+// SaveIngestedObject records o as the last content ingested from its key.
+func (db *DB) SaveIngestedObject(o *IngestedObject) error {
+	err := db.conn.QueryRow(`
+		INSERT INTO ingested_objects (tenant_id, object_key, etag, sha256, import_id, ingested_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(tenant_id, object_key) DO UPDATE SET
+			etag=excluded.etag,
+			sha256=excluded.sha256,
+			import_id=excluded.import_id,
+			ingested_at=CURRENT_TIMESTAMP
+		RETURNING ingested_at`,
+		o.TenantID, o.ObjectKey, o.ETag, o.SHA256, o.ImportID,
+	).Scan(&o.IngestedAt)
+	if err != nil {
+		return fmt.Errorf("save ingested object %s: %w", o.ObjectKey, err)
+	}
+	return nil
+}
+
+const jobColumns = "id, kind, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at"
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var j Job
+	var payload string
+	if err := row.Scan(&j.ID, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.LastError, &j.RunAt, &j.CreatedAt, &j.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), &j.Payload); err != nil {
+		return nil, fmt.Errorf("decode payload of job %d: %w", j.ID, err)
+	}
+	return &j, nil
+}
+
+// sourceMatch selects the records written from an object key, including
+// those from the entries of an archive at that key, whose source keys
+// continue with a slash.
+const sourceMatch = "tenant_id = ? AND (source_key = ? OR (source_key > ? || '/' AND source_key < ? || '0'))"
+
+// RetractRecords deletes the records last written from objectKey, saving
+// their last versions in record_history as replaced by jobID, and the
+// ingestion record of the object so that uploading the same content again
+// imports it again. It returns the number of deleted records.
+func (db *DB) RetractRecords(tenantID, objectKey string, jobID int64) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("retract records of %s: %w", objectKey, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(archiveRecords+" WHERE "+sourceMatch, jobID, tenantID, objectKey, objectKey, objectKey); err != nil {
+		return 0, fmt.Errorf("save history of records of %s: %w", objectKey, err)
+	}
+	res, err := tx.Exec("DELETE FROM records WHERE "+sourceMatch, tenantID, objectKey, objectKey, objectKey)
+	if err != nil {
+		return 0, fmt.Errorf("retract records of %s: %w", objectKey, err)
+	}
+	n, _ := res.RowsAffected()
+	if _, err := tx.Exec("DELETE FROM ingested_objects WHERE tenant_id = ? AND object_key = ?", tenantID, objectKey); err != nil {
+		return 0, fmt.Errorf("retract records of %s: %w", objectKey, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("retract records of %s: %w", objectKey, err)
+	}
+	return n, nil
+}
+
+// RenameSource points the records and the ingestion record of oldKey at
+// newKey, after the object was renamed. It returns the number of records
+// that moved.
+func (db *DB) RenameSource(tenantID, oldKey, newKey string) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("rename %s: %w", oldKey, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	// substr counts characters, not bytes.
+	res, err := tx.Exec("UPDATE records SET source_key = ? || substr(source_key, ?) WHERE "+sourceMatch,
+		newKey, utf8.RuneCountInString(oldKey)+1, tenantID, oldKey, oldKey, oldKey)
+	if err != nil {
+		return 0, fmt.Errorf("rename %s: %w", oldKey, err)
+	}
+	n, _ := res.RowsAffected()
+	if _, err := tx.Exec("DELETE FROM ingested_objects WHERE tenant_id = ? AND object_key = ?", tenantID, newKey); err != nil {
+		return 0, fmt.Errorf("rename %s: %w", oldKey, err)
+	}
+	if _, err := tx.Exec("UPDATE ingested_objects SET object_key = ? WHERE tenant_id = ? AND object_key = ?", newKey, tenantID, oldKey); err != nil {
+		return 0, fmt.Errorf("rename %s: %w", oldKey, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("rename %s: %w", oldKey, err)
+	}
+	return n, nil
+}
+
+// LogTransfer appends t to its tenant's transfer log, filling in its ID and
+// time.
+func (db *DB) LogTransfer(t *Transfer) error {
+	err := db.conn.QueryRow(`
+		INSERT INTO transfer_log (tenant_id, action, path, target_path, size, status, protocol, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at`,
+		t.TenantID, t.Action, t.Path, t.TargetPath, t.Size, t.Status, t.Protocol, t.IP,
+	).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("log transfer of %s: %w", t.Path, err)
+	}
+	return nil
+}
+
+// ListTransfers returns the most recent entries of a tenant's transfer log,
+// newest first, restricted to one action unless action is empty.
+func (db *DB) ListTransfers(tenantID, action string, limit int) ([]Transfer, error) {
+	query := "SELECT id, tenant_id, action, path, target_path, size, status, protocol, ip, created_at FROM transfer_log WHERE tenant_id = ?"
+	args := []any{tenantID}
+	if action != "" {
+		query += " AND action = ?"
+		args = append(args, action)
+	}
+	rows, err := db.conn.Query(query+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("list transfers: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var transfers []Transfer
+	for rows.Next() {
+		var t Transfer
+		if err := rows.Scan(&t.ID, &t.TenantID, &t.Action, &t.Path, &t.TargetPath, &t.Size, &t.Status, &t.Protocol, &t.IP, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan transfer: %w", err)
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+const webhookColumns = "id, tenant_id, url, secret, events, created_at"
+
+// CreateWebhook stores a new webhook, filling in its ID and creation time.
+func (db *DB) CreateWebhook(hook *Webhook) error {
+	events, err := json.Marshal(hook.Events)
+	if err != nil {
+		return fmt.Errorf("encode webhook events: %w", err)
+	}
+	err = db.conn.QueryRow(
+		"INSERT INTO webhooks (tenant_id, url, secret, events) VALUES (?, ?, ?, ?) RETURNING id, created_at",
+		hook.TenantID, hook.URL, hook.Secret, string(events),
+	).Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhook retrieves a webhook by ID.
+func (db *DB) GetWebhook(id int64) (*Webhook, error) {
+	hook, err := scanWebhook(db.conn.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id))
+	if err != nil {
+		return nil, fmt.Errorf("get webhook %d: %w", id, err)
+	}
+	return hook, nil
+}
+
+// ListWebhooks returns all webhooks ordered by ID.
+func (db *DB) ListWebhooks() ([]Webhook, error) {
+	return db.queryWebhooks("SELECT " + webhookColumns + " FROM webhooks ORDER BY id")
+}
+
+// WebhooksFor returns the webhooks subscribed to event for tenantID: the
+// tenant's own and the global ones.
+func (db *DB) WebhooksFor(event, tenantID string) ([]Webhook, error) {
+	return db.queryWebhooks(`
+		SELECT `+webhookColumns+` FROM webhooks
+		WHERE (tenant_id = ? OR tenant_id = '')
+			AND EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = ?)
+		ORDER BY id`, tenantID, event)
+}
+
+// DeleteWebhook removes a webhook and its delivery log. Deliveries still
+// queued fail once their job runs.
+func (db *DB) DeleteWebhook(id int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("delete webhook %d: %w", id, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("delete webhook %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("delete webhook %d: %w", id, sql.ErrNoRows)
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return fmt.Errorf("delete webhook %d: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete webhook %d: %w", id, err)
+	}
+	return nil
+}
+
+// retiredWebhook matches the webhooks of deleted tenants that have no
+// delivery left to make.
+const retiredWebhook = `webhooks.tenant_id != ''
+	AND NOT EXISTS (SELECT 1 FROM tenants WHERE tenants.tenant_id = webhooks.tenant_id)
+	AND NOT EXISTS (SELECT 1 FROM webhook_deliveries WHERE webhook_id = webhooks.id AND status = 'pending')`
+
+// DeleteRetiredWebhook removes webhook id and its delivery log when its
+// tenant was deleted and none of its deliveries is pending any more.
+func (db *DB) DeleteRetiredWebhook(id int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("delete retired webhook %d: %w", id, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec("DELETE FROM webhooks WHERE id = ? AND "+retiredWebhook, id)
+	if err != nil {
+		return fmt.Errorf("delete retired webhook %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return fmt.Errorf("delete retired webhook %d: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete retired webhook %d: %w", id, err)
+	}
+	return nil
+}
+
+func (db *DB) queryWebhooks(query string, args ...any) ([]Webhook, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var hooks []Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	var hook Webhook
+	var events string
+	if err := row.Scan(&hook.ID, &hook.TenantID, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(events), &hook.Events); err != nil {
+		return nil, fmt.Errorf("decode events of webhook %d: %w", hook.ID, err)
+	}
+	return &hook, nil
+}
+
+const deliveryColumns = "id, webhook_id, event, payload, status, attempts, response_status, error, created_at, updated_at"
+
+// CreateDelivery stores a pending delivery, filling in its ID and times.
+func (db *DB) CreateDelivery(d *WebhookDelivery) error {
+	d.Status = DeliveryPending
+	err := db.conn.QueryRow(
+		"INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES (?, ?, ?) RETURNING id, created_at, updated_at",
+		d.WebhookID, d.Event, string(d.Payload),
+	).Scan(&d.ID, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// GetDelivery retrieves a webhook delivery by ID.
+func (db *DB) GetDelivery(id int64) (*WebhookDelivery, error) {
+	d, err := scanDelivery(db.conn.QueryRow("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ?", id))
+	if err != nil {
+		return nil, fmt.Errorf("get webhook delivery %d: %w", id, err)
+	}
+	return d, nil
+}
+
+// UpdateDelivery saves the outcome of the latest attempt at a delivery.
+func (db *DB) UpdateDelivery(d *WebhookDelivery) error {
+	_, err := db.conn.Exec(`
+		UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		d.Status, d.Attempts, d.ResponseStatus, d.Error, d.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update webhook delivery %d: %w", d.ID, err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent deliveries of a webhook, newest
+// first.
+func (db *DB) ListDeliveries(webhookID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := db.conn.Query("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook deliveries: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	if err := row.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.Error, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	return &d, nil
+}
+
+// EnqueueJob persists a new pending ingestion job that is due immediately.
+func (db *DB) EnqueueJob(payload map[string]any, maxAttempts int) (*Job, error) {
+	return db.EnqueueKindJob("", payload, maxAttempts)
+}
+
+// EnqueueKindJob persists a new pending job of the given kind that is due
+// immediately.
+func (db *DB) EnqueueKindJob(kind string, payload map[string]any, maxAttempts int) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode job payload: %w", err)
+	}
+	row := db.conn.QueryRow(
+		"INSERT INTO jobs (kind, payload, max_attempts) VALUES (?, ?, ?) RETURNING "+jobColumns,
+		kind, string(raw), maxAttempts,
+	)
+	job, err := scanJob(row)
+	if err != nil {
+		return nil, fmt.Errorf("insert job: %w", err)
+	}
+	return job, nil
+}
+
+// EnqueueUniqueJob persists a new pending ingestion job like EnqueueJob unless a job
+// with the same dedupeKey was ever enqueued, in which case it returns nil.
+func (db *DB) EnqueueUniqueJob(payload map[string]any, maxAttempts int, dedupeKey string) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode job payload: %w", err)
+	}
+	row := db.conn.QueryRow(
+		"INSERT INTO jobs (payload, max_attempts, dedupe_key) VALUES (?, ?, ?) ON CONFLICT DO NOTHING RETURNING "+jobColumns,
+		string(raw), maxAttempts, dedupeKey,
+	)
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("insert job: %w", err)
+	}
+	return job, nil
+}
+
+// GetJob retrieves a job by ID.
+func (db *DB) GetJob(id int64) (*Job, error) {
+	job, err := scanJob(db.conn.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+	if err != nil {
+		return nil, fmt.Errorf("get job %d: %w", id, err)
+	}
+	return job, nil
+}
+
+// ClaimJob marks the oldest due pending job as running, counts the attempt
+// and returns it. It returns nil when no job is due.
+func (db *DB) ClaimJob() (*Job, error) {
+	job, err := scanJob(db.conn.QueryRow(`
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'pending' AND run_at <= strftime('%Y-%m-%d %H:%M:%f', 'now')
+			ORDER BY run_at, id LIMIT 1
+		)
+		RETURNING ` + jobColumns))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+	return job, nil
+}
+
+// CompleteJob marks a job as done.
+func (db *DB) CompleteJob(id int64) error {
+	_, err := db.conn.Exec("UPDATE jobs SET status = 'done', last_error = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("complete job %d: %w", id, err)
+	}
+	return nil
+}
+
+// RetryJob puts a failed job back in the queue to run again after delay,
+// kept to the millisecond so that short backoffs are not rounded away.
+func (db *DB) RetryJob(id int64, delay time.Duration, lastErr string) error {
+	_, err := db.conn.Exec(`
+		UPDATE jobs SET status = 'pending', last_error = ?,
+			run_at = strftime('%Y-%m-%d %H:%M:%f', 'now', ?), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		lastErr, fmt.Sprintf("%+.3f seconds", delay.Seconds()), id,
+	)
+	if err != nil {
+		return fmt.Errorf("retry job %d: %w", id, err)
+	}
+	return nil
+}
+
+// BuryJob moves a job that will not be retried into the dead-letter state.
+func (db *DB) BuryJob(id int64, lastErr string) error {
+	_, err := db.conn.Exec("UPDATE jobs SET status = 'dead', last_error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", lastErr, id)
+	if err != nil {
+		return fmt.Errorf("bury job %d: %w", id, err)
+	}
+	return nil
+}
+
+// ReleaseJob returns a running job to the queue without counting the
+// attempt, for jobs interrupted by shutdown.
+func (db *DB) ReleaseJob(id int64) error {
+	_, err := db.conn.Exec(`
+		UPDATE jobs SET status = 'pending', attempts = MAX(attempts - 1, 0), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'running'`, id)
+	if err != nil {
+		return fmt.Errorf("release job %d: %w", id, err)
+	}
+	return nil
+}
+
+// RequeueRunningJobs returns every job left running by a previous process
+// to the queue and reports how many there were.
+func (db *DB) RequeueRunningJobs() (int64, error) {
+	res, err := db.conn.Exec("UPDATE jobs SET status = 'pending', updated_at = CURRENT_TIMESTAMP WHERE status = 'running'")
+	if err != nil {
+		return 0, fmt.Errorf("requeue running jobs: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// StartImport records the beginning of an import attempt for a job, marked
+// as a dry run from the start when dryRun is set.
+func (db *DB) StartImport(jobID int64, tenantID, objectKey string, size int64, etag string, dryRun bool) (*Import, error) {
+	imp, err := scanImport(db.conn.QueryRow(
+		"INSERT INTO imports (job_id, tenant_id, object_key, size, etag, dry_run) VALUES (?, ?, ?, ?, ?, ?) RETURNING "+importColumns,
+		jobID, tenantID, objectKey, size, etag, dryRun,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("insert import: %w", err)
+	}
+	return imp, nil
+}
+
+// StartChildImport records the beginning of the import of a file extracted
+// from the archive imported by parent; it is a dry run when parent is.
+func (db *DB) StartChildImport(parent *Import, objectKey string, size int64, etag string) (*Import, error) {
+	imp, err := scanImport(db.conn.QueryRow(
+		"INSERT INTO imports (job_id, parent_id, tenant_id, object_key, size, etag, dry_run) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING "+importColumns,
+		parent.JobID, parent.ID, parent.TenantID, objectKey, size, etag, parent.DryRun,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("insert import: %w", err)
+	}
+	return imp, nil
+}
+
+// SkipImport records that an upload was not imported because import
+// duplicateOf already ingested its content.
+func (db *DB) SkipImport(jobID int64, tenantID, objectKey string, size int64, etag string, duplicateOf int64) (*Import, error) {
+	imp, err := scanImport(db.conn.QueryRow(`
+		INSERT INTO imports (job_id, tenant_id, object_key, size, etag, status, duplicate_of, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP) RETURNING `+importColumns,
+		jobID, tenantID, objectKey, size, etag, ImportSkipped, duplicateOf,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("insert import: %w", err)
+	}
+	return imp, nil
+}
+
+// HasImport reports whether an import of objectKey with the given ETag was
+// ever started, whatever its outcome. Dry runs do not count, since they wrote
+// nothing.
+func (db *DB) HasImport(tenantID, objectKey, etag string) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM imports WHERE tenant_id = ? AND object_key = ? AND etag = ? AND dry_run = 0)",
+		tenantID, objectKey, etag,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("look up imports of %s: %w", objectKey, err)
+	}
+	return exists, nil
+}
+
+// FinishImport stores the final status, row counts and dry run flag of imp
+// and sets its finish time.
+func (db *DB) FinishImport(imp *Import) error {
+	var finished time.Time
+	err := db.conn.QueryRow(`
+		UPDATE imports SET status = ?, error = ?, rows_read = ?, rows_inserted = ?,
+			rows_updated = ?, rows_rejected = ?, rows_deleted = ?, dry_run = ?, finished_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING finished_at`,
+		imp.Status, imp.Error, imp.RowsRead, imp.RowsInserted,
+		imp.RowsUpdated, imp.RowsRejected, imp.RowsDeleted, imp.DryRun, imp.ID,
+	).Scan(&finished)
+	if err != nil {
+		return fmt.Errorf("finish import %d: %w", imp.ID, err)
+	}
+	imp.FinishedAt = &finished
+	return nil
+}
+
+// ListImports returns the most recent imports of a tenant, newest first.
+func (db *DB) ListImports(tenantID string, limit int) ([]Import, error) {
+	return db.queryImports("SELECT "+importColumns+" FROM imports WHERE tenant_id = ? ORDER BY id DESC LIMIT ?", tenantID, limit)
+}
+
+// ListJobImports returns the import attempts made by a job, oldest first.
+func (db *DB) ListJobImports(jobID int64) ([]Import, error) {
+	return db.queryImports("SELECT "+importColumns+" FROM imports WHERE job_id = ? ORDER BY id", jobID)
+}
+
+// AddImportErrors stores the rejected rows of an import.
+func (db *DB) AddImportErrors(importID int64, rowErrors []ImportError) error {
+	if len(rowErrors) == 0 {
+		return nil
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin import errors: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.Prepare("INSERT INTO import_errors (import_id, line, column_name, reason, raw) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("prepare import errors: %w", err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	for _, e := range rowErrors {
+		if _, err := stmt.Exec(importID, e.Line, e.Column, e.Reason, e.Raw); err != nil {
+			return fmt.Errorf("insert import error: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit import errors: %w", err)
+	}
+	return nil
+}
+
+// ListJobImportErrors returns the rejected rows of every import made by a
+// job, ordered by import and line.
+func (db *DB) ListJobImportErrors(jobID int64) ([]ImportError, error) {
+	rows, err := db.conn.Query(`
+		SELECT e.id, e.import_id, e.line, e.column_name, e.reason, e.raw
+		FROM import_errors e JOIN imports i ON i.id = e.import_id
+		WHERE i.job_id = ? ORDER BY e.import_id, e.line, e.id`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("list import errors: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var rowErrors []ImportError
+	for rows.Next() {
+		var e ImportError
+		if err := rows.Scan(&e.ID, &e.ImportID, &e.Line, &e.Column, &e.Reason, &e.Raw); err != nil {
+			return nil, fmt.Errorf("scan import error: %w", err)
+		}
+		rowErrors = append(rowErrors, e)
+	}
+	return rowErrors, rows.Err()
+}
+
+const importColumns = `id, job_id, parent_id, duplicate_of, dry_run, tenant_id, object_key, size, etag, status, error, rows_read,
+	rows_inserted, rows_updated, rows_rejected, rows_deleted, started_at, finished_at`
+
+func scanImport(row interface{ Scan(...any) error }) (*Import, error) {
+	var imp Import
+	var parent, duplicateOf sql.NullInt64
+	var finished sql.NullTime
+	if err := row.Scan(&imp.ID, &imp.JobID, &parent, &duplicateOf, &imp.DryRun, &imp.TenantID, &imp.ObjectKey, &imp.Size, &imp.ETag,
+		&imp.Status, &imp.Error, &imp.RowsRead, &imp.RowsInserted, &imp.RowsUpdated,
+		&imp.RowsRejected, &imp.RowsDeleted, &imp.StartedAt, &finished); err != nil {
+		return nil, err
+	}
+	if parent.Valid {
+		imp.ParentID = &parent.Int64
+	}
+	if duplicateOf.Valid {
+		imp.DuplicateOf = &duplicateOf.Int64
+	}
+	if finished.Valid {
+		imp.FinishedAt = &finished.Time
+	}
+	return &imp, nil
+}
+
+func (db *DB) queryImports(query string, args ...any) ([]Import, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list imports: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var imports []Import
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan import: %w", err)
+		}
+		imports = append(imports, *imp)
+	}
+	return imports, rows.Err()
+}
+
 // Close closes the underlying database connection.
 func (db *DB) Close() error {
 	return db.conn.Close()
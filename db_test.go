@@ -1,12 +1,21 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
+// newTestDB opens a throwaway database file. A file is used rather than
+// :memory: because every pooled connection to :memory: sees its own database.
 func newTestDB(t *testing.T) *DB {
 	t.Helper()
-	db, err := NewDB(":memory:")
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
 	if err != nil {
 		t.Fatalf("NewDB: %v", err)
 	}
@@ -196,6 +205,43 @@ func TestDeleteTenant(t *testing.T) {
 		t.Fatalf("CreateTenant: %v", err)
 	}
 
+	if err := db.SaveTenantSettings("tid123", TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	if err := db.SaveTenantSchema("tid123", DefaultTenantSchema()); err != nil {
+		t.Fatalf("SaveTenantSchema: %v", err)
+	}
+	if err := db.SaveTenantPGPKeys(&TenantPGPKeys{TenantID: "tid123", PrivateKey: "sealed", PublicKey: "public"}); err != nil {
+		t.Fatalf("SaveTenantPGPKeys: %v", err)
+	}
+	// done has finished its deliveries, pending has one to make and
+	// farewell waits for tenant.deleted.
+	done := &Webhook{TenantID: "tid123", URL: "http://example.com/done", Secret: "s", Events: []string{EventImportCompleted}}
+	pending := &Webhook{TenantID: "tid123", URL: "http://example.com/pending", Secret: "s", Events: []string{EventImportCompleted}}
+	farewell := &Webhook{TenantID: "tid123", URL: "http://example.com/farewell", Secret: "s", Events: []string{EventTenantDeleted}}
+	global := &Webhook{URL: "http://example.com/all", Secret: "s", Events: []string{EventImportCompleted}}
+	for _, hook := range []*Webhook{done, pending, farewell, global} {
+		if err := db.CreateWebhook(hook); err != nil {
+			t.Fatalf("CreateWebhook: %v", err)
+		}
+	}
+	var pendingDelivery *WebhookDelivery
+	for _, hook := range []*Webhook{done, pending, global} {
+		d := &WebhookDelivery{WebhookID: hook.ID, Event: EventImportCompleted, Payload: json.RawMessage(`{}`)}
+		if err := db.CreateDelivery(d); err != nil {
+			t.Fatalf("CreateDelivery: %v", err)
+		}
+		switch hook {
+		case done:
+			d.Status = DeliveryDelivered
+			if err := db.UpdateDelivery(d); err != nil {
+				t.Fatalf("UpdateDelivery: %v", err)
+			}
+		case pending:
+			pendingDelivery = d
+		}
+	}
+
 	username, err := db.DeleteTenant(tenant.ID)
 	if err != nil {
 		t.Fatalf("DeleteTenant: %v", err)
@@ -207,6 +253,48 @@ func TestDeleteTenant(t *testing.T) {
 	if _, err := db.GetTenant(tenant.ID); err == nil {
 		t.Error("expected error after deleting tenant")
 	}
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		if err := db.conn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatalf("count %s: %v", table, err)
+		}
+		return n
+	}
+	for _, table := range []string{"tenant_settings", "tenant_schemas", "tenant_pgp_keys"} {
+		if n := count(table); n != 0 {
+			t.Errorf("%s has %d rows, want none", table, n)
+		}
+	}
+	hooks, err := db.ListWebhooks()
+	if err != nil {
+		t.Fatalf("ListWebhooks: %v", err)
+	}
+	var urls []string
+	for _, hook := range hooks {
+		urls = append(urls, hook.URL)
+	}
+	want := []string{pending.URL, farewell.URL, global.URL}
+	if !reflect.DeepEqual(urls, want) || count("webhook_deliveries") != 2 {
+		t.Errorf("kept webhooks %v with %d deliveries, want %v with 2", urls, count("webhook_deliveries"), want)
+	}
+
+	// Once its last delivery is done, the webhook of the deleted tenant goes.
+	pendingDelivery.Status = DeliveryDelivered
+	if err := db.UpdateDelivery(pendingDelivery); err != nil {
+		t.Fatalf("UpdateDelivery: %v", err)
+	}
+	for _, hook := range []*Webhook{pending, global} {
+		if err := db.DeleteRetiredWebhook(hook.ID); err != nil {
+			t.Fatalf("DeleteRetiredWebhook: %v", err)
+		}
+	}
+	if _, err := db.GetWebhook(pending.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetWebhook of the retired webhook = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := db.GetWebhook(global.ID); err != nil {
+		t.Errorf("GetWebhook of the global webhook: %v", err)
+	}
 }
 
 func TestDeleteTenantNotFound(t *testing.T) {
@@ -273,6 +361,25 @@ func TestUpsertRecordUpdate(t *testing.T) {
 	}
 }
 
+func TestUpsertRecordKeepsHistory(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := db.UpsertRecord("tid1", "REC-001", "Original", "desc", "cat", 10.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if err := db.UpsertRecord("tid1", "REC-001", "Updated", "desc", "cat", 10.0); err != nil {
+		t.Fatalf("UpsertRecord (update): %v", err)
+	}
+
+	versions, err := db.ListRecordHistory("tid1", "REC-001")
+	if err != nil {
+		t.Fatalf("ListRecordHistory: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Title != "Original" {
+		t.Errorf("history = %+v, want the original version", versions)
+	}
+}
+
 func TestListRecordsIsolation(t *testing.T) {
 	db := newTestDB(t)
 
@@ -303,3 +410,573 @@ func TestListRecordsEmpty(t *testing.T) {
 		t.Errorf("expected nil slice for empty results, got %v", records)
 	}
 }
+
+func TestFindRecordsByAttribute(t *testing.T) {
+	db := newTestDB(t)
+
+	tx, err := db.BeginImport("tid1")
+	if err != nil {
+		t.Fatalf("BeginImport: %v", err)
+	}
+	rows := []struct {
+		key   string
+		attrs map[string]string
+	}{
+		{"R1", map[string]string{"region": "emea", "tier": "gold"}},
+		{"R2", map[string]string{"region": "emea", "tier": "silver"}},
+		{"R3", map[string]string{"region": "apac"}},
+		{"R4", nil},
+	}
+	for _, r := range rows {
+		if _, err := tx.UpsertRecord(Record{RecordKey: r.key, Title: "T", Value: 1, Attributes: r.attrs}); err != nil {
+			t.Fatalf("UpsertRecord %s: %v", r.key, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	tests := []struct {
+		filter map[string]string
+		want   []string
+	}{
+		{nil, []string{"R1", "R2", "R3", "R4"}},
+		{map[string]string{"region": "emea"}, []string{"R1", "R2"}},
+		{map[string]string{"region": "emea", "tier": "gold"}, []string{"R1"}},
+		{map[string]string{"region": "latam"}, nil},
+		{map[string]string{"missing": ""}, nil},
+	}
+	for _, tt := range tests {
+		records, err := db.FindRecords("tid1", RecordFilter{Attributes: tt.filter})
+		if err != nil {
+			t.Fatalf("FindRecords(%v): %v", tt.filter, err)
+		}
+		var keys []string
+		for _, r := range records {
+			keys = append(keys, r.RecordKey)
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("FindRecords(%v) = %v, want %v", tt.filter, keys, tt.want)
+		}
+	}
+
+	records, err := db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if records[0].Attributes["tier"] != "gold" || records[3].Attributes == nil {
+		t.Errorf("attributes = %v and %v, want tier gold and an empty map", records[0].Attributes, records[3].Attributes)
+	}
+}
+
+func TestNewDBAddsAttributesToExistingRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := conn.Exec(`
+		CREATE TABLE records (
+			id INTEGER PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			record_key TEXT NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			category TEXT NOT NULL DEFAULT '',
+			value REAL NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(tenant_id, record_key)
+		);
+		INSERT INTO records (tenant_id, record_key, title) VALUES ('tid1', 'R1', 'Old');
+	`); err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	_ = conn.Close()
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	records, err := db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].Attributes == nil || len(records[0].Attributes) != 0 {
+		t.Errorf("records = %+v, want R1 with empty attributes", records)
+	}
+}
+
+func TestTenantSettingsDefaults(t *testing.T) {
+	db := newTestDB(t)
+
+	settings, err := db.GetTenantSettings("tid1")
+	if err != nil {
+		t.Fatalf("GetTenantSettings: %v", err)
+	}
+	if settings.IngestMode != IngestIncremental {
+		t.Errorf("IngestMode = %q, want %q", settings.IngestMode, IngestIncremental)
+	}
+}
+
+func TestSaveTenantSettings(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := db.SaveTenantSettings("tid1", TenantSettings{IngestMode: IngestSnapshot}); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	settings, err := db.GetTenantSettings("tid1")
+	if err != nil {
+		t.Fatalf("GetTenantSettings: %v", err)
+	}
+	if settings.IngestMode != IngestSnapshot {
+		t.Errorf("IngestMode = %q, want %q", settings.IngestMode, IngestSnapshot)
+	}
+
+	other, err := db.GetTenantSettings("tid2")
+	if err != nil {
+		t.Fatalf("GetTenantSettings: %v", err)
+	}
+	if other.IngestMode != IngestIncremental {
+		t.Errorf("other tenant IngestMode = %q, want %q", other.IngestMode, IngestIncremental)
+	}
+}
+
+func TestTenantSchemaLifecycle(t *testing.T) {
+	db := newTestDB(t)
+
+	schema, err := db.GetTenantSchema("tid1")
+	if err != nil {
+		t.Fatalf("GetTenantSchema: %v", err)
+	}
+	if len(schema.Fields) != len(recordFields) {
+		t.Errorf("default schema maps %d fields, want %d", len(schema.Fields), len(recordFields))
+	}
+
+	custom := TenantSchema{Fields: map[string]SchemaField{
+		FieldKey:   {Source: "sku", Required: true},
+		FieldValue: {Source: "price", Default: "0"},
+	}}
+	if err := db.SaveTenantSchema("tid1", custom); err != nil {
+		t.Fatalf("SaveTenantSchema: %v", err)
+	}
+	schema, err = db.GetTenantSchema("tid1")
+	if err != nil {
+		t.Fatalf("GetTenantSchema: %v", err)
+	}
+	if !reflect.DeepEqual(schema, custom) {
+		t.Errorf("schema = %+v, want %+v", schema, custom)
+	}
+
+	if err := db.DeleteTenantSchema("tid1"); err != nil {
+		t.Fatalf("DeleteTenantSchema: %v", err)
+	}
+	schema, err = db.GetTenantSchema("tid1")
+	if err != nil {
+		t.Fatalf("GetTenantSchema: %v", err)
+	}
+	if schema.Fields[FieldKey].Source != "" {
+		t.Errorf("schema after delete = %+v, want the default", schema)
+	}
+}
+
+func TestImportTxDeleteMissing(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, key := range []string{"R1", "R2", "R3"} {
+		if err := db.UpsertRecord("tid1", key, "Title", "", "", 1.0); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+	if err := db.UpsertRecord("tid2", "R2", "Other tenant", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	tx, err := db.BeginImport("tid1")
+	if err != nil {
+		t.Fatalf("BeginImport: %v", err)
+	}
+	inserted, err := tx.UpsertRecord(Record{RecordKey: "R1", Title: "Updated", Value: 2.0})
+	if err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if inserted {
+		t.Error("R1 already existed and should be reported as updated")
+	}
+	inserted, err = tx.UpsertRecord(Record{RecordKey: "R4", Title: "New", Value: 4.0})
+	if err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if !inserted {
+		t.Error("R4 should be reported as inserted")
+	}
+	deleted, err := tx.DeleteMissing(map[string]struct{}{"R1": {}, "R4": {}}, 7)
+	if err != nil {
+		t.Fatalf("DeleteMissing: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted = %d, want 2", deleted)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	versions, err := db.ListRecordHistory("tid1", "R3")
+	if err != nil {
+		t.Fatalf("ListRecordHistory: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Title != "Title" || versions[0].ReplacedByJobID != 7 {
+		t.Errorf("history of R3 = %+v, want its last version deleted by job 7", versions)
+	}
+
+	records, err := db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 2 || records[0].Title != "Updated" {
+		t.Errorf("records = %+v, want updated R1 and new R4", records)
+	}
+	other, err := db.ListRecords("tid2")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(other) != 1 {
+		t.Errorf("expected other tenant's record to survive, got %d", len(other))
+	}
+}
+
+func TestImportTxRollback(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := db.UpsertRecord("tid1", "R1", "Original", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	tx, err := db.BeginImport("tid1")
+	if err != nil {
+		t.Fatalf("BeginImport: %v", err)
+	}
+	if _, err := tx.UpsertRecord(Record{RecordKey: "R1", Title: "Changed", Value: 2.0}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if _, err := tx.DeleteMissing(map[string]struct{}{}, 0); err != nil {
+		t.Fatalf("DeleteMissing: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	records, err := db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].Title != "Original" {
+		t.Errorf("records = %+v, want untouched original", records)
+	}
+}
+
+func TestImportTxRecordHistory(t *testing.T) {
+	db := newTestDB(t)
+
+	for i, r := range []Record{
+		{RecordKey: "R1", Title: "First", Value: 1, SourceKey: "tid1/a.csv", JobID: 1, SourceLine: 2},
+		{RecordKey: "R1", Title: "Second", Value: 1, SourceKey: "tid1/b.csv", JobID: 2, SourceLine: 5},
+		{RecordKey: "R1", Title: "Second", Value: 1, SourceKey: "tid1/c.csv", JobID: 3, SourceLine: 7},
+		{RecordKey: "R1", Title: "Second", Value: 3, Attributes: map[string]string{"region": "emea"}, SourceKey: "tid1/d.csv", JobID: 4, SourceLine: 9},
+	} {
+		tx, err := db.BeginImport("tid1")
+		if err != nil {
+			t.Fatalf("BeginImport: %v", err)
+		}
+		if _, err := tx.UpsertRecord(r); err != nil {
+			t.Fatalf("UpsertRecord %d: %v", i, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	records, err := db.FindRecords("tid1", RecordFilter{Key: "R1"})
+	if err != nil {
+		t.Fatalf("FindRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].SourceKey != "tid1/d.csv" || records[0].JobID != 4 || records[0].SourceLine != 9 {
+		t.Fatalf("records = %+v, want R1 from line 9 of tid1/d.csv", records)
+	}
+
+	// The upload that repeated the record unchanged left no version.
+	versions, err := db.ListRecordHistory("tid1", "R1")
+	if err != nil {
+		t.Fatalf("ListRecordHistory: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("versions = %+v, want 2", versions)
+	}
+	if v := versions[0]; v.Title != "Second" || v.Value != 1 || v.SourceKey != "tid1/c.csv" || v.JobID != 3 || v.SourceLine != 7 || v.ReplacedByJobID != 4 {
+		t.Errorf("newest version = %+v, want the one from job 3 replaced by job 4", v)
+	}
+	if v := versions[1]; v.Title != "First" || v.JobID != 1 || v.ReplacedByJobID != 2 || v.Attributes == nil {
+		t.Errorf("oldest version = %+v, want the one from job 1 replaced by job 2", v)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	db := newTestDB(t)
+
+	job, err := db.EnqueueJob(map[string]any{"username": "u1", "virtual_path": "/a.csv"}, 3)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if job.Status != JobPending || job.MaxAttempts != 3 {
+		t.Errorf("job = %+v, want pending with 3 max attempts", job)
+	}
+
+	claimed, err := db.ClaimJob()
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("claimed = %+v, want job %d", claimed, job.ID)
+	}
+	if claimed.Status != JobRunning || claimed.Attempts != 1 {
+		t.Errorf("claimed = %+v, want running with 1 attempt", claimed)
+	}
+	if claimed.Payload["virtual_path"] != "/a.csv" {
+		t.Errorf("payload = %v, want virtual_path /a.csv", claimed.Payload)
+	}
+
+	again, err := db.ClaimJob()
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if again != nil {
+		t.Errorf("expected no claimable job while running, got %+v", again)
+	}
+
+	if err := db.CompleteJob(job.ID); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+	got, err := db.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if got.Status != JobDone {
+		t.Errorf("status = %q, want %q", got.Status, JobDone)
+	}
+}
+
+func TestRetryJobKeepsMilliseconds(t *testing.T) {
+	db := newTestDB(t)
+
+	job, err := db.EnqueueJob(map[string]any{}, 3)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if _, err := db.ClaimJob(); err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if err := db.RetryJob(job.ID, 300*time.Millisecond, "boom"); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+	if claimed, err := db.ClaimJob(); err != nil || claimed != nil {
+		t.Fatalf("ClaimJob = %+v, %v, want the job delayed", claimed, err)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	claimed, err := db.ClaimJob()
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if claimed == nil || claimed.ID != job.ID {
+		t.Errorf("claimed %+v, want job %d once its delay passed", claimed, job.ID)
+	}
+}
+
+func TestRetryJobDelaysClaim(t *testing.T) {
+	db := newTestDB(t)
+
+	job, err := db.EnqueueJob(map[string]any{}, 3)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if _, err := db.ClaimJob(); err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if err := db.RetryJob(job.ID, time.Hour, "boom"); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+
+	claimed, err := db.ClaimJob()
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if claimed != nil {
+		t.Errorf("expected delayed job not to be claimable, got %+v", claimed)
+	}
+	got, err := db.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if got.Status != JobPending || got.LastError != "boom" {
+		t.Errorf("job = %+v, want pending with last error", got)
+	}
+}
+
+func TestRequeueRunningJobs(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.EnqueueJob(map[string]any{}, 3); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if _, err := db.ClaimJob(); err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+
+	n, err := db.RequeueRunningJobs()
+	if err != nil {
+		t.Fatalf("RequeueRunningJobs: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("requeued = %d, want 1", n)
+	}
+	claimed, err := db.ClaimJob()
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if claimed == nil || claimed.Attempts != 2 {
+		t.Errorf("claimed = %+v, want second attempt", claimed)
+	}
+}
+
+func TestHasImportIgnoresDryRuns(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.StartImport(1, "tid1", "tid1/data.csv", 10, "abc", true); err != nil {
+		t.Fatalf("StartImport: %v", err)
+	}
+	seen, err := db.HasImport("tid1", "tid1/data.csv", "abc")
+	if err != nil {
+		t.Fatalf("HasImport: %v", err)
+	}
+	if seen {
+		t.Error("HasImport counts a dry run")
+	}
+
+	if _, err := db.StartImport(2, "tid1", "tid1/data.csv", 10, "abc", false); err != nil {
+		t.Fatalf("StartImport: %v", err)
+	}
+	if seen, err = db.HasImport("tid1", "tid1/data.csv", "abc"); err != nil || !seen {
+		t.Errorf("HasImport = %v, %v, want the import found", seen, err)
+	}
+}
+
+func TestImportLifecycle(t *testing.T) {
+	db := newTestDB(t)
+
+	imp, err := db.StartImport(3, "tid1", "tid1/data.csv", 128, "abc", false)
+	if err != nil {
+		t.Fatalf("StartImport: %v", err)
+	}
+	if imp.Status != ImportRunning || imp.FinishedAt != nil {
+		t.Errorf("import = %+v, want running and unfinished", imp)
+	}
+
+	imp.Status = ImportCompleted
+	imp.RowsRead, imp.RowsInserted, imp.RowsUpdated, imp.RowsRejected = 10, 6, 3, 1
+	if err := db.FinishImport(imp); err != nil {
+		t.Fatalf("FinishImport: %v", err)
+	}
+
+	imports, err := db.ListImports("tid1", 10)
+	if err != nil {
+		t.Fatalf("ListImports: %v", err)
+	}
+	if len(imports) != 1 {
+		t.Fatalf("expected 1 import, got %d", len(imports))
+	}
+	got := imports[0]
+	if got.Status != ImportCompleted || got.RowsInserted != 6 || got.RowsRejected != 1 || got.FinishedAt == nil {
+		t.Errorf("import = %+v, want finished counts", got)
+	}
+	if got.JobID != 3 || got.Size != 128 || got.ETag != "abc" {
+		t.Errorf("import = %+v, want job 3, size 128, etag abc", got)
+	}
+}
+
+func TestListImportsNewestFirst(t *testing.T) {
+	db := newTestDB(t)
+
+	for i := 1; i <= 3; i++ {
+		if _, err := db.StartImport(int64(i), "tid1", "tid1/data.csv", 0, "", false); err != nil {
+			t.Fatalf("StartImport: %v", err)
+		}
+	}
+	if _, err := db.StartImport(9, "tid2", "tid2/data.csv", 0, "", false); err != nil {
+		t.Fatalf("StartImport: %v", err)
+	}
+
+	imports, err := db.ListImports("tid1", 2)
+	if err != nil {
+		t.Fatalf("ListImports: %v", err)
+	}
+	if len(imports) != 2 || imports[0].JobID != 3 || imports[1].JobID != 2 {
+		t.Errorf("imports = %+v, want jobs 3 and 2", imports)
+	}
+}
+
+func TestRecordStats(t *testing.T) {
+	db := newTestDB(t)
+	for _, r := range []struct {
+		tenant, key, category string
+		value                 float64
+		updated               string
+	}{
+		{"tid1", "R1", "a", 1, "2024-01-29 10:00:00"},
+		{"tid1", "R2", "a", 3, "2024-02-04 23:59:59"},
+		{"tid1", "R3", "b", 8, "2024-02-05 00:00:00"},
+		{"tid2", "R4", "a", 100, "2024-02-05 12:00:00"},
+	} {
+		if err := db.UpsertRecord(r.tenant, r.key, "Title", "", r.category, r.value); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+		if _, err := db.conn.Exec("UPDATE records SET updated_at = ? WHERE record_key = ?", r.updated, r.key); err != nil {
+			t.Fatalf("set updated_at: %v", err)
+		}
+	}
+
+	total, groups, err := db.RecordStats("tid1", RecordFilter{}, "")
+	if err != nil {
+		t.Fatalf("RecordStats: %v", err)
+	}
+	if want := (RecordStats{Count: 3, Sum: 12, Min: 1, Max: 8, Avg: 4}); total != want || groups != nil {
+		t.Errorf("RecordStats = %+v, %+v, want %+v and no groups", total, groups, want)
+	}
+
+	tests := []struct {
+		tenantID, groupBy string
+		want              []RecordStats
+	}{
+		{"tid1", GroupCategory, []RecordStats{{"a", 2, 4, 1, 3, 2}, {"b", 1, 8, 8, 8, 8}}},
+		{"tid1", GroupDay, []RecordStats{{"2024-01-29", 1, 1, 1, 1, 1}, {"2024-02-04", 1, 3, 3, 3, 3}, {"2024-02-05", 1, 8, 8, 8, 8}}},
+		{"tid1", GroupWeek, []RecordStats{{"2024-01-29", 2, 4, 1, 3, 2}, {"2024-02-05", 1, 8, 8, 8, 8}}},
+		{"tid1", GroupMonth, []RecordStats{{"2024-01", 1, 1, 1, 1, 1}, {"2024-02", 2, 11, 3, 8, 5.5}}},
+		{"", GroupTenant, []RecordStats{{"tid1", 3, 12, 1, 8, 4}, {"tid2", 1, 100, 100, 100, 100}}},
+	}
+	for _, tt := range tests {
+		_, groups, err := db.RecordStats(tt.tenantID, RecordFilter{}, tt.groupBy)
+		if err != nil {
+			t.Fatalf("RecordStats(%s): %v", tt.groupBy, err)
+		}
+		if fmt.Sprint(groups) != fmt.Sprint(tt.want) {
+			t.Errorf("RecordStats(%s) = %+v, want %+v", tt.groupBy, groups, tt.want)
+		}
+	}
+
+	total, _, err = db.RecordStats("tid3", RecordFilter{}, "")
+	if err != nil || total != (RecordStats{}) {
+		t.Errorf("RecordStats of no records = %+v, %v, want zeros", total, err)
+	}
+	if _, _, err := db.RecordStats("tid1", RecordFilter{}, "year"); err == nil {
+		t.Error("expected error for an unknown grouping")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// checkDuplicate returns the import that already ingested the content of
+// the object opened as obj, or 0 when its content is new. An unchanged ETag
+// is enough; when the ETag changed, for instance because the partner
+// overwrote the file with the same bytes, the object is hashed to tell the
+// two apart. Hashing reads obj, so the object is then reopened for the
+// import and file updated from the reopened object. The returned reader
+// replaces obj.
+func (w *Worker) checkDuplicate(ctx context.Context, file *upload, obj io.ReadCloser) (int64, io.ReadCloser, error) {
+	prev, err := w.db.GetIngestedObject(file.tenantID, file.objectKey)
+	if err != nil || prev == nil {
+		return 0, obj, err
+	}
+	if prev.ETag == file.etag {
+		return prev.ImportID, obj, nil
+	}
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, obj); err != nil {
+		return 0, obj, fmt.Errorf("hash %s: %w", file.objectKey, err)
+	}
+	_ = obj.Close()
+	if hex.EncodeToString(digest.Sum(nil)) == prev.SHA256 {
+		prev.ETag = file.etag
+		return prev.ImportID, io.NopCloser(strings.NewReader("")), w.db.SaveIngestedObject(prev)
+	}
+	obj, info, err := w.store.Open(ctx, file.objectKey)
+	if err != nil {
+		return 0, io.NopCloser(strings.NewReader("")), err
+	}
+	file.size, file.etag = info.Size, info.ETag
+	return 0, obj, nil
+}
+
+// recordIngested stores the ETag and SHA-256 of an object that imp ingested
+// successfully. Parsers may stop reading before the end of the object, so the
+// rest of r is read to complete digest.
+func (w *Worker) recordIngested(file upload, imp *Import, r io.Reader, digest hash.Hash) {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		log.Printf("worker: hash %s: %v", file.objectKey, err)
+		return
+	}
+	err := w.db.SaveIngestedObject(&IngestedObject{
+		TenantID:  file.tenantID,
+		ObjectKey: file.objectKey,
+		ETag:      file.etag,
+		SHA256:    hex.EncodeToString(digest.Sum(nil)),
+		ImportID:  imp.ID,
+	})
+	if err != nil {
+		log.Printf("worker: %v", err)
+	}
+}
+
+// uploadDedupeKey identifies the version of an object that the scanner and
+// bucket notifications enqueue, so that each version gets one job whichever
+// of them finds it first.
+func uploadDedupeKey(obj ObjectInfo) string {
+	return "upload:" + obj.Key + ":" + obj.ETag
+}
+
+// objectLocks serializes the jobs for one object. The hook, bucket
+// notifications and the scanner may all report the same upload; run one at
+// a time, the later jobs find it ingested and skip it.
+type objectLocks struct {
+	mu   sync.Mutex
+	held map[string]*objectLock
+}
+
+type objectLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until no other job holds key and returns the function that
+// releases it.
+func (l *objectLocks) lock(key string) (unlock func()) {
+	l.mu.Lock()
+	if l.held == nil {
+		l.held = make(map[string]*objectLock)
+	}
+	k := l.held[key]
+	if k == nil {
+		k = &objectLock{}
+		l.held[key] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		if k.refs--; k.refs == 0 {
+			delete(l.held, key)
+		}
+		l.mu.Unlock()
+	}
+}
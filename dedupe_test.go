@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestProcessUploadEventSkipsUnchangedContent(t *testing.T) {
+	w := newTenantTestWorker(t)
+	store := w.store.(memStore)
+	csv := "key,title,value\nR1,First,1\n"
+	store["tid1/data.csv"] = []byte(csv)
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/data.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	first, err := w.db.ListJobImports(1)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("ListJobImports = %+v, %v", first, err)
+	}
+	ingested, err := w.db.GetIngestedObject("tid1", "tid1/data.csv")
+	if err != nil || ingested == nil || ingested.ImportID != first[0].ID || len(ingested.SHA256) != 64 {
+		t.Fatalf("GetIngestedObject = %+v, %v, want import %d with a SHA-256", ingested, err, first[0].ID)
+	}
+
+	// The same event again is skipped.
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/data.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	assertSkipped(t, w, 2, first[0].ID)
+
+	// So is the same content under a new ETag, which is then remembered.
+	w.store = etagStore{store, "rewritten"}
+	if err := w.ProcessUploadEvent(context.Background(), 3, uploadEvent("/data.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	assertSkipped(t, w, 3, first[0].ID)
+	if ingested, _ := w.db.GetIngestedObject("tid1", "tid1/data.csv"); ingested.ETag != "rewritten" {
+		t.Errorf("ETag = %q, want the new ETag to be recorded", ingested.ETag)
+	}
+
+	// force imports it anyway.
+	event := uploadEvent("/data.csv")
+	event["force"] = true
+	if err := w.ProcessUploadEvent(context.Background(), 4, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	forced, _ := w.db.ListJobImports(4)
+	if len(forced) != 1 || forced[0].Status != ImportCompleted || forced[0].RowsUpdated != 1 {
+		t.Errorf("imports = %+v, want a completed import updating R1", forced)
+	}
+
+	// Changed content is imported.
+	w.store = store
+	store["tid1/data.csv"] = []byte("key,title,value\nR1,Changed,2\n")
+	if err := w.ProcessUploadEvent(context.Background(), 5, uploadEvent("/data.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	changed, _ := w.db.ListJobImports(5)
+	if len(changed) != 1 || changed[0].Status != ImportCompleted {
+		t.Errorf("imports = %+v, want a completed import", changed)
+	}
+	if ingested, _ := w.db.GetIngestedObject("tid1", "tid1/data.csv"); ingested.ImportID != changed[0].ID {
+		t.Errorf("ingested object = %+v, want import %d", ingested, changed[0].ID)
+	}
+}
+
+func TestProcessUploadEventRetriesFailedContent(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.store.(memStore)["tid1/data.csv"] = []byte("key,title,value\nR1,First,oops\n")
+
+	for jobID := int64(1); jobID <= 2; jobID++ {
+		if err := w.ProcessUploadEvent(context.Background(), jobID, uploadEvent("/data.csv")); !IsPermanent(err) {
+			t.Fatalf("job %d: expected permanent error, got %v", jobID, err)
+		}
+	}
+	if ingested, _ := w.db.GetIngestedObject("tid1", "tid1/data.csv"); ingested != nil {
+		t.Errorf("expected a failed import not to be recorded as ingested, got %+v", ingested)
+	}
+}
+
+func TestProcessUploadEventSkipsMovedUpload(t *testing.T) {
+	w := newDispositionTestWorker(t)
+	w.store.(memStore)["tid1/data.csv"] = []byte("key,title,value\nR1,First,1\n")
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/data.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/data.csv")); err != nil {
+		t.Errorf("expected a repeated event for a moved upload to be skipped, got %v", err)
+	}
+	event := uploadEvent("/data.csv")
+	event["force"] = true
+	if err := w.ProcessUploadEvent(context.Background(), 3, event); !IsPermanent(err) {
+		t.Errorf("expected forcing a moved upload to fail, got %v", err)
+	}
+}
+
+func assertSkipped(t *testing.T, w *Worker, jobID, duplicateOf int64) {
+	t.Helper()
+	imports, err := w.db.ListJobImports(jobID)
+	if err != nil {
+		t.Fatalf("ListJobImports: %v", err)
+	}
+	if len(imports) != 1 || imports[0].Status != ImportSkipped || imports[0].DuplicateOf == nil || *imports[0].DuplicateOf != duplicateOf {
+		t.Errorf("job %d imports = %+v, want one import skipped as a duplicate of %d", jobID, imports, duplicateOf)
+	}
+}
+
+// etagStore reports a fixed ETag for every object of a memStore.
+type etagStore struct {
+	memStore
+	etag string
+}
+
+func (s etagStore) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	r, info, err := s.memStore.Open(ctx, key)
+	info.ETag = s.etag
+	return r, info, err
+}
+
+func TestProcessUploadEventDryRun(t *testing.T) {
+	w := newDispositionTestWorker(t)
+	store := w.store.(memStore)
+	if err := w.db.UpsertRecord("tid1", "R1", "Original", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	store["tid1/good.csv"] = []byte("key,title,value\nR1,Changed,2\nR2,New,3\n")
+	store["tid1/bad.csv"] = []byte("key,title,value\nR3,Bad,x\n")
+
+	event := uploadEvent("/good.csv")
+	event["dry_run"] = true
+	if err := w.ProcessUploadEvent(context.Background(), 1, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	imports, _ := w.db.ListJobImports(1)
+	if len(imports) != 1 || !imports[0].DryRun || imports[0].Status != ImportCompleted || imports[0].RowsInserted != 1 || imports[0].RowsUpdated != 1 {
+		t.Errorf("imports = %+v, want a completed dry run inserting and updating one row", imports)
+	}
+
+	event = uploadEvent("/bad.csv")
+	event["dry_run"] = true
+	if err := w.ProcessUploadEvent(context.Background(), 2, event); !IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if rowErrors, _ := w.db.ListJobImportErrors(2); len(rowErrors) != 1 {
+		t.Errorf("import errors = %+v, want the rejected row", rowErrors)
+	}
+
+	records, _ := w.db.ListRecords("tid1")
+	if len(records) != 1 || records[0].Title != "Original" {
+		t.Errorf("records = %+v, want the dry runs to write nothing", records)
+	}
+	if len(store) != 2 {
+		t.Errorf("expected the uploads in place and no error report, got keys %v", keys(store))
+	}
+	if ingested, _ := w.db.GetIngestedObject("tid1", "tid1/good.csv"); ingested != nil {
+		t.Errorf("expected a dry run not to be recorded as ingested, got %+v", ingested)
+	}
+}
+
+func TestProcessUploadEventForcedImportOfMovedFile(t *testing.T) {
+	w := newDispositionTestWorker(t)
+	store := w.store.(memStore)
+	store["tid1/processed/data.csv"] = []byte("key,title,value\nR1,First,1\n")
+
+	event := uploadEvent("/processed/data.csv")
+	event["force"] = true
+	if err := w.ProcessUploadEvent(context.Background(), 1, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if records, _ := w.db.ListRecords("tid1"); len(records) != 1 {
+		t.Errorf("records = %+v, want R1", records)
+	}
+	if _, ok := store["tid1/processed/data.csv"]; !ok || len(store) != 1 {
+		t.Errorf("expected the file to stay in the processed folder, got keys %v", keys(store))
+	}
+}
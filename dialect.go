@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	// dialectSampleSize is how many leading bytes of a file are inspected to
+	// detect its dialect.
+	dialectSampleSize = 64 << 10
+	// dialectSampleLines is how many lines of the sample are compared when
+	// detecting the delimiter.
+	dialectSampleLines = 20
+	// delimiterCandidates are the delimiters detection chooses from, in
+	// order of preference when they score the same.
+	delimiterCandidates = ",;\t|"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Dialect describes how a delimited file is encoded and quoted.
+type Dialect struct {
+	Encoding  string
+	BOM       bool
+	Delimiter rune
+	Quote     rune
+}
+
+func (d Dialect) String() string {
+	return fmt.Sprintf("encoding %s (bom %t), delimiter %q, quote %q", d.Encoding, d.BOM, d.Delimiter, d.Quote)
+}
+
+// openDialect determines the dialect of r from the tenant's settings, detecting
+// whatever is set to DialectAuto from the first bytes of the file, and returns
+// the content transcoded to UTF-8 without a byte order mark. A byte order mark
+// overrides the configured encoding.
+//
+// encoding/csv only understands double quotes, so for single-quoted files the
+// returned reader swaps the two quote characters; values read through it must
+// be passed through Dialect.restore.
+func openDialect(r io.Reader, settings TenantSettings) (io.Reader, Dialect, error) {
+	br := bufio.NewReaderSize(r, dialectSampleSize)
+	sample, err := br.Peek(dialectSampleSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, Dialect{}, fmt.Errorf("read file sample: %w", err)
+	}
+	truncated := len(sample) == dialectSampleSize
+
+	d := Dialect{Encoding: settings.Encoding}
+	switch {
+	case bytes.HasPrefix(sample, bomUTF8):
+		d.Encoding, d.BOM = EncodingUTF8, true
+		_, _ = br.Discard(len(bomUTF8))
+		sample = sample[len(bomUTF8):]
+	case bytes.HasPrefix(sample, bomUTF16LE):
+		d.Encoding, d.BOM = EncodingUTF16LE, true
+	case bytes.HasPrefix(sample, bomUTF16BE):
+		d.Encoding, d.BOM = EncodingUTF16BE, true
+	case d.Encoding == "" || d.Encoding == DialectAuto:
+		d.Encoding = detectEncoding(sample, truncated)
+	}
+
+	var content io.Reader = br
+	text := string(sample)
+	if dec := decoder(d.Encoding); dec != nil {
+		content = transform.NewReader(br, dec)
+		// The sample may end inside a character; the replacement character
+		// this produces is harmless for detection.
+		decoded, _, _ := transform.String(decoder(d.Encoding), text)
+		text = decoded
+	}
+	if truncated {
+		// Only whole lines take part in detection.
+		if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+			text = text[:i]
+		}
+	}
+
+	switch settings.Quote {
+	case `"`, "'":
+		d.Quote = rune(settings.Quote[0])
+	default:
+		d.Quote = detectQuote(text)
+	}
+	switch settings.Delimiter {
+	case "", DialectAuto:
+		d.Delimiter = detectDelimiter(text, d.Quote)
+	default:
+		d.Delimiter = rune(settings.Delimiter[0])
+	}
+
+	if d.Quote == '\'' {
+		content = &quoteSwapper{r: content}
+	}
+	return content, d, nil
+}
+
+// restore undoes the quote swapping applied to single-quoted files.
+func (d Dialect) restore(s string) string {
+	if d.Quote != '\'' {
+		return s
+	}
+	return strings.Map(swapQuote, s)
+}
+
+// decoder returns the transcoder from encoding to UTF-8, or nil for UTF-8.
+func decoder(encoding string) transform.Transformer {
+	switch encoding {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()
+	case EncodingWindows1252:
+		return charmap.Windows1252.NewDecoder()
+	case EncodingISO88591:
+		return charmap.ISO8859_1.NewDecoder()
+	}
+	return nil
+}
+
+// detectEncoding guesses the encoding of a sample without a byte order mark.
+// UTF-16 shows up as NUL bytes in every other position; anything else that is
+// not valid UTF-8 is taken to be Windows-1252, the usual legacy export
+// encoding, which is a superset of the printable ISO-8859-1 range.
+func detectEncoding(sample []byte, truncated bool) string {
+	if len(sample) >= 2 {
+		var evenNUL, oddNUL int
+		for i, b := range sample {
+			if b != 0 {
+				continue
+			}
+			if i%2 == 0 {
+				evenNUL++
+			} else {
+				oddNUL++
+			}
+		}
+		half := len(sample) / 2
+		switch {
+		case oddNUL > half/2 && evenNUL == 0:
+			return EncodingUTF16LE
+		case evenNUL > half/2 && oddNUL == 0:
+			return EncodingUTF16BE
+		}
+	}
+	if truncated {
+		// Ignore a character cut off by the end of the sample.
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i]
+		}
+	}
+	if utf8.Valid(sample) {
+		return EncodingUTF8
+	}
+	return EncodingWindows1252
+}
+
+// detectQuote picks a single quote when it opens more fields than a double
+// quote does, and a double quote otherwise.
+func detectQuote(text string) rune {
+	opens := func(q byte) int {
+		n := 0
+		for i := 0; i < len(text); i++ {
+			if text[i] != q {
+				continue
+			}
+			if i == 0 || text[i-1] == '\n' || strings.IndexByte(delimiterCandidates, text[i-1]) >= 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if opens('\'') > opens('"') {
+		return '\''
+	}
+	return '"'
+}
+
+// detectDelimiter picks the candidate that splits the first lines of text
+// into the same number of fields, preferring the one that yields the most
+// fields. When no candidate is consistent, the one most frequent in the
+// header wins. Characters inside quoted fields are ignored.
+func detectDelimiter(text string, quote rune) rune {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) == dialectSampleLines {
+			break
+		}
+	}
+	if len(lines) == 0 {
+		return ','
+	}
+
+	best, bestCount, bestConsistent := ',', 0, false
+	for _, c := range delimiterCandidates {
+		first := countOutsideQuotes(lines[0], c, quote)
+		if first == 0 {
+			continue
+		}
+		consistent := true
+		for _, line := range lines[1:] {
+			if countOutsideQuotes(line, c, quote) != first {
+				consistent = false
+				break
+			}
+		}
+		if consistent && !bestConsistent || consistent == bestConsistent && first > bestCount {
+			best, bestCount, bestConsistent = c, first, consistent
+		}
+	}
+	return best
+}
+
+func countOutsideQuotes(line string, c, quote rune) int {
+	n := 0
+	quoted := false
+	for _, r := range line {
+		switch r {
+		case quote:
+			quoted = !quoted
+		case c:
+			if !quoted {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// quoteSwapper exchanges single and double quotes in the stream it reads.
+type quoteSwapper struct {
+	r io.Reader
+}
+
+func (q *quoteSwapper) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case '"':
+			p[i] = '\''
+		case '\'':
+			p[i] = '"'
+		}
+	}
+	return n, err
+}
+
+func swapQuote(r rune) rune {
+	switch r {
+	case '"':
+		return '\''
+	case '\'':
+		return '"'
+	}
+	return r
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenDialectFixtures(t *testing.T) {
+	tests := []struct {
+		file   string
+		want   Dialect
+		titles []string
+	}{
+		{
+			file:   "excel_utf8_bom_semicolon.csv",
+			want:   Dialect{Encoding: EncodingUTF8, BOM: true, Delimiter: ';', Quote: '"'},
+			titles: []string{"Crème brûlée", "Pâté en croûte", "Œufs mimosa"},
+		},
+		{
+			file:   "windows1252_semicolon.csv",
+			want:   Dialect{Encoding: EncodingWindows1252, Delimiter: ';', Quote: '"'},
+			titles: []string{"Müller Café", "Größe „XL“"},
+		},
+		{
+			file:   "latin1_comma.csv",
+			want:   Dialect{Encoding: EncodingWindows1252, Delimiter: ',', Quote: '"'},
+			titles: []string{"Jalapeño", "Año nuevo"},
+		},
+		{
+			file:   "tab_separated.csv",
+			want:   Dialect{Encoding: EncodingUTF8, Delimiter: '\t', Quote: '"'},
+			titles: []string{"Widget", "Gadget"},
+		},
+		{
+			file:   "excel_utf16le_tab.csv",
+			want:   Dialect{Encoding: EncodingUTF16LE, BOM: true, Delimiter: '\t', Quote: '"'},
+			titles: []string{"東京タワー", "富士山"},
+		},
+		{
+			file:   "single_quote_pipe.csv",
+			want:   Dialect{Encoding: EncodingUTF8, Delimiter: '|', Quote: '\''},
+			titles: []string{"O'Brien | Sons", `Say "hi"`},
+		},
+		{
+			file:   "comma_quoted_semicolons.csv",
+			want:   Dialect{Encoding: EncodingUTF8, Delimiter: ',', Quote: '"'},
+			titles: []string{"First", "Second"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "dialect", tt.file))
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+
+			_, got, err := openDialect(bytes.NewReader(data), DefaultTenantSettings())
+			if err != nil {
+				t.Fatalf("openDialect: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("dialect = %s, want %s", got, tt.want)
+			}
+
+			w := newTestWorker(t)
+			settings := TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()
+			stats, err := w.importCSV("tid1", bytes.NewReader(data), settings, DefaultTenantSchema())
+			if err != nil {
+				t.Fatalf("importCSV: %v", err)
+			}
+			if stats.Rejected != 0 {
+				t.Fatalf("rejected rows: %+v", stats.Errors)
+			}
+			records, err := w.db.ListRecords("tid1")
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			if len(records) != len(tt.titles) {
+				t.Fatalf("got %d records, want %d", len(records), len(tt.titles))
+			}
+			for i, r := range records {
+				if r.Title != tt.titles[i] {
+					t.Errorf("record %d title = %q, want %q", i, r.Title, tt.titles[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenDialectConfigured(t *testing.T) {
+	// Latin-1 "é" is 0xE9. Forcing UTF-8 leaves it undecoded, which shows
+	// the configured encoding wins over detection.
+	data := []byte("key;title;value\nR1;Caf\xe9;1\n")
+
+	settings := DefaultTenantSettings()
+	settings.Encoding = EncodingUTF8
+	settings.Delimiter = ","
+	settings.Quote = "'"
+	content, d, err := openDialect(bytes.NewReader(data), settings)
+	if err != nil {
+		t.Fatalf("openDialect: %v", err)
+	}
+	want := Dialect{Encoding: EncodingUTF8, Delimiter: ',', Quote: '\''}
+	if d != want {
+		t.Errorf("dialect = %s, want %s", d, want)
+	}
+	out, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("content = %q, want it unchanged", out)
+	}
+
+	// A byte order mark overrides the configured encoding.
+	settings.Encoding = EncodingWindows1252
+	_, d, err = openDialect(strings.NewReader("\ufeffkey,title,value\n"), settings)
+	if err != nil {
+		t.Fatalf("openDialect: %v", err)
+	}
+	if d.Encoding != EncodingUTF8 || !d.BOM {
+		t.Errorf("dialect = %s, want UTF-8 with BOM", d)
+	}
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want rune
+	}{
+		{"empty", "", ','},
+		{"single column", "key\nR1\nR2", ','},
+		{"comma", "a,b,c\n1,2,3", ','},
+		{"semicolon with decimal commas", "a;b;c\n1,5;2;3\n4;5,25;6", ';'},
+		{"pipe", "a|b\n1|2", '|'},
+		{"tab beats inconsistent comma", "a\tb\n1,1\t2\n3\t4,4,4", '\t'},
+		{"quoted delimiters ignored", "a,b\n\"x;y;z\",1", ','},
+		{"inconsistent falls back to header", "a;b;c\n1;2\n3", ';'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectDelimiter(tt.text, '"'); got != tt.want {
+				t.Errorf("detectDelimiter(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectEncodingLargeSample(t *testing.T) {
+	// A multi-byte character split by the end of the sample must not make
+	// a UTF-8 file look like Windows-1252.
+	line := strings.Repeat("é", 100) + "\n"
+	data := []byte(strings.Repeat(line, dialectSampleSize/len(line)+1))
+	sample := data[:dialectSampleSize]
+	if got := detectEncoding(sample, true); got != EncodingUTF8 {
+		t.Errorf("detectEncoding = %s, want %s", got, EncodingUTF8)
+	}
+}
+
+func TestSettingsValidateDialect(t *testing.T) {
+	for _, s := range []TenantSettings{
+		{Delimiter: "::"},
+		{Delimiter: "x"},
+		{Quote: "`"},
+		{Encoding: "ebcdic"},
+	} {
+		if err := s.withDefaults().Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want an error", s)
+		}
+	}
+	for _, s := range []TenantSettings{
+		{Delimiter: "\t", Quote: "'", Encoding: EncodingISO88591},
+		{Delimiter: ";", Encoding: EncodingUTF16BE},
+	} {
+		if err := s.withDefaults().Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", s, err)
+		}
+	}
+}
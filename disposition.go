@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// Disposition modes control what happens to an upload once it is ingested.
+const (
+	// DispositionKeep leaves uploads where the partner put them.
+	DispositionKeep = "keep"
+	// DispositionMove moves uploads into the processed or failed folder.
+	DispositionMove = "move"
+)
+
+// Date folder granularities for moved uploads.
+const (
+	DateFoldersDay   = "day"
+	DateFoldersMonth = "month"
+	DateFoldersNone  = "none"
+)
+
+// FileDisposition describes where uploads go once their import has finished,
+// so partners can see which files were consumed in their directory listing.
+type FileDisposition struct {
+	Mode string `json:"mode" enums:"keep,move"`
+	// ProcessedDir receives uploads that were imported, relative to the
+	// tenant's home directory.
+	ProcessedDir string `json:"processed_dir" example:"processed"`
+	// FailedDir receives uploads whose import failed for good.
+	FailedDir string `json:"failed_dir" example:"failed"`
+	// DateFolders groups moved uploads by the UTC day or month they were
+	// moved: processed/2024-01-31/data.csv or processed/2024-01/data.csv.
+	DateFolders string `json:"date_folders" enums:"day,month,none"`
+}
+
+// DefaultFileDisposition leaves uploads in place.
+func DefaultFileDisposition() FileDisposition {
+	return FileDisposition{
+		Mode:         DispositionKeep,
+		ProcessedDir: "processed",
+		FailedDir:    "failed",
+		DateFolders:  DateFoldersDay,
+	}
+}
+
+func (d FileDisposition) withDefaults() FileDisposition {
+	def := DefaultFileDisposition()
+	if d.Mode == "" {
+		d.Mode = def.Mode
+	}
+	if d.ProcessedDir == "" {
+		d.ProcessedDir = def.ProcessedDir
+	}
+	if d.FailedDir == "" {
+		d.FailedDir = def.FailedDir
+	}
+	if d.DateFolders == "" {
+		d.DateFolders = def.DateFolders
+	}
+	d.ProcessedDir = strings.Trim(d.ProcessedDir, "/")
+	d.FailedDir = strings.Trim(d.FailedDir, "/")
+	return d
+}
+
+// Validate reports whether the disposition is supported.
+func (d FileDisposition) Validate() error {
+	switch d.Mode {
+	case DispositionKeep, DispositionMove:
+	default:
+		return fmt.Errorf("file_disposition.mode must be %q or %q", DispositionKeep, DispositionMove)
+	}
+	switch d.DateFolders {
+	case DateFoldersDay, DateFoldersMonth, DateFoldersNone:
+	default:
+		return fmt.Errorf("file_disposition.date_folders must be %q, %q or %q", DateFoldersDay, DateFoldersMonth, DateFoldersNone)
+	}
+	for _, f := range []struct{ name, dir string }{{"processed_dir", d.ProcessedDir}, {"failed_dir", d.FailedDir}} {
+		if f.dir == "" || f.dir == "." || path.Clean(f.dir) != f.dir || f.dir == ".." || strings.HasPrefix(f.dir, "../") {
+			return fmt.Errorf("file_disposition.%s must be a relative folder such as %q", f.name, "processed")
+		}
+	}
+	if d.ProcessedDir == d.FailedDir {
+		return fmt.Errorf("file_disposition folders must differ")
+	}
+	return nil
+}
+
+// holds reports whether the upload at virtualPath lies in one of the folders
+// uploads are moved to. Such files were already ingested and are not imported
+// again.
+func (d FileDisposition) holds(virtualPath string) bool {
+	if d.Mode != DispositionMove {
+		return false
+	}
+	p := strings.TrimPrefix(virtualPath, "/")
+	return strings.HasPrefix(p, d.ProcessedDir+"/") || strings.HasPrefix(p, d.FailedDir+"/")
+}
+
+// target returns the path, relative to the tenant's home directory, that the
+// upload at virtualPath is moved to at time now.
+func (d FileDisposition) target(virtualPath string, failed bool, now time.Time) string {
+	dir := d.ProcessedDir
+	if failed {
+		dir = d.FailedDir
+	}
+	switch d.DateFolders {
+	case DateFoldersDay:
+		dir += "/" + now.UTC().Format("2006-01-02")
+	case DateFoldersMonth:
+		dir += "/" + now.UTC().Format("2006-01")
+	}
+	return dir + "/" + strings.TrimPrefix(virtualPath, "/")
+}
+
+// dispose moves an upload whose import has finished, together with its error
+// reports, into the tenant's processed or failed folder. The object is copied
+// before the original is removed, so a failure part-way leaves the upload in
+// place. Failures are logged rather than returned: the import itself is done
+// and retrying it would not help.
+func (w *Worker) dispose(ctx context.Context, file upload, d FileDisposition, failed bool) {
+	if d.Mode != DispositionMove {
+		return
+	}
+	targetKey := file.tenantID + "/" + d.target(file.name, failed, time.Now())
+
+	reports := []string{file.reportKey}
+	if isArchive(file.name) {
+		// Entry reports are named batch.zip.<entry>.errors.csv.
+		objects, err := w.store.List(ctx, file.objectKey+".")
+		if err != nil {
+			log.Printf("worker: %v", err)
+		}
+		reports = reports[:0]
+		for _, obj := range objects {
+			if strings.HasSuffix(obj.Key, errorReportSuffix) {
+				reports = append(reports, obj.Key)
+			}
+		}
+	}
+	if err := w.move(ctx, file.objectKey, targetKey); err != nil {
+		log.Printf("worker: %v", err)
+		return
+	}
+	for _, report := range reports {
+		err := w.move(ctx, report, targetKey+strings.TrimPrefix(report, file.objectKey))
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			log.Printf("worker: %v", err)
+		}
+	}
+	log.Printf("worker: moved %s to %s", file.objectKey, targetKey)
+}
+
+func (w *Worker) move(ctx context.Context, src, dst string) error {
+	if err := w.store.Copy(ctx, src, dst); err != nil {
+		return fmt.Errorf("move %s: %w", src, err)
+	}
+	if err := w.store.Remove(ctx, src); err != nil {
+		return fmt.Errorf("move %s: %w", src, err)
+	}
+	return nil
+}
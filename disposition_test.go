@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFileDispositionValidate(t *testing.T) {
+	valid := FileDisposition{Mode: DispositionMove, ProcessedDir: "/done/ok/", FailedDir: "done/bad"}.withDefaults()
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate(%+v): %v", valid, err)
+	}
+	if valid.ProcessedDir != "done/ok" || valid.DateFolders != DateFoldersDay {
+		t.Errorf("withDefaults = %+v, want trimmed folders and day folders", valid)
+	}
+
+	for _, d := range []FileDisposition{
+		{Mode: "archive"},
+		{DateFolders: "week"},
+		{ProcessedDir: "../up"},
+		{ProcessedDir: "a/../../b"},
+		{FailedDir: "."},
+		{ProcessedDir: "same", FailedDir: "same"},
+	} {
+		if err := d.withDefaults().Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected error", d)
+		}
+	}
+}
+
+func TestFileDispositionTarget(t *testing.T) {
+	now := time.Date(2024, 1, 31, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	d := DefaultFileDisposition()
+	tests := []struct {
+		dateFolders string
+		failed      bool
+		want        string
+	}{
+		{DateFoldersDay, false, "processed/2024-02-01/in/data.csv"},
+		{DateFoldersMonth, true, "failed/2024-02/in/data.csv"},
+		{DateFoldersNone, false, "processed/in/data.csv"},
+	}
+	for _, tt := range tests {
+		d.DateFolders = tt.dateFolders
+		if got := d.target("/in/data.csv", tt.failed, now); got != tt.want {
+			t.Errorf("target(%s, failed %t) = %s, want %s", tt.dateFolders, tt.failed, got, tt.want)
+		}
+	}
+}
+
+func newDispositionTestWorker(t *testing.T) *Worker {
+	t.Helper()
+	w := newTenantTestWorker(t)
+	settings := DefaultTenantSettings()
+	settings.FileDisposition.Mode = DispositionMove
+	settings.FileDisposition.DateFolders = DateFoldersNone
+	if err := w.db.SaveTenantSettings("tid1", settings); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	return w
+}
+
+func TestProcessUploadEventMovesUploads(t *testing.T) {
+	w := newDispositionTestWorker(t)
+	store := w.store.(memStore)
+	store["tid1/in/good.csv"] = []byte("key,title,value\nR1,First,1\n")
+	store["tid1/in/bad.csv"] = []byte("key,title,value\nR2,Second,oops\n")
+	store["tid1/notes.txt"] = []byte("hello")
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/in/good.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/in/bad.csv")); !IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 3, uploadEvent("/notes.txt")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	for _, key := range []string{"tid1/processed/in/good.csv", "tid1/failed/in/bad.csv", "tid1/failed/in/bad.csv.errors.csv", "tid1/notes.txt"} {
+		if _, ok := store[key]; !ok {
+			t.Errorf("expected %s, got keys %v", key, keys(store))
+		}
+	}
+	for _, key := range []string{"tid1/in/good.csv", "tid1/in/bad.csv", "tid1/in/bad.csv.errors.csv"} {
+		if _, ok := store[key]; ok {
+			t.Errorf("expected %s to be moved", key)
+		}
+	}
+
+	// Files in the processed folder are not ingested again.
+	if err := w.ProcessUploadEvent(context.Background(), 4, uploadEvent("/processed/in/good.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if imports, _ := w.db.ListJobImports(4); len(imports) != 0 {
+		t.Errorf("expected no import of a processed file, got %+v", imports)
+	}
+}
+
+func TestProcessUploadEventMovesArchiveReports(t *testing.T) {
+	w := newDispositionTestWorker(t)
+	store := w.store.(memStore)
+	store["tid1/batch.zip"] = zipBytes(t,
+		"a.csv", "key,title,value\nA1,First,1\n",
+		"b.csv", "key,title,value\nB1,Bad,x\n",
+	)
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/batch.zip")); !IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	for _, key := range []string{"tid1/failed/batch.zip", "tid1/failed/batch.zip.b.csv.errors.csv"} {
+		if _, ok := store[key]; !ok {
+			t.Errorf("expected %s, got keys %v", key, keys(store))
+		}
+	}
+	if len(store) != 2 {
+		t.Errorf("expected only the moved archive and its report, got keys %v", keys(store))
+	}
+}
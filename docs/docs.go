@@ -74,7 +74,7 @@ const docTemplate = `{
         },
         "/events/upload": {
             "post": {
-                "description": "Called by SFTPGo after a file upload. If the file is a .csv, it is asynchronously downloaded from S3 and parsed into the records table.",
+                "description": "Called by SFTPGo after a file is uploaded, downloaded, deleted or renamed. Every such event of a tenant is appended to its transfer log. Uploads, deletes and renames are then persisted as a job before responding: a worker pool downloads CSV, JSON, NDJSON and XLSX uploads from S3 and parses them into the records table, retracts the records of deleted files for tenants with retract_on_delete set, and follows renamed files, retrying transient failures. Only SFTPGo's event fields are queued, so import options such as force and dry_run cannot be passed here. Other events are ignored.",
                 "consumes": [
                     "application/json"
                 ],
@@ -84,7 +84,7 @@ const docTemplate = `{
                 "tags": [
                     "hooks"
                 ],
-                "summary": "SFTPGo upload event hook",
+                "summary": "SFTPGo file event hook",
                 "parameters": [
                     {
                         "description": "SFTPGo event payload",
@@ -107,6 +107,197 @@ const docTemplate = `{
                                 }
                             }
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/imports/{job_id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns an ingestion job with its retry state, every import attempt it made and the rows those attempts rejected, with line number, column, reason and raw content.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "imports"
+                ],
+                "summary": "Get ingestion job status",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "errors": {
+                                    "type": "array",
+                                    "items": {
+                                        "$ref": "#/definitions/main.ImportError"
+                                    }
+                                },
+                                "imports": {
+                                    "type": "array",
+                                    "items": {
+                                        "$ref": "#/definitions/main.Import"
+                                    }
+                                },
+                                "job": {
+                                    "$ref": "#/definitions/main.Job"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/imports/{job_id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Streams the progress of an ingestion job as server-sent events until the job is done or dead. \"progress\" events carry the row counts of the file being read every PROGRESS_ROWS rows (ImportProgress). Once a file was read, an \"error\" event is sent for each rejected row (ImportError), then an \"import\" event with the finished import (Import). A \"status\" event (JobStatus) ends each attempt; a pending job will be retried and the stream goes on. A client that falls behind may miss progress and error events, never import and status events. For a job that already finished, only its status is sent.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "imports"
+                ],
+                "summary": "Stream the progress of an ingestion job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.JobStatus"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/imports/{job_id}/reprocess": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Queues the upload event of an ingestion job again as a new job, for instance to re-run a failed import. Uploads whose content was already ingested are skipped as duplicates unless force is true.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "imports"
+                ],
+                "summary": "Reprocess an upload",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Import the upload even if its content was already ingested",
+                        "name": "force",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
                     }
                 }
             }
@@ -160,6 +351,74 @@ const docTemplate = `{
                 }
             }
         },
+        "/records/stats": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the count, sum, minimum, maximum and average of the value of the records of every tenant, in total and, with group_by, per tenant, category or day, week or month of their last update (UTC). The attr.\u003cname\u003e and q filters of the record listing apply.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "Get record statistics across tenants",
+                "parameters": [
+                    {
+                        "enum": [
+                            "tenant",
+                            "category",
+                            "day",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "Grouping of the records",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Full-text search of title and description",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "groups": {
+                                    "type": "array",
+                                    "items": {
+                                        "$ref": "#/definitions/main.RecordStats"
+                                    }
+                                },
+                                "total": {
+                                    "$ref": "#/definitions/main.RecordStats"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
         "/tenants": {
             "get": {
                 "security": [
@@ -319,7 +578,7 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Removes a tenant from both the local DB and SFTPGo.",
+                "description": "Removes a tenant from both the local DB and SFTPGo, together with its settings, schema, webhooks and PGP keys. The tenant's webhooks are removed once they have received the tenant.deleted event and any delivery still pending.",
                 "produces": [
                     "application/json"
                 ],
@@ -362,24 +621,21 @@ const docTemplate = `{
                 }
             }
         },
-        "/tenants/{id}/keys": {
-            "put": {
+        "/tenants/{id}/imports": {
+            "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Replaces the SSH public key for a tenant in both the local DB and SFTPGo.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Returns the most recent ingestion attempts for a tenant, newest first, with row counts and final status.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tenants"
+                    "imports"
                 ],
-                "summary": "Update tenant's SSH public key",
+                "summary": "List imports for a tenant",
                 "parameters": [
                     {
                         "type": "integer",
@@ -389,40 +645,19 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "New public key",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "type": "object",
-                            "properties": {
-                                "public_key": {
-                                    "type": "string"
-                                }
-                            }
-                        }
+                        "type": "integer",
+                        "description": "Maximum number of imports (default 50, max 500)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "properties": {
-                                "status": {
-                                    "type": "string"
-                                }
-                            }
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "type": "object",
-                            "properties": {
-                                "error": {
-                                    "type": "string"
-                                }
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Import"
                             }
                         }
                     },
@@ -438,23 +673,24 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/tenants/{id}/records": {
-            "get": {
+            },
+            "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Returns all records ingested from CSV uploads for a given tenant.",
+                "description": "Queues the import of a file already in the tenant's storage, as if SFTPGo had reported its upload, for instance after fixing the tenant's schema. The file is imported even if its content was ingested before. With dry_run the file is parsed and validated and the import records what it would have done, without writing records or error reports or moving the file.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "records"
+                    "imports"
                 ],
-                "summary": "List records for a tenant",
+                "summary": "Import a tenant file again",
                 "parameters": [
                     {
                         "type": "integer",
@@ -462,15 +698,1177 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Path of the file relative to the tenant's home directory",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "dry_run": {
+                                    "type": "boolean"
+                                },
+                                "path": {
+                                    "type": "string"
+                                }
+                            }
+                        }
                     }
                 ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.Job"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/keys": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replaces the SSH public key for a tenant in both the local DB and SFTPGo.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Update tenant's SSH public key",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New public key",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "public_key": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "status": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/pgp": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the public half and fingerprint of the key a tenant's partner encrypts uploads to, and the fingerprint of the key uploads must be signed with, if any. The private key is never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Get tenant PGP keys",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantPGPKeys"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stores the armored private key used to decrypt a tenant's .pgp, .gpg and .asc uploads, encrypted at rest under the server's master key. A passphrase-protected key is unlocked with passphrase, which is not stored. When verify_key, the partner's armored public key, is given, every upload must be signed with it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Replace tenant PGP keys",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Keys",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.PGPKeyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantPGPKeys"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes the PGP keys of a tenant. Encrypted uploads fail until new keys are stored.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Delete tenant PGP keys",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "status": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/records": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns all records ingested from uploads for a given tenant. Records can be filtered on their custom attributes with query parameters of the form attr.\u003cname\u003e=\u003cvalue\u003e, for example ?attr.region=emea; several filters must all match. With q, only records whose title or description contain every word are returned, most relevant first, each with a snippet of the matching text; \"quoted words\" match a phrase and a trailing * matches a prefix.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "List records for a tenant",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Full-text search of title and description",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Record"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/records/changes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the records of a tenant inserted, updated or deleted after the change sequence since, in sequence order, for incremental syncs. Each record appears once, at its latest change, with its current state; deleted records are tombstones without a record. Pass next as since to fetch the following page, until no changes are returned. Sequences only grow, and changes of the provenance alone, such as an upload repeating a record, are not listed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "List a tenant's record changes",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Sequence of the last change already synced (default 0, all records)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of changes (default 100, max 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "changes": {
+                                    "type": "array",
+                                    "items": {
+                                        "$ref": "#/definitions/main.RecordChange"
+                                    }
+                                },
+                                "next": {
+                                    "type": "integer"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/records/stats": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the count, sum, minimum, maximum and average of the value of a tenant's records, in total and, with group_by, per category or per day, week or month of their last update (UTC). Weeks are named by their Monday. The attr.\u003cname\u003e and q filters of the record listing apply.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "Get record statistics for a tenant",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "category",
+                            "day",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "Grouping of the records",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Full-text search of title and description",
+                        "name": "q",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "groups": {
+                                    "type": "array",
+                                    "items": {
+                                        "$ref": "#/definitions/main.RecordStats"
+                                    }
+                                },
+                                "total": {
+                                    "$ref": "#/definitions/main.RecordStats"
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/records/{key}/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a record of a tenant with the upload, job and line it was last written from, together with its earlier versions, newest first. A version is saved each time an import changes the record's data, and when a snapshot or a retraction deletes it, with the job that replaced or deleted it. The record is null once it was deleted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "records"
+                ],
+                "summary": "Get the history of a record",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Record key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "record": {
+                                    "$ref": "#/definitions/main.Record"
+                                },
+                                "versions": {
+                                    "type": "array",
+                                    "items": {
+                                        "$ref": "#/definitions/main.RecordVersion"
+                                    }
+                                }
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/schema": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the mapping from CSV columns to record fields for a tenant. Tenants that never saved a schema get the default, which reads each field from the column of the same name.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Get tenant CSV schema",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantSchema"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replaces the mapping from CSV columns to record fields for a tenant. Each entry of fields is keyed by a record field (key, title, description, category, value) and names its source column, whether it is required, a default value and transforms applied in order (trim, upper, lower, regex_extract with a pattern). key and value must be mapped and key must be required; unmapped fields are left empty.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Replace tenant CSV schema",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New schema",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantSchema"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes the custom schema of a tenant so its files are read with the default column names again.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Reset tenant CSV schema",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "status": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/settings": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the ingestion settings for a tenant. Tenants that never saved settings get the defaults.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Get tenant ingestion settings",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantSettings"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replaces the ingestion settings for a tenant. Omitted fields fall back to their defaults. ingest_mode \"snapshot\" deletes records missing from each uploaded file; \"incremental\" only upserts. import_policy \"atomic\" rolls a file back on its first bad row; \"best_effort\" skips bad rows and commits the rest in batches. ragged_rows decides whether rows with the wrong field count are rejected (\"reject\"), padded (\"pad\") or fail the file (\"fail\"); lazy_quotes accepts stray quotes in unquoted fields. delimiter (one of , ; | or a tab), quote (a double or single quote) and encoding (utf-8, utf-16le, utf-16be, windows-1252, iso-8859-1) default to \"auto\", which detects them from the start of each file; a byte order mark always decides the encoding. number_format sets the decimal and thousands separators, currency stripping, accounting negatives and percent handling of the value column; exact_decimals also stores each value as exact decimal text. sheet names the worksheet read from Excel uploads (the first one when empty).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Replace tenant ingestion settings",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New settings",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantSettings"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantSettings"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/transfers": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the most recent uploads, downloads, deletes and renames of a tenant's files reported by SFTPGo, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transfers"
+                ],
+                "summary": "List a tenant's transfer log",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "upload",
+                            "download",
+                            "delete",
+                            "rename"
+                        ],
+                        "type": "string",
+                        "description": "Only entries of this action",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of entries (default 50, max 500)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Transfer"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{id}/validate": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Checks whether a tenant's SFTP account is active and valid in SFTPGo.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Validate tenant in SFTPGo",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Tenant ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "username": {
+                                    "type": "string"
+                                },
+                                "valid": {
+                                    "type": "boolean"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns all registered webhooks, without their secrets.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List webhooks",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.Record"
+                                "$ref": "#/definitions/main.Webhook"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Subscribes a URL to events of one tenant, or of every tenant when tenant_id is empty. Events are posted as JSON with a signature in X-Webhook-Signature: \"sha256=\" and the hex HMAC-SHA256, keyed with the secret, of X-Webhook-Timestamp, a dot and the body. A secret is generated if none is given; it is only returned here.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Register a webhook",
+                "parameters": [
+                    {
+                        "description": "Webhook (url and events required)",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "events": {
+                                    "type": "array",
+                                    "items": {
+                                        "type": "string"
+                                    }
+                                },
+                                "secret": {
+                                    "type": "string"
+                                },
+                                "tenant_id": {
+                                    "type": "string"
+                                },
+                                "url": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Webhook"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a webhook by ID, without its secret.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get a webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Webhook"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a webhook and its delivery log. Deliveries still queued are dropped.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "status": {
+                                    "type": "string"
+                                }
                             }
                         }
                     },
@@ -488,63 +1886,310 @@ const docTemplate = `{
                 }
             }
         },
-        "/tenants/{id}/validate": {
-            "post": {
+        "/webhooks/{id}/deliveries": {
+            "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Checks whether a tenant's SFTP account is active and valid in SFTPGo.",
+                "description": "Returns the most recent deliveries of a webhook, newest first, with their payload, status, number of attempts and the outcome of the last one.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tenants"
+                    "webhooks"
                 ],
-                "summary": "Validate tenant in SFTPGo",
+                "summary": "List a webhook's deliveries",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Tenant ID",
+                        "description": "Webhook ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of deliveries (default 50, max 500)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.WebhookDelivery"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
                             "properties": {
-                                "username": {
+                                "error": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "error": {
                                     "type": "string"
-                                },
-                                "valid": {
-                                    "type": "boolean"
                                 }
                             }
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.APIKey": {
+        }
+    },
+    "definitions": {
+        "main.APIKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "label": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.FileDisposition": {
+            "type": "object",
+            "properties": {
+                "date_folders": {
+                    "description": "DateFolders groups moved uploads by the UTC day or month they were\nmoved: processed/2024-01-31/data.csv or processed/2024-01/data.csv.",
+                    "type": "string",
+                    "enum": [
+                        "day",
+                        "month",
+                        "none"
+                    ]
+                },
+                "failed_dir": {
+                    "description": "FailedDir receives uploads whose import failed for good.",
+                    "type": "string",
+                    "example": "failed"
+                },
+                "mode": {
+                    "type": "string",
+                    "enum": [
+                        "keep",
+                        "move"
+                    ]
+                },
+                "processed_dir": {
+                    "description": "ProcessedDir receives uploads that were imported, relative to the\ntenant's home directory.",
+                    "type": "string",
+                    "example": "processed"
+                }
+            }
+        },
+        "main.Import": {
+            "type": "object",
+            "properties": {
+                "dry_run": {
+                    "description": "DryRun marks an import that validated the file without writing\nrecords; its row counts say what the import would have done.",
+                    "type": "boolean"
+                },
+                "duplicate_of": {
+                    "description": "DuplicateOf is the import that already ingested the content of a\nskipped upload.",
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "etag": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "job_id": {
+                    "type": "integer"
+                },
+                "object_key": {
+                    "type": "string"
+                },
+                "parent_id": {
+                    "description": "ParentID is the import of the archive this file was extracted from.",
+                    "type": "integer"
+                },
+                "rows_deleted": {
+                    "type": "integer"
+                },
+                "rows_inserted": {
+                    "type": "integer"
+                },
+                "rows_read": {
+                    "type": "integer"
+                },
+                "rows_rejected": {
+                    "type": "integer"
+                },
+                "rows_updated": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tenant_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ImportError": {
+            "type": "object",
+            "properties": {
+                "column": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "import_id": {
+                    "type": "integer"
+                },
+                "line": {
+                    "type": "integer"
+                },
+                "raw": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Job": {
             "type": "object",
             "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
                 "created_at": {
                     "type": "string"
                 },
                 "id": {
                     "type": "integer"
                 },
-                "key": {
+                "kind": {
+                    "description": "Kind picks the handler of the job; it is empty for ingestion jobs.\nIt is set when the job is enqueued, never from its payload.",
+                    "type": "string",
+                    "example": "webhook"
+                },
+                "last_error": {
                     "type": "string"
                 },
-                "label": {
+                "max_attempts": {
+                    "type": "integer"
+                },
+                "payload": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "run_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.JobStatus": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "job_id": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "done",
+                        "dead"
+                    ]
+                }
+            }
+        },
+        "main.NumberFormat": {
+            "type": "object",
+            "properties": {
+                "accounting_negatives": {
+                    "description": "AccountingNegatives reads a number in parentheses as negative.",
+                    "type": "boolean"
+                },
+                "decimal_separator": {
+                    "description": "DecimalSeparator is \".\" or \",\".",
+                    "type": "string",
+                    "example": ","
+                },
+                "percent": {
+                    "type": "string",
+                    "enum": [
+                        "reject",
+                        "strip",
+                        "fraction"
+                    ]
+                },
+                "strip_currency": {
+                    "description": "StripCurrency removes currency symbols such as $ or € and three-letter\ncodes such as EUR before or after the number.",
+                    "type": "boolean"
+                },
+                "thousands_separator": {
+                    "description": "ThousandsSeparator groups digits in threes; empty means no grouping.\nA space also matches non-breaking spaces.",
+                    "type": "string",
+                    "example": "."
+                }
+            }
+        },
+        "main.PGPKeyRequest": {
+            "type": "object",
+            "properties": {
+                "passphrase": {
+                    "description": "Passphrase unlocks PrivateKey when it is protected. It is only used to\nunlock the key and is not stored.",
+                    "type": "string"
+                },
+                "private_key": {
+                    "description": "PrivateKey is the armored secret key that uploads are encrypted to.",
+                    "type": "string"
+                },
+                "verify_key": {
+                    "description": "VerifyKey is the partner's armored public key. When set, every upload\nmust be signed with it.",
                     "type": "string"
                 }
             }
@@ -552,6 +2197,13 @@ const docTemplate = `{
         "main.Record": {
             "type": "object",
             "properties": {
+                "attributes": {
+                    "description": "Attributes holds the CSV columns that are not mapped to a field above.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "category": {
                     "type": "string"
                 },
@@ -561,9 +2213,25 @@ const docTemplate = `{
                 "id": {
                     "type": "integer"
                 },
+                "job_id": {
+                    "description": "JobID is the ingestion job of that upload and SourceLine the line of\nthe file the record was read from.",
+                    "type": "integer"
+                },
                 "record_key": {
                     "type": "string"
                 },
+                "snippet": {
+                    "description": "Snippet is the matching text of a search result, with matches\nenclosed in \u003cmark\u003e tags.",
+                    "type": "string",
+                    "example": "Annual \u003cmark\u003einvoice\u003c/mark\u003e for EMEA"
+                },
+                "source_key": {
+                    "description": "SourceKey is the object key of the upload that last wrote the record;\nrecords from an archive name the archive entry, as in batch.zip/a.csv.",
+                    "type": "string"
+                },
+                "source_line": {
+                    "type": "integer"
+                },
                 "tenant_id": {
                     "type": "string"
                 },
@@ -575,6 +2243,139 @@ const docTemplate = `{
                 },
                 "value": {
                     "type": "number"
+                },
+                "value_decimal": {
+                    "description": "ValueDecimal is the exact decimal text of Value, stored for tenants\nwith exact decimals enabled.",
+                    "type": "string",
+                    "example": "1234.50"
+                }
+            }
+        },
+        "main.RecordChange": {
+            "type": "object",
+            "properties": {
+                "changed_at": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "insert",
+                        "update",
+                        "delete"
+                    ]
+                },
+                "record": {
+                    "description": "Record is the current state of an inserted or updated record, and\nnil for a deleted one.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Record"
+                        }
+                    ]
+                },
+                "record_key": {
+                    "type": "string"
+                },
+                "seq": {
+                    "description": "Seq orders the changes of all tenants and only ever grows.",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.RecordStats": {
+            "type": "object",
+            "properties": {
+                "avg": {
+                    "type": "number"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "group": {
+                    "description": "Group is the tenant ID or category of the group, or the first day\n(2024-01-29) or month (2024-01) of its time bucket.",
+                    "type": "string"
+                },
+                "max": {
+                    "type": "number"
+                },
+                "min": {
+                    "type": "number"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "main.RecordVersion": {
+            "type": "object",
+            "properties": {
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "category": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "job_id": {
+                    "type": "integer"
+                },
+                "record_key": {
+                    "type": "string"
+                },
+                "replaced_at": {
+                    "type": "string"
+                },
+                "replaced_by_job_id": {
+                    "type": "integer"
+                },
+                "source_key": {
+                    "type": "string"
+                },
+                "source_line": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "number"
+                },
+                "value_decimal": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.SchemaField": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "description": "Default is used when the column is absent or the value is empty\nafter transforms.",
+                    "type": "string"
+                },
+                "required": {
+                    "description": "Required rejects rows whose value is empty after transforms and the\ndefault are applied. A file without the column fails unless Default\nis set.",
+                    "type": "boolean"
+                },
+                "source": {
+                    "description": "Source is the header of the column holding the field, matched\ncase-insensitively. It defaults to the field name.",
+                    "type": "string"
+                },
+                "transforms": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.Transform"
+                    }
                 }
             }
         },
@@ -603,6 +2404,257 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "main.TenantPGPKeys": {
+            "type": "object",
+            "properties": {
+                "fingerprint": {
+                    "type": "string"
+                },
+                "public_key": {
+                    "description": "PublicKey is the public half of the decryption key, which partners\nencrypt their uploads to.",
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "verify_fingerprint": {
+                    "type": "string"
+                },
+                "verify_key": {
+                    "description": "VerifyKey is the partner's public key that uploads must be signed with.",
+                    "type": "string"
+                }
+            }
+        },
+        "main.TenantSchema": {
+            "type": "object",
+            "properties": {
+                "fields": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.SchemaField"
+                    }
+                }
+            }
+        },
+        "main.TenantSettings": {
+            "type": "object",
+            "properties": {
+                "delimiter": {
+                    "description": "Delimiter separates fields: one of , ; | or a tab, or \"auto\".",
+                    "type": "string",
+                    "example": "auto"
+                },
+                "encoding": {
+                    "type": "string",
+                    "enum": [
+                        "auto",
+                        "utf-8",
+                        "utf-16le",
+                        "utf-16be",
+                        "windows-1252",
+                        "iso-8859-1"
+                    ]
+                },
+                "exact_decimals": {
+                    "description": "ExactDecimals also stores each value as the decimal text it was written\nas, since the REAL value column cannot hold every amount exactly.",
+                    "type": "boolean"
+                },
+                "file_disposition": {
+                    "description": "FileDisposition moves uploads out of the way once they are ingested.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.FileDisposition"
+                        }
+                    ]
+                },
+                "import_policy": {
+                    "type": "string",
+                    "enum": [
+                        "atomic",
+                        "best_effort"
+                    ]
+                },
+                "ingest_mode": {
+                    "type": "string",
+                    "enum": [
+                        "incremental",
+                        "snapshot"
+                    ]
+                },
+                "lazy_quotes": {
+                    "description": "LazyQuotes accepts stray quotes inside unquoted fields as literal text\ninstead of rejecting the row.",
+                    "type": "boolean"
+                },
+                "number_format": {
+                    "description": "NumberFormat describes how the value column writes numbers.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.NumberFormat"
+                        }
+                    ]
+                },
+                "quote": {
+                    "description": "Quote encloses fields: a double or single quote, or \"auto\".",
+                    "type": "string",
+                    "example": "auto"
+                },
+                "ragged_rows": {
+                    "type": "string",
+                    "enum": [
+                        "reject",
+                        "pad",
+                        "fail"
+                    ]
+                },
+                "retract_on_delete": {
+                    "description": "RetractOnDelete deletes the records an upload wrote when the partner\ndeletes the file over SFTP.",
+                    "type": "boolean"
+                },
+                "sheet": {
+                    "description": "Sheet names the worksheet read from Excel uploads, matched without\nregard to case; empty reads the first sheet.",
+                    "type": "string"
+                }
+            }
+        },
+        "main.Transfer": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "enum": [
+                        "upload",
+                        "download",
+                        "delete",
+                        "rename"
+                    ]
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string",
+                    "example": "/in/data.csv"
+                },
+                "protocol": {
+                    "type": "string",
+                    "example": "SFTP"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "Status is SFTPGo's outcome: 1 for success, 2 for an error and 3 for\nan exceeded quota.",
+                    "type": "integer"
+                },
+                "target_path": {
+                    "description": "TargetPath is the new path of a renamed file.",
+                    "type": "string"
+                },
+                "tenant_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Transform": {
+            "type": "object",
+            "properties": {
+                "pattern": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "trim",
+                        "upper",
+                        "lower",
+                        "regex_extract"
+                    ]
+                }
+            }
+        },
+        "main.Webhook": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "import.completed",
+                        "import.failed"
+                    ]
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "secret": {
+                    "description": "Secret signs the deliveries. It is only returned when the webhook is\ncreated.",
+                    "type": "string"
+                },
+                "tenant_id": {
+                    "description": "TenantID restricts the webhook to the events of one tenant; empty\nsubscribes to the events of every tenant.",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/hooks/sftpgo"
+                }
+            }
+        },
+        "main.WebhookDelivery": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string",
+                    "example": "import.completed"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "payload": {
+                    "description": "Payload is the JSON body posted to the webhook.",
+                    "type": "object"
+                },
+                "response_status": {
+                    "description": "ResponseStatus is the HTTP status of the last attempt, zero when no\nresponse was received.",
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "delivered",
+                        "failed"
+                    ]
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "webhook_id": {
+                    "type": "integer"
+                }
+            }
         }
     },
     "securityDefinitions": {
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ProcessDeleteEvent handles an SFTPGo delete event. For tenants with
+// retract_on_delete set, the records last written from the deleted file, or
+// from the files of a deleted archive, are deleted, and uploading the same
+// content again imports it again. Other tenants keep their records. Since
+// the hook is not authenticated, nothing is retracted while the file is
+// still in the bucket.
+func (w *Worker) ProcessDeleteEvent(ctx context.Context, jobID int64, event map[string]any) error {
+	tenant, virtualPath, err := w.eventTenant(event)
+	if err != nil {
+		return err
+	}
+	settings, err := w.db.GetTenantSettings(tenant.TenantID)
+	if err != nil {
+		return err
+	}
+	if !settings.RetractOnDelete {
+		return nil
+	}
+	objectKey := tenant.TenantID + "/" + strings.TrimPrefix(virtualPath, "/")
+	defer w.locks.lock(objectKey)()
+
+	exists, err := w.objectExists(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return Permanent(fmt.Errorf("%s still exists, ignoring its delete event", objectKey))
+	}
+	n, err := w.db.RetractRecords(tenant.TenantID, objectKey, jobID)
+	if err != nil {
+		return err
+	}
+	log.Printf("worker: retracted %d records of deleted %s", n, objectKey)
+	return nil
+}
+
+// ProcessRenameEvent handles an SFTPGo rename event. When the file was
+// ingested under its old name, its records and ingestion record follow it to
+// the new name, so retracting and deduplicating keep working, provided the
+// file really is in the bucket under that name. Otherwise the file is
+// imported under its new name, which covers clients that upload to a
+// temporary name and rename the file once complete.
+func (w *Worker) ProcessRenameEvent(ctx context.Context, jobID int64, event map[string]any) error {
+	tenant, virtualPath, err := w.eventTenant(event)
+	if err != nil {
+		return err
+	}
+	targetPath, _ := event["virtual_target_path"].(string)
+	if targetPath == "" {
+		return Permanent(fmt.Errorf("missing virtual_target_path in rename event"))
+	}
+	oldKey := tenant.TenantID + "/" + strings.TrimPrefix(virtualPath, "/")
+	newKey := tenant.TenantID + "/" + strings.TrimPrefix(targetPath, "/")
+
+	unlock := w.locks.lock(oldKey)
+	prev, err := w.db.GetIngestedObject(tenant.TenantID, oldKey)
+	if err == nil && prev != nil {
+		var exists bool
+		if exists, err = w.objectExists(ctx, newKey); err == nil && !exists {
+			err = Permanent(fmt.Errorf("%s not found, ignoring the rename of %s", newKey, oldKey))
+		}
+	}
+	if err == nil && prev != nil {
+		var n int64
+		n, err = w.db.RenameSource(tenant.TenantID, oldKey, newKey)
+		if err == nil {
+			log.Printf("worker: %s renamed to %s, moved %d records", oldKey, newKey, n)
+		}
+	}
+	unlock()
+	if err != nil || prev != nil {
+		return err
+	}
+
+	upload := map[string]any{
+		"action":       TransferUpload,
+		"username":     tenant.Username,
+		"virtual_path": targetPath,
+	}
+	return w.ProcessUploadEvent(ctx, jobID, upload)
+}
+
+// objectExists reports whether the bucket holds an object at key.
+func (w *Worker) objectExists(ctx context.Context, key string) (bool, error) {
+	rc, _, err := w.store.Open(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("look up %s: %w", key, err)
+	}
+	_ = rc.Close()
+	return true, nil
+}
+
+// eventTenant returns the tenant named by an event's username and the
+// event's virtual_path.
+func (w *Worker) eventTenant(event map[string]any) (*Tenant, string, error) {
+	username, _ := event["username"].(string)
+	virtualPath, _ := event["virtual_path"].(string)
+	if username == "" || virtualPath == "" {
+		return nil, "", Permanent(fmt.Errorf("missing username or virtual_path in event"))
+	}
+	tenant, err := w.db.GetTenantByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", Permanent(fmt.Errorf("tenant %s not found", username))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return tenant, virtualPath, nil
+}
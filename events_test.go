@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func deleteEvent(path string) map[string]any {
+	return map[string]any{"action": "delete", "username": "user1", "virtual_path": path}
+}
+
+func renameEvent(from, to string) map[string]any {
+	return map[string]any{"action": "rename", "username": "user1", "virtual_path": from, "virtual_target_path": to}
+}
+
+func setRetractOnDelete(t *testing.T, w *Worker) {
+	t.Helper()
+	settings := DefaultTenantSettings()
+	settings.RetractOnDelete = true
+	if err := w.db.SaveTenantSettings("tid1", settings); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+}
+
+func recordKeys(t *testing.T, w *Worker) map[string]string {
+	t.Helper()
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	sources := make(map[string]string)
+	for _, r := range records {
+		sources[r.RecordKey] = r.SourceKey
+	}
+	return sources
+}
+
+func TestProcessJobRetractsDeletedUploads(t *testing.T) {
+	w := newTenantTestWorker(t)
+	store := w.store.(memStore)
+	store["tid1/a.csv"] = []byte("key,title,value\nA1,First,1\nA2,Second,2\n")
+	store["tid1/b.csv"] = []byte("key,title,value\nB1,Third,3\n")
+	store["tid1/batch.zip"] = zipBytes(t, "c.csv", "key,title,value\nC1,Fourth,4\n")
+	for i, p := range []string{"/a.csv", "/b.csv", "/batch.zip"} {
+		if err := w.ProcessUploadEvent(context.Background(), int64(i+1), uploadEvent(p)); err != nil {
+			t.Fatalf("ProcessUploadEvent(%s): %v", p, err)
+		}
+	}
+	if got := recordKeys(t, w); got["A1"] != "tid1/a.csv" || got["C1"] != "tid1/batch.zip/c.csv" {
+		t.Fatalf("record sources = %v", got)
+	}
+
+	// Without retract_on_delete the records stay.
+	if err := w.ProcessJob(context.Background(), &Job{ID: 4, Payload: deleteEvent("/a.csv")}); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	if got := recordKeys(t, w); len(got) != 4 {
+		t.Fatalf("records = %v, want all 4 kept", got)
+	}
+
+	setRetractOnDelete(t, w)
+	// A delete event for a file still in the bucket is not trusted.
+	err := w.ProcessJob(context.Background(), &Job{ID: 10, Payload: deleteEvent("/a.csv")})
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error for a file still in the bucket, got %v", err)
+	}
+	if got := recordKeys(t, w); len(got) != 4 {
+		t.Fatalf("records = %v, want all 4 kept", got)
+	}
+
+	delete(store, "tid1/a.csv")
+	delete(store, "tid1/batch.zip")
+	for i, p := range []string{"/a.csv", "/batch.zip"} {
+		if err := w.ProcessJob(context.Background(), &Job{ID: int64(5 + i), Payload: deleteEvent(p)}); err != nil {
+			t.Fatalf("ProcessJob: %v", err)
+		}
+	}
+	if got := recordKeys(t, w); len(got) != 1 || got["B1"] == "" {
+		t.Errorf("records = %v, want only B1", got)
+	}
+	versions, err := w.db.ListRecordHistory("tid1", "A1")
+	if err != nil {
+		t.Fatalf("ListRecordHistory: %v", err)
+	}
+	if len(versions) != 1 || versions[0].SourceKey != "tid1/a.csv" || versions[0].ReplacedByJobID != 5 {
+		t.Errorf("history of A1 = %+v, want its last version retracted by job 5", versions)
+	}
+
+	// The same content uploaded again is imported again.
+	store["tid1/a.csv"] = []byte("key,title,value\nA1,First,1\nA2,Second,2\n")
+	if err := w.ProcessUploadEvent(context.Background(), 7, uploadEvent("/a.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if got := recordKeys(t, w); len(got) != 3 {
+		t.Errorf("records = %v, want A1 and A2 back", got)
+	}
+}
+
+func TestProcessJobFollowsRenamedUploads(t *testing.T) {
+	w := newTenantTestWorker(t)
+	setRetractOnDelete(t, w)
+	store := w.store.(memStore)
+	store["tid1/a.csv"] = []byte("key,title,value\nA1,First,1\n")
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/a.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	// A rename to a name that is not in the bucket is not trusted.
+	err := w.ProcessJob(context.Background(), &Job{ID: 4, Payload: renameEvent("/a.csv", "/elsewhere.csv")})
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error for a rename to a missing file, got %v", err)
+	}
+	if got := recordKeys(t, w); got["A1"] != "tid1/a.csv" {
+		t.Fatalf("record sources = %v, want A1 still from tid1/a.csv", got)
+	}
+
+	store["tid1/done/a.csv"] = store["tid1/a.csv"]
+	delete(store, "tid1/a.csv")
+	if err := w.ProcessJob(context.Background(), &Job{ID: 2, Payload: renameEvent("/a.csv", "/done/a.csv")}); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	if got := recordKeys(t, w); got["A1"] != "tid1/done/a.csv" {
+		t.Errorf("record sources = %v, want A1 from tid1/done/a.csv", got)
+	}
+	if imports, _ := w.db.ListJobImports(2); len(imports) != 0 {
+		t.Errorf("expected a rename of an ingested file not to import it, got %+v", imports)
+	}
+
+	delete(store, "tid1/done/a.csv")
+	if err := w.ProcessJob(context.Background(), &Job{ID: 3, Payload: deleteEvent("/done/a.csv")}); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	if got := recordKeys(t, w); len(got) != 0 {
+		t.Errorf("records = %v, want none after deleting the renamed file", got)
+	}
+}
+
+func TestProcessJobImportsFilesRenamedFromTemporaryNames(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.store.(memStore)["tid1/b.csv"] = []byte("key,title,value\nB1,First,1\n")
+
+	if err := w.ProcessJob(context.Background(), &Job{ID: 1, Payload: renameEvent("/b.csv.filepart", "/b.csv")}); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	if got := recordKeys(t, w); got["B1"] != "tid1/b.csv" {
+		t.Errorf("record sources = %v, want B1 from tid1/b.csv", got)
+	}
+
+	if err := w.ProcessJob(context.Background(), &Job{ID: 2, Payload: map[string]any{"action": "rename", "username": "user1", "virtual_path": "/b.csv"}}); !IsPermanent(err) {
+		t.Errorf("expected permanent error for a rename without target, got %v", err)
+	}
+	if err := w.ProcessJob(context.Background(), &Job{ID: 3, Payload: map[string]any{"action": "mkdir", "username": "user1", "virtual_path": "/x"}}); err != nil {
+		t.Errorf("expected other actions to be ignored, got %v", err)
+	}
+}
@@ -2,14 +2,21 @@ package main
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Handlers groups HTTP handler methods and their dependencies.
@@ -17,9 +24,20 @@ type Handlers struct {
 	db     *DB
 	sftpgo *SFTPGoClient
 	cfg    Config
-	worker *Worker
+	queue  *Queue
+	vault  *keyVault
+	// webhooks is told about created and deleted tenants; nil sends
+	// nothing.
+	webhooks *Webhooks
+	// broker streams the progress of ingestion jobs; nil when ingestion is
+	// disabled.
+	broker *Broker
 }
 
+// sseKeepAlive is how often an idle event stream sends a comment, which
+// also rechecks whether its job finished.
+const sseKeepAlive = 15 * time.Second
+
 // CreateAPIKey godoc
 // @Summary Bootstrap a new API key
 // @Description Creates a new API key for authenticating subsequent requests. No auth required.
@@ -120,6 +138,8 @@ func (h *Handlers) CreateTenant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.webhooks.Emit(EventTenantCreated, tenant.TenantID, tenantEvent(tenant))
+
 	writeJSON(w, http.StatusCreated, map[string]any{
 		"tenant":    tenant,
 		"password":  req.Password,
@@ -181,7 +201,7 @@ func (h *Handlers) GetTenant(w http.ResponseWriter, r *http.Request) {
 
 // DeleteTenant godoc
 // @Summary Delete a tenant
-// @Description Removes a tenant from both the local DB and SFTPGo.
+// @Description Removes a tenant from both the local DB and SFTPGo, together with its settings, schema, webhooks and PGP keys. The tenant's webhooks are removed once they have received the tenant.deleted event and any delivery still pending.
 // @Tags tenants
 // @Produce json
 // @Security BearerAuth
@@ -199,11 +219,19 @@ func (h *Handlers) DeleteTenant(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
 		return
 	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
 	username, err := h.db.DeleteTenant(id)
 	if err != nil {
 		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
 		return
 	}
+	// The tenant's webhooks subscribed to the event outlive it until the
+	// event is delivered.
+	h.webhooks.Emit(EventTenantDeleted, tenant.TenantID, tenantEvent(tenant))
 	if err := h.sftpgo.DeleteUser(username); err != nil {
 		writeError(w, http.StatusBadGateway, fmt.Errorf("deleted from db but sftpgo failed: %w", err))
 		return
@@ -382,16 +410,17 @@ func (h *Handlers) UpdateTenantKeys(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
 
-// UploadEventHook godoc
-// @Summary SFTPGo upload event hook
-// @Description Called by SFTPGo after a file upload. If the file is a .csv, it is asynchronously downloaded from S3 and parsed into the records table.
+// FileEventHook godoc
+// @Summary SFTPGo file event hook
+// @Description Called by SFTPGo after a file is uploaded, downloaded, deleted or renamed. Every such event of a tenant is appended to its transfer log. Uploads, deletes and renames are then persisted as a job before responding: a worker pool downloads CSV, JSON, NDJSON and XLSX uploads from S3 and parses them into the records table, retracts the records of deleted files for tenants with retract_on_delete set, and follows renamed files, retrying transient failures. Only SFTPGo's event fields are queued, so import options such as force and dry_run cannot be passed here. Other events are ignored.
 // @Tags hooks
 // @Accept json
 // @Produce json
 // @Param body body object true "SFTPGo event payload"
 // @Success 200 {object} object{status=string}
+// @Failure 500 {object} object{error=string}
 // @Router /events/upload [post]
-func (h *Handlers) UploadEventHook(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) FileEventHook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
 		return
@@ -401,21 +430,78 @@ func (h *Handlers) UploadEventHook(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
 		return
 	}
-	log.Printf("upload event: %+v", event)
-	if h.worker != nil {
-		go h.worker.ProcessUploadEvent(event)
+	action, _ := event["action"].(string)
+	log.Printf("%s event: %+v", action, event)
+	switch action {
+	case TransferUpload, TransferDownload, TransferDelete, TransferRename:
+		h.logTransfer(action, event)
+	}
+	switch action {
+	case TransferUpload, TransferDelete, TransferRename:
+		if h.queue != nil {
+			job, err := h.queue.Enqueue(hookEvent(event))
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			log.Printf("%s event: queued as job %d", action, job.ID)
+		}
 	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// hookEventFields are the members of an SFTPGo event that are copied into
+// the job processing it.
+var hookEventFields = []string{"action", "username", "virtual_path", "virtual_target_path", "file_size", "status", "protocol", "ip", "timestamp"}
+
+// hookEvent returns the SFTPGo fields of an event posted to the hook. The
+// hook is not authenticated, so nothing else of the body may reach the job.
+func hookEvent(event map[string]any) map[string]any {
+	fields := make(map[string]any, len(hookEventFields))
+	for _, name := range hookEventFields {
+		if v, ok := event[name]; ok {
+			fields[name] = v
+		}
+	}
+	return fields
+}
+
+// logTransfer appends a file event to the transfer log of the tenant it
+// names. Events of unknown users are not logged, and a failure to log does
+// not fail the hook.
+func (h *Handlers) logTransfer(action string, event map[string]any) {
+	username, _ := event["username"].(string)
+	tenant, err := h.db.GetTenantByUsername(username)
+	if err != nil {
+		return
+	}
+	t := &Transfer{TenantID: tenant.TenantID, Action: action}
+	t.Path, _ = event["virtual_path"].(string)
+	t.TargetPath, _ = event["virtual_target_path"].(string)
+	t.Protocol, _ = event["protocol"].(string)
+	t.IP, _ = event["ip"].(string)
+	// JSON numbers decode as float64.
+	if size, ok := event["file_size"].(float64); ok {
+		t.Size = int64(size)
+	}
+	if status, ok := event["status"].(float64); ok {
+		t.Status = int(status)
+	}
+	if err := h.db.LogTransfer(t); err != nil {
+		log.Printf("%s event: %v", action, err)
+	}
+}
+
 // ListTenantRecords godoc
 // @Summary List records for a tenant
-// @Description Returns all records ingested from CSV uploads for a given tenant.
+// @Description Returns all records ingested from uploads for a given tenant. Records can be filtered on their custom attributes with query parameters of the form attr.<name>=<value>, for example ?attr.region=emea; several filters must all match. With q, only records whose title or description contain every word are returned, most relevant first, each with a snippet of the matching text; "quoted words" match a phrase and a trailing * matches a prefix.
 // @Tags records
 // @Produce json
 // @Security BearerAuth
 // @Param id path int true "Tenant ID"
+// @Param q query string false "Full-text search of title and description"
 // @Success 200 {array} Record
+// @Failure 400 {object} object{error=string}
 // @Failure 404 {object} object{error=string}
 // @Router /tenants/{id}/records [get]
 func (h *Handlers) ListTenantRecords(w http.ResponseWriter, r *http.Request) {
@@ -434,7 +520,12 @@ func (h *Handlers) ListTenantRecords(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
 		return
 	}
-	records, err := h.db.ListRecords(tenant.TenantID)
+	filter, err := parseRecordFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	records, err := h.db.FindRecords(tenant.TenantID, filter)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -445,6 +536,1071 @@ func (h *Handlers) ListTenantRecords(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, records)
 }
 
+// parseRecordFilter reads the attr.<name> and q parameters that narrow a
+// record listing or statistics.
+func parseRecordFilter(r *http.Request) (RecordFilter, error) {
+	var filter RecordFilter
+	if q := r.URL.Query().Get("q"); q != "" {
+		var err error
+		if filter.Query, err = ftsQuery(q); err != nil {
+			return filter, fmt.Errorf("invalid search: %w", err)
+		}
+	}
+	for param, values := range r.URL.Query() {
+		name, ok := strings.CutPrefix(param, "attr.")
+		if !ok {
+			continue
+		}
+		if name == "" {
+			return filter, errors.New("attribute filter needs a name")
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = make(map[string]string)
+		}
+		filter.Attributes[strings.ToLower(name)] = values[0]
+	}
+	return filter, nil
+}
+
+// GetTenantRecordStats godoc
+// @Summary Get record statistics for a tenant
+// @Description Returns the count, sum, minimum, maximum and average of the value of a tenant's records, in total and, with group_by, per category or per day, week or month of their last update (UTC). Weeks are named by their Monday. The attr.<name> and q filters of the record listing apply.
+// @Tags records
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param group_by query string false "Grouping of the records" Enums(category, day, week, month)
+// @Param q query string false "Full-text search of title and description"
+// @Success 200 {object} object{total=RecordStats,groups=[]RecordStats}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/records/stats [get]
+func (h *Handlers) GetTenantRecordStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("group_by") == GroupTenant {
+		http.Error(w, `{"error":"group_by must be category, day, week or month"}`, http.StatusBadRequest)
+		return
+	}
+	h.writeRecordStats(w, r, tenant.TenantID)
+}
+
+// GetRecordStats godoc
+// @Summary Get record statistics across tenants
+// @Description Returns the count, sum, minimum, maximum and average of the value of the records of every tenant, in total and, with group_by, per tenant, category or day, week or month of their last update (UTC). The attr.<name> and q filters of the record listing apply.
+// @Tags records
+// @Produce json
+// @Security BearerAuth
+// @Param group_by query string false "Grouping of the records" Enums(tenant, category, day, week, month)
+// @Param q query string false "Full-text search of title and description"
+// @Success 200 {object} object{total=RecordStats,groups=[]RecordStats}
+// @Failure 400 {object} object{error=string}
+// @Router /records/stats [get]
+func (h *Handlers) GetRecordStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	h.writeRecordStats(w, r, "")
+}
+
+// writeRecordStats responds with the statistics of the records of tenantID,
+// or of every tenant when it is empty, filtered and grouped as r asks.
+func (h *Handlers) writeRecordStats(w http.ResponseWriter, r *http.Request, tenantID string) {
+	groupBy := r.URL.Query().Get("group_by")
+	if _, ok := statsGroups[groupBy]; !ok && groupBy != "" {
+		http.Error(w, `{"error":"group_by must be tenant, category, day, week or month"}`, http.StatusBadRequest)
+		return
+	}
+	filter, err := parseRecordFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	total, groups, err := h.db.RecordStats(tenantID, filter, groupBy)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if groups == nil {
+		groups = []RecordStats{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total": total, "groups": groups})
+}
+
+// ListRecordChanges godoc
+// @Summary List a tenant's record changes
+// @Description Returns the records of a tenant inserted, updated or deleted after the change sequence since, in sequence order, for incremental syncs. Each record appears once, at its latest change, with its current state; deleted records are tombstones without a record. Pass next as since to fetch the following page, until no changes are returned. Sequences only grow, and changes of the provenance alone, such as an upload repeating a record, are not listed.
+// @Tags records
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param since query int false "Sequence of the last change already synced (default 0, all records)"
+// @Param limit query int false "Maximum number of changes (default 100, max 1000)"
+// @Success 200 {object} object{changes=[]RecordChange,next=int}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/records/changes [get]
+func (h *Handlers) ListRecordChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	var since int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || since < 0 {
+			http.Error(w, `{"error":"since must be a change sequence"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 1000 {
+			http.Error(w, `{"error":"limit must be between 1 and 1000"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	changes, err := h.db.ListRecordChanges(tenant.TenantID, since, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	next := since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+	if changes == nil {
+		changes = []RecordChange{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"changes": changes, "next": next})
+}
+
+// GetRecordHistory godoc
+// @Summary Get the history of a record
+// @Description Returns a record of a tenant with the upload, job and line it was last written from, together with its earlier versions, newest first. A version is saved each time an import changes the record's data, and when a snapshot or a retraction deletes it, with the job that replaced or deleted it. The record is null once it was deleted.
+// @Tags records
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param key path string true "Record key"
+// @Success 200 {object} object{record=Record,versions=[]RecordVersion}
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/records/{key}/history [get]
+func (h *Handlers) GetRecordHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	_, rest, _ := strings.Cut(r.URL.Path, "/records/")
+	key := strings.TrimSuffix(rest, "/history")
+	if key == "" {
+		http.Error(w, `{"error":"record key is required"}`, http.StatusBadRequest)
+		return
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	records, err := h.db.FindRecords(tenant.TenantID, RecordFilter{Key: key})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	versions, err := h.db.ListRecordHistory(tenant.TenantID, key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(records) == 0 && len(versions) == 0 {
+		http.Error(w, `{"error":"record not found"}`, http.StatusNotFound)
+		return
+	}
+	var record *Record
+	if len(records) > 0 {
+		record = &records[0]
+	}
+	if versions == nil {
+		versions = []RecordVersion{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"record": record, "versions": versions})
+}
+
+// GetTenantSettings godoc
+// @Summary Get tenant ingestion settings
+// @Description Returns the ingestion settings for a tenant. Tenants that never saved settings get the defaults.
+// @Tags tenants
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Success 200 {object} TenantSettings
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/settings [get]
+func (h *Handlers) GetTenantSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/settings")
+	id, err := parseID(path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	settings, err := h.db.GetTenantSettings(tenant.TenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// UpdateTenantSettings godoc
+// @Summary Replace tenant ingestion settings
+// @Description Replaces the ingestion settings for a tenant. Omitted fields fall back to their defaults. ingest_mode "snapshot" deletes records missing from each uploaded file; "incremental" only upserts. import_policy "atomic" rolls a file back on its first bad row; "best_effort" skips bad rows and commits the rest in batches. ragged_rows decides whether rows with the wrong field count are rejected ("reject"), padded ("pad") or fail the file ("fail"); lazy_quotes accepts stray quotes in unquoted fields. delimiter (one of , ; | or a tab), quote (a double or single quote) and encoding (utf-8, utf-16le, utf-16be, windows-1252, iso-8859-1) default to "auto", which detects them from the start of each file; a byte order mark always decides the encoding. number_format sets the decimal and thousands separators, currency stripping, accounting negatives and percent handling of the value column; exact_decimals also stores each value as exact decimal text. sheet names the worksheet read from Excel uploads (the first one when empty).
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param body body TenantSettings true "New settings"
+// @Success 200 {object} TenantSettings
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/settings [put]
+func (h *Handlers) UpdateTenantSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/settings")
+	id, err := parseID(path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	var req TenantSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	settings := req.withDefaults()
+	if err := settings.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	if err := h.db.SaveTenantSettings(tenant.TenantID, settings); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// GetTenantSchema godoc
+// @Summary Get tenant CSV schema
+// @Description Returns the mapping from CSV columns to record fields for a tenant. Tenants that never saved a schema get the default, which reads each field from the column of the same name.
+// @Tags tenants
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Success 200 {object} TenantSchema
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/schema [get]
+func (h *Handlers) GetTenantSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	tenant, ok := h.subresourceTenant(w, r, "/schema")
+	if !ok {
+		return
+	}
+	schema, err := h.db.GetTenantSchema(tenant.TenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// UpdateTenantSchema godoc
+// @Summary Replace tenant CSV schema
+// @Description Replaces the mapping from CSV columns to record fields for a tenant. Each entry of fields is keyed by a record field (key, title, description, category, value) and names its source column, whether it is required, a default value and transforms applied in order (trim, upper, lower, regex_extract with a pattern). key and value must be mapped and key must be required; unmapped fields are left empty.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param body body TenantSchema true "New schema"
+// @Success 200 {object} TenantSchema
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/schema [put]
+func (h *Handlers) UpdateTenantSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	var req TenantSchema
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tenant, ok := h.subresourceTenant(w, r, "/schema")
+	if !ok {
+		return
+	}
+	if err := h.db.SaveTenantSchema(tenant.TenantID, req); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+// DeleteTenantSchema godoc
+// @Summary Reset tenant CSV schema
+// @Description Removes the custom schema of a tenant so its files are read with the default column names again.
+// @Tags tenants
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Success 200 {object} object{status=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/schema [delete]
+func (h *Handlers) DeleteTenantSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	tenant, ok := h.subresourceTenant(w, r, "/schema")
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteTenantSchema(tenant.TenantID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reset"})
+}
+
+// GetTenantPGPKeys godoc
+// @Summary Get tenant PGP keys
+// @Description Returns the public half and fingerprint of the key a tenant's partner encrypts uploads to, and the fingerprint of the key uploads must be signed with, if any. The private key is never returned.
+// @Tags tenants
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Success 200 {object} TenantPGPKeys
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/pgp [get]
+func (h *Handlers) GetTenantPGPKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	tenant, ok := h.subresourceTenant(w, r, "/pgp")
+	if !ok {
+		return
+	}
+	keys, err := h.db.GetTenantPGPKeys(tenant.TenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if keys == nil {
+		http.Error(w, `{"error":"tenant has no PGP keys"}`, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// UpdateTenantPGPKeys godoc
+// @Summary Replace tenant PGP keys
+// @Description Stores the armored private key used to decrypt a tenant's .pgp, .gpg and .asc uploads, encrypted at rest under the server's master key. A passphrase-protected key is unlocked with passphrase, which is not stored. When verify_key, the partner's armored public key, is given, every upload must be signed with it.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param body body PGPKeyRequest true "Keys"
+// @Success 200 {object} TenantPGPKeys
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 503 {object} object{error=string}
+// @Router /tenants/{id}/pgp [put]
+func (h *Handlers) UpdateTenantPGPKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if h.vault == nil {
+		http.Error(w, `{"error":"PGP_MASTER_KEY is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+	var req PGPKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	tenant, ok := h.subresourceTenant(w, r, "/pgp")
+	if !ok {
+		return
+	}
+	keys, err := newTenantPGPKeys(h.vault, tenant.TenantID, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.db.SaveTenantPGPKeys(keys); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// DeleteTenantPGPKeys godoc
+// @Summary Delete tenant PGP keys
+// @Description Removes the PGP keys of a tenant. Encrypted uploads fail until new keys are stored.
+// @Tags tenants
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Success 200 {object} object{status=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/pgp [delete]
+func (h *Handlers) DeleteTenantPGPKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	tenant, ok := h.subresourceTenant(w, r, "/pgp")
+	if !ok {
+		return
+	}
+	if err := h.db.DeleteTenantPGPKeys(tenant.TenantID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// subresourceTenant resolves the tenant of a /api/tenants/{id}/<suffix>
+// request, writing the error response when it cannot.
+func (h *Handlers) subresourceTenant(w http.ResponseWriter, r *http.Request, suffix string) (*Tenant, bool) {
+	path := strings.TrimSuffix(r.URL.Path, suffix)
+	id, err := parseID(path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return tenant, true
+}
+
+// CreateTenantImport godoc
+// @Summary Import a tenant file again
+// @Description Queues the import of a file already in the tenant's storage, as if SFTPGo had reported its upload, for instance after fixing the tenant's schema. The file is imported even if its content was ingested before. With dry_run the file is parsed and validated and the import records what it would have done, without writing records or error reports or moving the file.
+// @Tags imports
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param body body object{path=string,dry_run=bool} true "Path of the file relative to the tenant's home directory"
+// @Success 202 {object} Job
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Failure 503 {object} object{error=string}
+// @Router /tenants/{id}/imports [post]
+func (h *Handlers) CreateTenantImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(strings.TrimSuffix(r.URL.Path, "/imports"), "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Path   string `json:"path"`
+		DryRun bool   `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Path) == "" {
+		http.Error(w, `{"error":"path is required"}`, http.StatusBadRequest)
+		return
+	}
+	// Cleaning a rooted path drops any .. that would leave the tenant's home.
+	virtualPath := path.Clean("/" + req.Path)
+	if virtualPath == "/" {
+		http.Error(w, `{"error":"path must name a file"}`, http.StatusBadRequest)
+		return
+	}
+	if h.queue == nil {
+		http.Error(w, `{"error":"ingestion is not enabled"}`, http.StatusServiceUnavailable)
+		return
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	event := map[string]any{
+		"action":       "upload",
+		"username":     tenant.Username,
+		"virtual_path": virtualPath,
+		"force":        true,
+	}
+	if req.DryRun {
+		event["dry_run"] = true
+	}
+	job, err := h.queue.Enqueue(event)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("manual import: %s of tenant %s queued as job %d (dry run %t)", virtualPath, tenant.TenantID, job.ID, req.DryRun)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// ListTenantImports godoc
+// @Summary List imports for a tenant
+// @Description Returns the most recent ingestion attempts for a tenant, newest first, with row counts and final status.
+// @Tags imports
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param limit query int false "Maximum number of imports (default 50, max 500)"
+// @Success 200 {array} Import
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/imports [get]
+func (h *Handlers) ListTenantImports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/imports")
+	id, err := parseID(path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, `{"error":"limit must be between 1 and 500"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	imports, err := h.db.ListImports(tenant.TenantID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if imports == nil {
+		imports = []Import{}
+	}
+	writeJSON(w, http.StatusOK, imports)
+}
+
+// ListTenantTransfers godoc
+// @Summary List a tenant's transfer log
+// @Description Returns the most recent uploads, downloads, deletes and renames of a tenant's files reported by SFTPGo, newest first.
+// @Tags transfers
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Tenant ID"
+// @Param action query string false "Only entries of this action" Enums(upload, download, delete, rename)
+// @Param limit query int false "Maximum number of entries (default 50, max 500)"
+// @Success 200 {array} Transfer
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /tenants/{id}/transfers [get]
+func (h *Handlers) ListTenantTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/transfers")
+	id, err := parseID(path, "/api/tenants/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	action := r.URL.Query().Get("action")
+	switch action {
+	case "", TransferUpload, TransferDownload, TransferDelete, TransferRename:
+	default:
+		http.Error(w, `{"error":"action must be upload, download, delete or rename"}`, http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, `{"error":"limit must be between 1 and 500"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	tenant, err := h.db.GetTenant(id)
+	if err != nil {
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+		return
+	}
+	transfers, err := h.db.ListTransfers(tenant.TenantID, action, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if transfers == nil {
+		transfers = []Transfer{}
+	}
+	writeJSON(w, http.StatusOK, transfers)
+}
+
+// GetImport godoc
+// @Summary Get ingestion job status
+// @Description Returns an ingestion job with its retry state, every import attempt it made and the rows those attempts rejected, with line number, column, reason and raw content.
+// @Tags imports
+// @Produce json
+// @Security BearerAuth
+// @Param job_id path int true "Job ID"
+// @Success 200 {object} object{job=Job,imports=[]Import,errors=[]ImportError}
+// @Failure 404 {object} object{error=string}
+// @Router /imports/{job_id} [get]
+func (h *Handlers) GetImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/imports/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	job, err := h.db.GetJob(id)
+	// Jobs of other kinds, such as webhook deliveries, are not imports.
+	if err != nil || job.Kind != "" {
+		http.Error(w, `{"error":"import not found"}`, http.StatusNotFound)
+		return
+	}
+	imports, err := h.db.ListJobImports(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if imports == nil {
+		imports = []Import{}
+	}
+	rowErrors, err := h.db.ListJobImportErrors(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if rowErrors == nil {
+		rowErrors = []ImportError{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"job": job, "imports": imports, "errors": rowErrors})
+}
+
+// StreamImportEvents godoc
+// @Summary Stream the progress of an ingestion job
+// @Description Streams the progress of an ingestion job as server-sent events until the job is done or dead. "progress" events carry the row counts of the file being read every PROGRESS_ROWS rows (ImportProgress). Once a file was read, an "error" event is sent for each rejected row (ImportError), then an "import" event with the finished import (Import). A "status" event (JobStatus) ends each attempt; a pending job will be retried and the stream goes on. A client that falls behind may miss progress and error events, never import and status events. For a job that already finished, only its status is sent.
+// @Tags imports
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param job_id path int true "Job ID"
+// @Success 200 {object} JobStatus
+// @Failure 404 {object} object{error=string}
+// @Failure 503 {object} object{error=string}
+// @Router /imports/{job_id}/events [get]
+func (h *Handlers) StreamImportEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/imports/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	if h.broker == nil {
+		http.Error(w, `{"error":"ingestion is not enabled"}`, http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `{"error":"streaming is not supported"}`, http.StatusInternalServerError)
+		return
+	}
+	// Subscribing before reading the job ensures no event is missed in
+	// between.
+	sub, unsubscribe := h.broker.Subscribe(id)
+	defer unsubscribe()
+	job, err := h.db.GetJob(id)
+	if err != nil || job.Kind != "" {
+		http.Error(w, `{"error":"import not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if status := jobStatus(job); status.final() {
+		_ = writeEvent(w, ImportEventStatus, status)
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Ready():
+			for _, ev := range sub.Take() {
+				if err := writeEvent(w, ev.Name, ev.Data); err != nil {
+					log.Printf("import events: %v", err)
+					return
+				}
+				if status, ok := ev.Data.(JobStatus); ok && status.final() {
+					flusher.Flush()
+					return
+				}
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			// No status event is published when the job panics.
+			if job, err := h.db.GetJob(id); err == nil && jobStatus(job).final() {
+				_ = writeEvent(w, ImportEventStatus, jobStatus(job))
+				flusher.Flush()
+				return
+			}
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// jobStatus returns the status event of job as stored.
+func jobStatus(job *Job) JobStatus {
+	return JobStatus{JobID: job.ID, Status: job.Status, Attempts: job.Attempts, Error: job.LastError}
+}
+
+// writeEvent writes a server-sent event named name with data encoded as JSON.
+func writeEvent(w io.Writer, name string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", name, err)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, raw)
+	return err
+}
+
+// ReprocessImport godoc
+// @Summary Reprocess an upload
+// @Description Queues the upload event of an ingestion job again as a new job, for instance to re-run a failed import. Uploads whose content was already ingested are skipped as duplicates unless force is true.
+// @Tags imports
+// @Produce json
+// @Security BearerAuth
+// @Param job_id path int true "Job ID"
+// @Param force query bool false "Import the upload even if its content was already ingested"
+// @Success 202 {object} Job
+// @Failure 404 {object} object{error=string}
+// @Failure 503 {object} object{error=string}
+// @Router /imports/{job_id}/reprocess [post]
+func (h *Handlers) ReprocessImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/imports/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	force := false
+	if v := r.URL.Query().Get("force"); v != "" {
+		force, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, `{"error":"force must be true or false"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if h.queue == nil {
+		http.Error(w, `{"error":"ingestion is not enabled"}`, http.StatusServiceUnavailable)
+		return
+	}
+	job, err := h.db.GetJob(id)
+	if err != nil || job.Kind != "" {
+		http.Error(w, `{"error":"import not found"}`, http.StatusNotFound)
+		return
+	}
+	payload := make(map[string]any, len(job.Payload)+1)
+	for k, v := range job.Payload {
+		payload[k] = v
+	}
+	delete(payload, "force")
+	if force {
+		payload["force"] = true
+	}
+	reprocess, err := h.queue.Enqueue(payload)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("reprocess: job %d queued again as job %d (force %t)", id, reprocess.ID, force)
+	writeJSON(w, http.StatusAccepted, reprocess)
+}
+
+// CreateWebhook godoc
+// @Summary Register a webhook
+// @Description Subscribes a URL to events of one tenant, or of every tenant when tenant_id is empty. Events are posted as JSON with a signature in X-Webhook-Signature: "sha256=" and the hex HMAC-SHA256, keyed with the secret, of X-Webhook-Timestamp, a dot and the body. A secret is generated if none is given; it is only returned here.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body object{url=string,events=[]string,tenant_id=string,secret=string} true "Webhook (url and events required)"
+// @Success 201 {object} Webhook
+// @Failure 400 {object} object{error=string}
+// @Router /webhooks [post]
+func (h *Handlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	var hook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, `{"error":"url must be an http or https URL"}`, http.StatusBadRequest)
+		return
+	}
+	if len(hook.Events) == 0 {
+		http.Error(w, `{"error":"events is required"}`, http.StatusBadRequest)
+		return
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown event %q, must be one of %s", event, strings.Join(webhookEvents, ", ")))
+			return
+		}
+	}
+	if hook.TenantID != "" {
+		if _, err := h.db.GetTenantByTenantID(hook.TenantID); err != nil {
+			http.Error(w, `{"error":"tenant not found"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if hook.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		hook.Secret = secret
+	}
+	if err := h.db.CreateWebhook(&hook); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// ListWebhooks godoc
+// @Summary List webhooks
+// @Description Returns all registered webhooks, without their secrets.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} Webhook
+// @Router /webhooks [get]
+func (h *Handlers) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	hooks, err := h.db.ListWebhooks()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// GetWebhook godoc
+// @Summary Get a webhook
+// @Description Returns a webhook by ID, without its secret.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} Webhook
+// @Failure 404 {object} object{error=string}
+// @Router /webhooks/{id} [get]
+func (h *Handlers) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/webhooks/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	hook, err := h.db.GetWebhook(id)
+	if err != nil {
+		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Removes a webhook and its delivery log. Deliveries still queued are dropped.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} object{status=string}
+// @Failure 404 {object} object{error=string}
+// @Router /webhooks/{id} [delete]
+func (h *Handlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := parseID(r.URL.Path, "/api/webhooks/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	err = h.db.DeleteWebhook(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListWebhookDeliveries godoc
+// @Summary List a webhook's deliveries
+// @Description Returns the most recent deliveries of a webhook, newest first, with their payload, status, number of attempts and the outcome of the last one.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param limit query int false "Maximum number of deliveries (default 50, max 500)"
+// @Success 200 {array} WebhookDelivery
+// @Failure 400 {object} object{error=string}
+// @Failure 404 {object} object{error=string}
+// @Router /webhooks/{id}/deliveries [get]
+func (h *Handlers) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/deliveries")
+	id, err := parseID(path, "/api/webhooks/")
+	if err != nil {
+		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			http.Error(w, `{"error":"limit must be between 1 and 500"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := h.db.GetWebhook(id); err != nil {
+		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		return
+	}
+	deliveries, err := h.db.ListDeliveries(id, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
 func parseID(path, prefix string) (int64, error) {
 	s := strings.TrimPrefix(path, prefix)
 	s = strings.Split(s, "/")[0]
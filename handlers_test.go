@@ -1,11 +1,16 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 // newTestHandlers creates a Handlers with an in-memory DB and a mock SFTPGo client.
@@ -204,6 +209,48 @@ func TestListTenantRecordsHandlerWithData(t *testing.T) {
 	}
 }
 
+func TestListTenantRecordsHandlerAttributeFilter(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	tx, err := h.db.BeginImport("tid1")
+	if err != nil {
+		t.Fatalf("BeginImport: %v", err)
+	}
+	if _, err := tx.UpsertRecord(Record{RecordKey: "R1", Title: "EMEA", Value: 1, Attributes: map[string]string{"region": "emea"}}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if _, err := tx.UpsertRecord(Record{RecordKey: "R2", Title: "APAC", Value: 2, Attributes: map[string]string{"region": "apac"}}); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/records?attr.region=emea", nil)
+	rec := httptest.NewRecorder()
+	h.ListTenantRecords(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var records []Record
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "R1" || records[0].Attributes["region"] != "emea" {
+		t.Errorf("records = %+v, want only R1", records)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/records?attr.=x", nil)
+	rec = httptest.NewRecorder()
+	h.ListTenantRecords(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty attribute name: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
 func TestListTenantRecordsHandlerTenantNotFound(t *testing.T) {
 	h := newTestHandlers(t, nil)
 
@@ -216,13 +263,13 @@ func TestListTenantRecordsHandlerTenantNotFound(t *testing.T) {
 	}
 }
 
-func TestUploadEventHookHandler(t *testing.T) {
+func TestFileEventHookHandler(t *testing.T) {
 	h := newTestHandlers(t, nil)
 
 	body := `{"action":"upload","username":"test","virtual_path":"/data.txt"}`
 	req := httptest.NewRequest(http.MethodPost, "/api/events/upload", strings.NewReader(body))
 	rec := httptest.NewRecorder()
-	h.UploadEventHook(rec, req)
+	h.FileEventHook(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
@@ -237,12 +284,12 @@ func TestUploadEventHookHandler(t *testing.T) {
 	}
 }
 
-func TestUploadEventHookHandlerInvalidJSON(t *testing.T) {
+func TestFileEventHookHandlerInvalidJSON(t *testing.T) {
 	h := newTestHandlers(t, nil)
 
 	req := httptest.NewRequest(http.MethodPost, "/api/events/upload", strings.NewReader("not json"))
 	rec := httptest.NewRecorder()
-	h.UploadEventHook(rec, req)
+	h.FileEventHook(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
@@ -348,3 +395,699 @@ func TestParseID(t *testing.T) {
 		}
 	}
 }
+
+func TestTenantSettingsHandlers(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/tenants/1/settings", strings.NewReader(`{"ingest_mode":"snapshot"}`))
+	rec := httptest.NewRecorder()
+	h.UpdateTenantSettings(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/settings", nil)
+	rec = httptest.NewRecorder()
+	h.GetTenantSettings(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var settings TenantSettings
+	if err := json.NewDecoder(rec.Body).Decode(&settings); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if settings.IngestMode != IngestSnapshot {
+		t.Errorf("ingest_mode = %q, want %q", settings.IngestMode, IngestSnapshot)
+	}
+}
+
+func TestUpdateTenantSettingsHandlerInvalidMode(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/tenants/1/settings", strings.NewReader(`{"ingest_mode":"mirror"}`))
+	rec := httptest.NewRecorder()
+	h.UpdateTenantSettings(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestTenantSchemaHandlers(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	body := `{"fields":{"key":{"source":"SKU","required":true,"transforms":[{"type":"trim"},{"type":"upper"}]},"value":{"source":"price"}}}`
+	req := httptest.NewRequest(http.MethodPut, "/api/tenants/1/schema", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.UpdateTenantSchema(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/schema", nil)
+	rec = httptest.NewRecorder()
+	h.GetTenantSchema(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var schema TenantSchema
+	if err := json.NewDecoder(rec.Body).Decode(&schema); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if schema.Fields[FieldKey].Source != "SKU" || len(schema.Fields[FieldKey].Transforms) != 2 {
+		t.Errorf("key field = %+v, want source SKU with two transforms", schema.Fields[FieldKey])
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/tenants/1/schema", nil)
+	rec = httptest.NewRecorder()
+	h.DeleteTenantSchema(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	schema, err := h.db.GetTenantSchema("tid1")
+	if err != nil {
+		t.Fatalf("GetTenantSchema: %v", err)
+	}
+	if schema.Fields[FieldKey].Source != "" {
+		t.Errorf("schema after reset = %+v, want the default", schema)
+	}
+}
+
+func TestUpdateTenantSchemaHandlerInvalid(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	for _, body := range []string{
+		`{"fields":{"value":{}}}`,
+		`{"fields":{"key":{},"value":{},"price":{}}}`,
+		`{"fields":{"key":{"transforms":[{"type":"regex_extract","pattern":"("}]},"value":{}}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/api/tenants/1/schema", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.UpdateTenantSchema(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestFileEventHookHandlerQueuesJob(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	h.queue = NewQueue(h.db, nil, Config{JobMaxAttempts: 3})
+
+	body := `{"action":"upload","username":"test","virtual_path":"/data.csv","kind":"webhook","delivery_id":1,"force":true,"dry_run":true}`
+	req := httptest.NewRequest(http.MethodPost, "/api/events/upload", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.FileEventHook(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	job, err := h.db.GetJob(1)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if job.Status != JobPending || job.Kind != "" || job.Payload["virtual_path"] != "/data.csv" {
+		t.Errorf("job = %+v, want pending ingestion job for /data.csv", job)
+	}
+	for _, name := range []string{"kind", "delivery_id", "force", "dry_run"} {
+		if _, ok := job.Payload[name]; ok {
+			t.Errorf("payload = %v, want no %s", job.Payload, name)
+		}
+	}
+}
+
+func TestListTenantImportsHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	if _, err := h.db.StartImport(1, "tid1", "tid1/data.csv", 10, "etag", false); err != nil {
+		t.Fatalf("StartImport: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/imports", nil)
+	rec := httptest.NewRecorder()
+	h.ListTenantImports(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var imports []Import
+	if err := json.NewDecoder(rec.Body).Decode(&imports); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(imports) != 1 || imports[0].ObjectKey != "tid1/data.csv" {
+		t.Errorf("imports = %+v, want one import of tid1/data.csv", imports)
+	}
+}
+
+func TestListTenantImportsHandlerInvalidLimit(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/imports?limit=0", nil)
+	rec := httptest.NewRecorder()
+	h.ListTenantImports(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetImportHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	job, err := h.db.EnqueueJob(map[string]any{"virtual_path": "/data.csv"}, 3)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	imp, err := h.db.StartImport(job.ID, "tid1", "tid1/data.csv", 10, "etag", false)
+	if err != nil {
+		t.Fatalf("StartImport: %v", err)
+	}
+	if err := h.db.AddImportErrors(imp.ID, []ImportError{{Line: 2, Column: "value", Reason: "bad", Raw: "R1,T,x"}}); err != nil {
+		t.Fatalf("AddImportErrors: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/imports/1", nil)
+	rec := httptest.NewRecorder()
+	h.GetImport(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp struct {
+		Job     Job           `json:"job"`
+		Imports []Import      `json:"imports"`
+		Errors  []ImportError `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Job.ID != job.ID || len(resp.Imports) != 1 {
+		t.Errorf("response = %+v, want job %d with one import", resp, job.ID)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Raw != "R1,T,x" {
+		t.Errorf("errors = %+v, want the stored row error", resp.Errors)
+	}
+}
+
+func TestGetImportHandlerNotFound(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.EnqueueKindJob(JobKindWebhook, map[string]any{"delivery_id": 1}, 3); err != nil {
+		t.Fatalf("EnqueueKindJob: %v", err)
+	}
+
+	for _, target := range []string{"/api/imports/42", "/api/imports/1"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		h.GetImport(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestReprocessImportHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+
+	rec := httptest.NewRecorder()
+	h.ReprocessImport(rec, httptest.NewRequest(http.MethodPost, "/api/imports/1/reprocess", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a queue: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	h.queue = NewQueue(h.db, nil, Config{JobMaxAttempts: 3})
+	if _, err := h.queue.Enqueue(map[string]any{"username": "test", "virtual_path": "/data.csv"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ReprocessImport(rec, httptest.NewRequest(http.MethodPost, "/api/imports/1/reprocess?force=true", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	var job Job
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if job.ID != 2 || job.Payload["virtual_path"] != "/data.csv" || job.Payload["force"] != true {
+		t.Errorf("job = %+v, want a new forced job for /data.csv", job)
+	}
+
+	delivery, err := h.queue.EnqueueKind(JobKindWebhook, map[string]any{"delivery_id": 1})
+	if err != nil {
+		t.Fatalf("EnqueueKind: %v", err)
+	}
+	for url, want := range map[string]int{
+		"/api/imports/1/reprocess?force=maybe":                http.StatusBadRequest,
+		"/api/imports/9/reprocess":                            http.StatusNotFound,
+		fmt.Sprintf("/api/imports/%d/reprocess", delivery.ID): http.StatusNotFound,
+	} {
+		rec = httptest.NewRecorder()
+		h.ReprocessImport(rec, httptest.NewRequest(http.MethodPost, url, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", url, rec.Code, want)
+		}
+	}
+}
+
+func TestCreateTenantImportHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.CreateTenantImport(rec, httptest.NewRequest(http.MethodPost, "/api/tenants/1/imports", strings.NewReader(`{"path":"/data.csv"}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a queue: status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	h.queue = NewQueue(h.db, nil, Config{JobMaxAttempts: 3})
+	rec = httptest.NewRecorder()
+	body := `{"path":"in/../../data.csv","dry_run":true}`
+	h.CreateTenantImport(rec, httptest.NewRequest(http.MethodPost, "/api/tenants/1/imports", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	job, err := h.db.GetJob(1)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	want := map[string]any{"action": "upload", "username": "testuser", "virtual_path": "/data.csv", "force": true, "dry_run": true}
+	if len(job.Payload) != len(want) {
+		t.Errorf("payload = %v, want %v", job.Payload, want)
+	}
+	for k, v := range want {
+		if job.Payload[k] != v {
+			t.Errorf("payload[%s] = %v, want %v", k, job.Payload[k], v)
+		}
+	}
+
+	for _, tt := range []struct {
+		url, body string
+		want      int
+	}{
+		{"/api/tenants/1/imports", `{}`, http.StatusBadRequest},
+		{"/api/tenants/1/imports", `{"path":"/"}`, http.StatusBadRequest},
+		{"/api/tenants/1/imports", `not json`, http.StatusBadRequest},
+		{"/api/tenants/9/imports", `{"path":"/data.csv"}`, http.StatusNotFound},
+	} {
+		rec = httptest.NewRecorder()
+		h.CreateTenantImport(rec, httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.url, tt.body, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestFileEventHookHandlerRoutesActions(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	h.queue = NewQueue(h.db, nil, Config{JobMaxAttempts: 3})
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	for _, body := range []string{
+		`{"action":"upload","username":"testuser","virtual_path":"/a.csv","file_size":120,"status":1,"protocol":"SFTP","ip":"10.0.0.1"}`,
+		`{"action":"download","username":"testuser","virtual_path":"/a.csv","file_size":120,"status":1,"protocol":"SFTP"}`,
+		`{"action":"rename","username":"testuser","virtual_path":"/a.csv","virtual_target_path":"/b.csv","status":1}`,
+		`{"action":"mkdir","username":"testuser","virtual_path":"/dir","status":1}`,
+		`{"action":"download","username":"nobody","virtual_path":"/x.csv","status":1}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/events/upload", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.FileEventHook(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d for %s", rec.Code, http.StatusOK, body)
+		}
+	}
+
+	// Only the upload and the rename need the worker.
+	for id, action := range map[int64]string{1: "upload", 2: "rename"} {
+		job, err := h.db.GetJob(id)
+		if err != nil || job.Payload["action"] != action {
+			t.Errorf("job %d = %+v, %v, want a %s job", id, job, err, action)
+		}
+	}
+	if job, err := h.db.GetJob(3); err == nil {
+		t.Errorf("expected two jobs, got job 3 %+v", job)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/transfers", nil)
+	rec := httptest.NewRecorder()
+	h.ListTenantTransfers(rec, req)
+	var transfers []Transfer
+	if err := json.NewDecoder(rec.Body).Decode(&transfers); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(transfers) != 3 || transfers[0].Action != TransferRename || transfers[0].TargetPath != "/b.csv" {
+		t.Fatalf("transfers = %+v, want rename, download and upload", transfers)
+	}
+	if up := transfers[2]; up.Size != 120 || up.Status != 1 || up.Protocol != "SFTP" || up.IP != "10.0.0.1" {
+		t.Errorf("upload transfer = %+v", up)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/transfers?action=download", nil)
+	rec = httptest.NewRecorder()
+	h.ListTenantTransfers(rec, req)
+	transfers = nil
+	if err := json.NewDecoder(rec.Body).Decode(&transfers); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(transfers) != 1 || transfers[0].Action != TransferDownload {
+		t.Errorf("transfers = %+v, want the download", transfers)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/transfers?action=chmod", nil)
+	rec = httptest.NewRecorder()
+	h.ListTenantTransfers(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d for an unknown action", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetRecordHistoryHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	for _, title := range []string{"Old", "New"} {
+		tx, err := h.db.BeginImport("tid1")
+		if err != nil {
+			t.Fatalf("BeginImport: %v", err)
+		}
+		if _, err := tx.UpsertRecord(Record{RecordKey: "R/1", Title: title, Value: 1}); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/records/R%2F1/history", nil)
+	rec := httptest.NewRecorder()
+	h.GetRecordHistory(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var resp struct {
+		Record   *Record         `json:"record"`
+		Versions []RecordVersion `json:"versions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Record == nil || resp.Record.Title != "New" || len(resp.Versions) != 1 || resp.Versions[0].Title != "Old" {
+		t.Errorf("history = %+v, want New with one Old version", resp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants/1/records/missing/history", nil)
+	rec = httptest.NewRecorder()
+	h.GetRecordHistory(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRecordStatsHandlers(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	if err := h.db.UpsertRecord("tid1", "R1", "Annual report", "", "a", 2); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if err := h.db.UpsertRecord("tid1", "R2", "Invoice", "", "b", 4); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	if err := h.db.UpsertRecord("tid2", "R3", "Annual report", "", "a", 10); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	var resp struct {
+		Total  RecordStats   `json:"total"`
+		Groups []RecordStats `json:"groups"`
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/records/stats?group_by=category", nil)
+	rec := httptest.NewRecorder()
+	h.GetTenantRecordStats(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Total.Count != 2 || resp.Total.Sum != 6 || len(resp.Groups) != 2 || resp.Groups[1].Group != "b" {
+		t.Errorf("stats = %+v, want 2 records in categories a and b", resp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/records/stats?group_by=tenant&q=annual", nil)
+	rec = httptest.NewRecorder()
+	h.GetRecordStats(rec, req)
+	resp.Groups = nil
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Total.Count != 2 || resp.Total.Max != 10 || len(resp.Groups) != 2 {
+		t.Errorf("stats = %+v, want the two annual reports of both tenants", resp)
+	}
+
+	for _, target := range []string{"/api/tenants/1/records/stats?group_by=tenant", "/api/tenants/1/records/stats?group_by=year", "/api/tenants/1/records/stats?attr.=x"} {
+		req = httptest.NewRequest(http.MethodGet, target, nil)
+		rec = httptest.NewRecorder()
+		h.GetTenantRecordStats(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com","events":["import.completed"]}`,
+		`{"url":"https://example.com","events":[]}`,
+		`{"url":"https://example.com","events":["import.started"]}`,
+		`{"url":"https://example.com","events":["import.failed"],"tenant_id":"nope"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.CreateWebhook(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["import.failed"],"tenant_id":"tid1"}`))
+	rec := httptest.NewRecorder()
+	h.CreateWebhook(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var hook Webhook
+	if err := json.NewDecoder(rec.Body).Decode(&hook); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(hook.Secret) != 64 || hook.TenantID != "tid1" {
+		t.Errorf("webhook = %+v, want a generated secret", hook)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/webhooks", nil)
+	rec = httptest.NewRecorder()
+	h.ListWebhooks(rec, req)
+	var hooks []Webhook
+	if err := json.NewDecoder(rec.Body).Decode(&hooks); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].Secret != "" || hooks[0].URL != "https://example.com/hook" {
+		t.Errorf("webhooks = %+v, want the webhook without its secret", hooks)
+	}
+
+	h.webhooks = NewWebhooks(h.db, NewQueue(h.db, nil, Config{JobMaxAttempts: 3}))
+	h.webhooks.Emit(EventImportFailed, "tid1", map[string]any{"id": 1})
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/webhooks/%d/deliveries", hook.ID), nil)
+	rec = httptest.NewRecorder()
+	h.ListWebhookDeliveries(rec, req)
+	var deliveries []WebhookDelivery
+	if err := json.NewDecoder(rec.Body).Decode(&deliveries); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != EventImportFailed || deliveries[0].Status != DeliveryPending {
+		t.Errorf("deliveries = %+v, want one pending import.failed", deliveries)
+	}
+
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/webhooks/%d", hook.ID), nil)
+		rec = httptest.NewRecorder()
+		h.DeleteWebhook(rec, req)
+		if want := []int{http.StatusOK, http.StatusNotFound}[i]; rec.Code != want {
+			t.Errorf("delete %d: status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/webhooks/%d", hook.ID), nil)
+	rec = httptest.NewRecorder()
+	h.GetWebhook(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestTenantHandlersEmitWebhookEvents(t *testing.T) {
+	h := newTestHandlers(t, newMockSFTPGo(t))
+	h.webhooks = NewWebhooks(h.db, NewQueue(h.db, nil, Config{JobMaxAttempts: 3}))
+	hook := &Webhook{URL: "http://example.com", Secret: "s", Events: []string{EventTenantCreated, EventTenantDeleted}}
+	if err := h.db.CreateWebhook(hook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tenants", strings.NewReader(`{"username":"acme","password":"secret"}`))
+	rec := httptest.NewRecorder()
+	h.CreateTenant(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	req = httptest.NewRequest(http.MethodDelete, "/api/tenants/1", nil)
+	rec = httptest.NewRecorder()
+	h.DeleteTenant(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	deliveries, err := h.db.ListDeliveries(hook.ID, 10)
+	if err != nil {
+		t.Fatalf("ListDeliveries: %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[1].Event != EventTenantCreated || deliveries[0].Event != EventTenantDeleted {
+		t.Fatalf("deliveries = %+v, want tenant.created then tenant.deleted", deliveries)
+	}
+	for _, d := range deliveries {
+		if strings.Contains(string(d.Payload), "secret") || !strings.Contains(string(d.Payload), `"username":"acme"`) {
+			t.Errorf("%s payload = %s, want the tenant without its password", d.Event, d.Payload)
+		}
+	}
+}
+
+func TestDeleteTenantNotifiesTenantWebhooks(t *testing.T) {
+	h := newTestHandlers(t, newMockSFTPGo(t))
+	h.webhooks = newTestWebhooks(t, h.db)
+	recv := newWebhookReceiver(t, func(int) int { return http.StatusOK })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tenants", strings.NewReader(`{"username":"acme","password":"secret"}`))
+	rec := httptest.NewRecorder()
+	h.CreateTenant(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var created struct{ Tenant Tenant }
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("decode tenant: %v", err)
+	}
+	tenant := created.Tenant
+	hook := &Webhook{TenantID: tenant.TenantID, URL: recv.URL, Secret: "s", Events: []string{EventTenantDeleted}}
+	if err := h.db.CreateWebhook(hook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/tenants/%d", tenant.ID), nil)
+	rec = httptest.NewRecorder()
+	h.DeleteTenant(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	// The tenant's webhook receives tenant.deleted, then goes with it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := h.db.GetWebhook(hook.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook of the deleted tenant still there (%v)", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if recv.count() != 1 || recv.requests[0].Header.Get(headerWebhookEvent) != EventTenantDeleted {
+		t.Errorf("webhook received %d requests, want the tenant.deleted event", recv.count())
+	}
+}
+
+func TestStreamImportEventsHandler(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	h.broker = NewBroker()
+	job, err := h.db.EnqueueJob(map[string]any{"username": "testuser", "virtual_path": "/a.csv"}, 3)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(h.StreamImportEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/imports/%d/events", srv.URL, job.ID))
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// The handler subscribed before sending the headers.
+	h.broker.Publish(job.ID, ImportEventProgress, ImportProgress{ObjectKey: "tid1/a.csv", RowsRead: 1000})
+	h.broker.Publish(job.ID, ImportEventStatus, JobStatus{JobID: job.ID, Status: JobPending, Attempts: 1, Error: "timeout"})
+	h.broker.Publish(job.ID, ImportEventStatus, JobStatus{JobID: job.ID, Status: JobDone, Attempts: 2})
+	h.broker.Publish(job.ID, ImportEventProgress, ImportProgress{ObjectKey: "tid1/a.csv", RowsRead: 1})
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	want := `event: progress
+data: {"object_key":"tid1/a.csv","rows_read":1000,"rows_inserted":0,"rows_updated":0,"rows_rejected":0}
+
+event: status
+data: {"job_id":1,"status":"pending","attempts":1,"error":"timeout"}
+
+event: status
+data: {"job_id":1,"status":"done","attempts":2}
+
+`
+	if string(body) != want {
+		t.Errorf("stream =\n%s\nwant\n%s", body, want)
+	}
+
+	// A finished job only gets its status.
+	if err := h.db.BuryJob(job.ID, "bad file"); err != nil {
+		t.Fatalf("BuryJob: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/imports/%d/events", job.ID), nil)
+	rec := httptest.NewRecorder()
+	h.StreamImportEvents(rec, req)
+	if got := rec.Body.String(); !strings.HasPrefix(got, "event: status\n") || !strings.Contains(got, `"status":"dead"`) {
+		t.Errorf("stream = %q, want the dead status", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/imports/99/events", nil)
+	rec = httptest.NewRecorder()
+	h.StreamImportEvents(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -37,15 +37,62 @@ func main() {
 
 	sftpgoClient := NewSFTPGoClient(cfg.SFTPGoURL, cfg.AdminUser, cfg.AdminPass)
 
-	h := &Handlers{db: db, sftpgo: sftpgoClient, cfg: cfg}
+	var vault *keyVault
+	if cfg.PGPMasterKey != "" {
+		if vault, err = newKeyVault(cfg.PGPMasterKey); err != nil {
+			log.Fatalf("invalid PGP master key: %v", err)
+		}
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var worker *Worker
 	if cfg.S3Endpoint != "" {
-		worker, err := NewWorker(db, cfg)
-		if err != nil {
+		if worker, err = NewWorker(db, cfg, vault); err != nil {
 			log.Printf("warning: worker init failed (CSV processing disabled): %v", err)
-		} else {
-			h.worker = worker
-			log.Printf("worker initialized, CSV processing enabled")
+		}
+	}
+
+	// The queue runs without the worker too, to deliver webhooks.
+	var ingest JobHandler
+	if worker != nil {
+		ingest = worker.ProcessJob
+	}
+	queue := NewQueue(db, ingest, cfg)
+	webhooks := NewWebhooks(db, queue)
+	queue.Handle(JobKindWebhook, webhooks.Deliver)
+	if worker != nil {
+		worker.webhooks = webhooks
+		worker.broker = NewBroker()
+	}
+	if err := queue.Start(ctx); err != nil {
+		log.Fatalf("failed to start job queue: %v", err)
+	}
+
+	h := &Handlers{db: db, sftpgo: sftpgoClient, cfg: cfg, vault: vault, webhooks: webhooks}
+
+	if worker != nil {
+		h.queue = queue
+		h.broker = worker.broker
+		log.Printf("worker initialized, CSV processing enabled with %d workers", cfg.WorkerConcurrency)
+
+		if cfg.ScanInterval > 0 {
+			scanner, err := NewScanner(db, worker.store, h.queue, cfg)
+			if err != nil {
+				log.Fatalf("failed to init scanner: %v", err)
+			}
+			go scanner.Run(ctx)
+			log.Printf("scanner enabled, listing tenant prefixes every %s", cfg.ScanInterval)
+		}
+
+		if cfg.S3Notifications {
+			go func() {
+				if err := worker.Listen(ctx, h.queue); err != nil {
+					log.Printf("warning: bucket notifications disabled: %v", err)
+				}
+			}()
+			log.Printf("listening for bucket notifications on %s", cfg.S3Bucket)
 		}
 	}
 
@@ -56,7 +103,7 @@ func main() {
 
 	// SFTPGo hook endpoints — no API key auth (called by SFTPGo internally)
 	mux.HandleFunc("/api/auth/hook", h.ExternalAuthHook)
-	mux.HandleFunc("/api/events/upload", h.UploadEventHook)
+	mux.HandleFunc("/api/events/upload", h.FileEventHook)
 
 	// Authenticated endpoints
 	mux.HandleFunc("/api/tenants", AuthMiddleware(db, func(w http.ResponseWriter, r *http.Request) {
@@ -68,10 +115,34 @@ func main() {
 	}))
 
 	mux.HandleFunc("/api/tenants/", AuthMiddleware(db, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/records/changes") {
+			h.ListRecordChanges(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/records/stats") {
+			h.GetTenantRecordStats(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/records/") && strings.HasSuffix(r.URL.Path, "/history") {
+			h.GetRecordHistory(w, r)
+			return
+		}
 		if strings.HasSuffix(r.URL.Path, "/records") {
 			h.ListTenantRecords(w, r)
 			return
 		}
+		if strings.HasSuffix(r.URL.Path, "/imports") {
+			if r.Method == http.MethodPost {
+				h.CreateTenantImport(w, r)
+			} else {
+				h.ListTenantImports(w, r)
+			}
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/transfers") {
+			h.ListTenantTransfers(w, r)
+			return
+		}
 		if strings.HasSuffix(r.URL.Path, "/validate") {
 			h.ValidateTenant(w, r)
 			return
@@ -80,6 +151,36 @@ func main() {
 			h.UpdateTenantKeys(w, r)
 			return
 		}
+		if strings.HasSuffix(r.URL.Path, "/settings") {
+			if r.Method == http.MethodPut {
+				h.UpdateTenantSettings(w, r)
+			} else {
+				h.GetTenantSettings(w, r)
+			}
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/pgp") {
+			switch r.Method {
+			case http.MethodPut:
+				h.UpdateTenantPGPKeys(w, r)
+			case http.MethodDelete:
+				h.DeleteTenantPGPKeys(w, r)
+			default:
+				h.GetTenantPGPKeys(w, r)
+			}
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/schema") {
+			switch r.Method {
+			case http.MethodPut:
+				h.UpdateTenantSchema(w, r)
+			case http.MethodDelete:
+				h.DeleteTenantSchema(w, r)
+			default:
+				h.GetTenantSchema(w, r)
+			}
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			h.GetTenant(w, r)
@@ -90,6 +191,43 @@ func main() {
 		}
 	}))
 
+	mux.HandleFunc("/api/records/stats", AuthMiddleware(db, h.GetRecordStats))
+
+	mux.HandleFunc("/api/webhooks", AuthMiddleware(db, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.CreateWebhook(w, r)
+		} else {
+			h.ListWebhooks(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/webhooks/", AuthMiddleware(db, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/deliveries") {
+			h.ListWebhookDeliveries(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.GetWebhook(w, r)
+		case http.MethodDelete:
+			h.DeleteWebhook(w, r)
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.HandleFunc("/api/imports/", AuthMiddleware(db, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/reprocess") {
+			h.ReprocessImport(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			h.StreamImportEvents(w, r)
+			return
+		}
+		h.GetImport(w, r)
+	}))
+
 	mux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
 
 	srv := &http.Server{Addr: cfg.ListenAddr, Handler: mux}
@@ -99,6 +237,7 @@ func main() {
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-sigCh
 		log.Printf("received %s, shutting down", sig)
+		cancel()
 		if err := srv.Shutdown(context.Background()); err != nil {
 			log.Printf("shutdown error: %v", err)
 		}
@@ -109,4 +248,5 @@ func main() {
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("server error: %v", err)
 	}
+	queue.Wait()
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// notifyRetryDelay is how long Listen waits before subscribing again once a
+// subscription to bucket notifications ended.
+const notifyRetryDelay = 5 * time.Second
+
+// Listen subscribes to the notifications of objects created in the bucket and
+// enqueues an ingestion job on queue for each upload under a tenant's prefix,
+// whichever channel it came through. It resubscribes when the subscription
+// ends and returns when ctx is cancelled, or straight away when the object
+// store sends no notifications.
+//
+// Jobs are deduplicated against the upload hook and the scanner: objects
+// whose current version an import has already seen are not enqueued, one
+// job is enqueued per object version, and jobs for the same object run one
+// at a time, so the later ones are skipped as duplicates.
+func (w *Worker) Listen(ctx context.Context, queue *Queue) error {
+	notifier, ok := w.store.(ObjectNotifier)
+	if !ok {
+		return fmt.Errorf("object store does not support notifications")
+	}
+	for {
+		for event := range notifier.ListenCreated(ctx) {
+			if event.Err != nil {
+				log.Printf("notify: %v", event.Err)
+				continue
+			}
+			job, err := w.enqueueCreated(queue, event.ObjectInfo)
+			if err != nil {
+				log.Printf("notify: %s: %v", event.Key, err)
+				continue
+			}
+			if job != nil {
+				log.Printf("notify: queued %s as job %d", event.Key, job.ID)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(notifyRetryDelay):
+		}
+	}
+}
+
+// enqueueCreated enqueues an upload event for the created object obj, mapped
+// to a tenant by the tenant ID its key starts with. It returns a nil job for
+// objects that are not uploads, that belong to no tenant or that were
+// already imported or enqueued.
+func (w *Worker) enqueueCreated(queue *Queue, obj ObjectInfo) (*Job, error) {
+	tenantID, rel, _ := strings.Cut(obj.Key, "/")
+	if rel == "" || strings.HasSuffix(rel, "/") || strings.HasSuffix(strings.ToLower(rel), errorReportSuffix) {
+		return nil, nil
+	}
+	tenant, err := w.db.GetTenantByTenantID(tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings, err := w.db.GetTenantSettings(tenant.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	// Moving an upload into the processed or failed folder creates an
+	// object too.
+	if settings.FileDisposition.holds("/" + rel) {
+		return nil, nil
+	}
+	seen, err := w.db.HasImport(tenant.TenantID, obj.Key, obj.ETag)
+	if err != nil || seen {
+		return nil, err
+	}
+	event := map[string]any{
+		"action":       "upload",
+		"username":     tenant.Username,
+		"virtual_path": "/" + rel,
+		"source":       "notification",
+	}
+	return queue.EnqueueUnique(event, uploadDedupeKey(obj))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// notifyStore reports the events sent on events as created objects.
+type notifyStore struct {
+	memStore
+	events chan ObjectEvent
+}
+
+func (s notifyStore) ListenCreated(ctx context.Context) <-chan ObjectEvent {
+	return s.events
+}
+
+func TestEnqueueCreated(t *testing.T) {
+	w := newDispositionTestWorker(t)
+	queue := NewQueue(w.db, nil, Config{JobMaxAttempts: 3})
+	store := w.store.(memStore)
+	store["tid1/imported.csv"] = []byte("key,title,value\nR1,First,1\n")
+	if err := w.ProcessUploadEvent(context.Background(), 100, uploadEvent("/imported.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	for _, obj := range []ObjectInfo{
+		{Key: "tid1/in/"},
+		{Key: "tid1/in/data.csv.errors.csv", ETag: "e1"},
+		{Key: "tid1/processed/imported.csv", ETag: "etag-27"},
+		{Key: "other/data.csv", ETag: "e1"},
+		{Key: "tid1/imported.csv", ETag: "etag-27"},
+	} {
+		job, err := w.enqueueCreated(queue, obj)
+		if err != nil || job != nil {
+			t.Errorf("enqueueCreated(%s) = %v, %v, want it ignored", obj.Key, job, err)
+		}
+	}
+
+	obj := ObjectInfo{Key: "tid1/in/new data.csv", ETag: "e2"}
+	job, err := w.enqueueCreated(queue, obj)
+	if err != nil || job == nil {
+		t.Fatalf("enqueueCreated = %v, %v, want a job", job, err)
+	}
+	if job.Payload["username"] != "user1" || job.Payload["virtual_path"] != "/in/new data.csv" || job.Payload["source"] != "notification" {
+		t.Errorf("job payload = %v, want a notification event for /in/new data.csv", job.Payload)
+	}
+	if job, err := w.enqueueCreated(queue, obj); err != nil || job != nil {
+		t.Errorf("repeated enqueueCreated = %v, %v, want no job", job, err)
+	}
+	obj.ETag = "e3"
+	if job, err := w.enqueueCreated(queue, obj); err != nil || job == nil {
+		t.Errorf("enqueueCreated of a new version = %v, %v, want a job", job, err)
+	}
+}
+
+func TestListenEnqueuesNotifications(t *testing.T) {
+	w := newTenantTestWorker(t)
+	store := notifyStore{w.store.(memStore), make(chan ObjectEvent)}
+	w.store = store
+	queue := NewQueue(w.db, nil, Config{JobMaxAttempts: 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Listen(ctx, queue) }()
+	store.events <- ObjectEvent{Err: errors.New("connection reset")}
+	store.events <- ObjectEvent{ObjectInfo: ObjectInfo{Key: "tid1/data.csv", ETag: "e1"}}
+	cancel()
+	close(store.events)
+	if err := <-done; err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	job, err := w.db.GetJob(1)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if job.Payload["virtual_path"] != "/data.csv" {
+		t.Errorf("job payload = %v, want an event for /data.csv", job.Payload)
+	}
+
+	w.store = memStore{}
+	if err := w.Listen(context.Background(), queue); err == nil {
+		t.Error("expected an error from a store without notifications")
+	}
+}
+
+func TestProcessUploadEventImportsConcurrentEventsOnce(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.store.(memStore)["tid1/data.csv"] = []byte("key,title,value\nR1,First,1\n")
+
+	var wg sync.WaitGroup
+	for id := int64(1); id <= 3; id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.ProcessUploadEvent(context.Background(), id, uploadEvent("/data.csv")); err != nil {
+				t.Errorf("ProcessUploadEvent: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	imports, err := w.db.ListImports("tid1", 10)
+	if err != nil {
+		t.Fatalf("ListImports: %v", err)
+	}
+	succeeded := 0
+	for _, imp := range imports {
+		if imp.Status != ImportSkipped {
+			succeeded++
+		}
+	}
+	if len(imports) != 3 || succeeded != 1 {
+		t.Errorf("imports = %+v, want one import and two skipped duplicates", imports)
+	}
+	if len(w.locks.held) != 0 {
+		t.Errorf("expected all object locks released, %d held", len(w.locks.held))
+	}
+}
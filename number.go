@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Percent handling modes for values ending in a percent sign.
+const (
+	// PercentReject rejects values with a percent sign.
+	PercentReject = "reject"
+	// PercentStrip drops the sign and keeps the number: "12 %" reads as 12.
+	PercentStrip = "strip"
+	// PercentFraction divides by a hundred: "12 %" reads as 0.12.
+	PercentFraction = "fraction"
+)
+
+// thousandsSeparators are the accepted digit group separators.
+var thousandsSeparators = []string{"", ",", ".", " ", "'", "_"}
+
+// NumberFormat describes how the value column writes numbers.
+type NumberFormat struct {
+	// DecimalSeparator is "." or ",".
+	DecimalSeparator string `json:"decimal_separator" example:","`
+	// ThousandsSeparator groups digits in threes; empty means no grouping.
+	// A space also matches non-breaking spaces.
+	ThousandsSeparator string `json:"thousands_separator" example:"."`
+	// StripCurrency removes currency symbols such as $ or € and three-letter
+	// codes such as EUR before or after the number.
+	StripCurrency bool `json:"strip_currency"`
+	// AccountingNegatives reads a number in parentheses as negative.
+	AccountingNegatives bool   `json:"accounting_negatives"`
+	Percent             string `json:"percent" enums:"reject,strip,fraction"`
+}
+
+// DefaultNumberFormat accepts plain numbers such as -1234.56 or 1e3.
+func DefaultNumberFormat() NumberFormat {
+	return NumberFormat{DecimalSeparator: ".", Percent: PercentReject}
+}
+
+func (f NumberFormat) withDefaults() NumberFormat {
+	def := DefaultNumberFormat()
+	if f.DecimalSeparator == "" {
+		f.DecimalSeparator = def.DecimalSeparator
+	}
+	if f.Percent == "" {
+		f.Percent = def.Percent
+	}
+	return f
+}
+
+// Validate reports whether the format is supported and unambiguous.
+func (f NumberFormat) Validate() error {
+	if f.DecimalSeparator != "." && f.DecimalSeparator != "," {
+		return fmt.Errorf(`number_format.decimal_separator must be "." or ","`)
+	}
+	valid := false
+	for _, sep := range thousandsSeparators {
+		valid = valid || f.ThousandsSeparator == sep
+	}
+	if !valid {
+		return fmt.Errorf(`number_format.thousands_separator must be empty or one of , . ' _ and space`)
+	}
+	if f.ThousandsSeparator == f.DecimalSeparator {
+		return fmt.Errorf("number_format separators must differ")
+	}
+	switch f.Percent {
+	case PercentReject, PercentStrip, PercentFraction:
+	default:
+		return fmt.Errorf("number_format.percent must be %q, %q or %q", PercentReject, PercentStrip, PercentFraction)
+	}
+	return nil
+}
+
+// maxExponent bounds exponents so a hostile value cannot make Parse build
+// an enormous number.
+const maxExponent = 400
+
+// Parse reads s in the format and returns it both as an exact decimal string,
+// keeping the number of fraction digits written in the file, and as a float.
+// Numbers too large for a float64 are rejected.
+func (f NumberFormat) Parse(s string) (string, float64, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if f.AccountingNegatives && strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s, negative = strings.TrimSpace(s[1:len(s)-1]), true
+	}
+
+	percent := false
+	if rest, ok := strings.CutSuffix(s, "%"); ok {
+		if f.Percent == PercentReject {
+			return "", 0, errors.New("percent values are not accepted")
+		}
+		s, percent = strings.TrimSpace(rest), true
+	}
+
+	if f.StripCurrency {
+		s = stripCurrency(s)
+	}
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		s, negative = rest, !negative
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	if f.StripCurrency {
+		// Handles both "-$5" and "$-5".
+		s = stripCurrency(s)
+	}
+
+	exponent := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil || exp > maxExponent || exp < -maxExponent {
+			return "", 0, fmt.Errorf("invalid exponent %q", s[i+1:])
+		}
+		s, exponent = s[:i], exp
+	}
+
+	intPart, fracPart, _ := strings.Cut(s, f.DecimalSeparator)
+	intPart, err := f.ungroup(intPart)
+	if err != nil {
+		return "", 0, err
+	}
+	if intPart+fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return "", 0, errors.New("not a number")
+	}
+
+	r, _ := new(big.Rat).SetString(intPart + fracPart)
+	scale := len(fracPart) - exponent
+	if percent && f.Percent == PercentFraction {
+		scale += 2
+	}
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(scale))), nil)
+	if scale >= 0 {
+		r.Quo(r, new(big.Rat).SetInt(pow))
+	} else {
+		r.Mul(r, new(big.Rat).SetInt(pow))
+		scale = 0
+	}
+	if negative {
+		r.Neg(r)
+	}
+	// Values beyond the float64 range round to infinity, which JSON cannot
+	// encode.
+	value, _ := r.Float64()
+	if math.IsInf(value, 0) {
+		return "", 0, errors.New("number out of range")
+	}
+	return r.FloatString(scale), value, nil
+}
+
+// ungroup removes thousands separators from the integer part of a number,
+// checking that they split it into groups of three digits.
+func (f NumberFormat) ungroup(s string) (string, error) {
+	sep := f.ThousandsSeparator
+	if sep == "" {
+		return s, nil
+	}
+	if sep == " " {
+		s = strings.NewReplacer("\u00a0", " ", "\u202f", " ").Replace(s)
+	}
+	groups := strings.Split(s, sep)
+	if len(groups) == 1 {
+		return s, nil
+	}
+	for i, g := range groups {
+		if len(g) != 3 && (i > 0 || len(g) == 0 || len(g) > 3) {
+			return "", errors.New("misplaced thousands separator")
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+// stripCurrency removes currency symbols and a three-letter currency code
+// from either end of s.
+func stripCurrency(s string) string {
+	trim := func(s string) string {
+		return strings.TrimFunc(s, func(r rune) bool { return unicode.Is(unicode.Sc, r) || unicode.IsSpace(r) })
+	}
+	s = trim(s)
+	if len(s) > 3 && isCurrencyCode(s[:3]) {
+		s = s[3:]
+	}
+	if len(s) > 3 && isCurrencyCode(s[len(s)-3:]) {
+		s = s[:len(s)-3]
+	}
+	return trim(s)
+}
+
+func isCurrencyCode(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberFormatParse(t *testing.T) {
+	us := NumberFormat{DecimalSeparator: ".", ThousandsSeparator: ",", StripCurrency: true, AccountingNegatives: true, Percent: PercentReject}
+	eu := NumberFormat{DecimalSeparator: ",", ThousandsSeparator: ".", StripCurrency: true, Percent: PercentStrip}
+	fr := NumberFormat{DecimalSeparator: ",", ThousandsSeparator: " ", Percent: PercentFraction}
+
+	tests := []struct {
+		name    string
+		format  NumberFormat
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"plain", DefaultNumberFormat(), "10.5", "10.5", false},
+		{"negative", DefaultNumberFormat(), " -3 ", "-3", false},
+		{"explicit plus", DefaultNumberFormat(), "+7.25", "7.25", false},
+		{"leading dot", DefaultNumberFormat(), ".5", "0.5", false},
+		{"exponent", DefaultNumberFormat(), "1.5e3", "1500", false},
+		{"negative exponent", DefaultNumberFormat(), "25E-3", "0.025", false},
+		{"keeps scale", DefaultNumberFormat(), "12.30", "12.30", false},
+		{"default rejects grouping", DefaultNumberFormat(), "1,200", "", true},
+		{"default rejects currency", DefaultNumberFormat(), "$5", "", true},
+		{"default rejects percent", DefaultNumberFormat(), "12 %", "", true},
+		{"default rejects text", DefaultNumberFormat(), "abc", "", true},
+		{"default rejects nan", DefaultNumberFormat(), "NaN", "", true},
+		{"empty", DefaultNumberFormat(), "", "", true},
+		{"sign only", DefaultNumberFormat(), "-", "", true},
+		{"huge exponent", DefaultNumberFormat(), "1e999999", "", true},
+		{"beyond float64", DefaultNumberFormat(), "1e309", "", true},
+		{"just beyond float64", DefaultNumberFormat(), "9e308", "", true},
+		{"negative beyond float64", DefaultNumberFormat(), "-2e308", "", true},
+		{"largest float64", DefaultNumberFormat(), "1.7e308", "17" + strings.Repeat("0", 307), false},
+
+		{"us currency", us, "$1,200.00", "1200.00", false},
+		{"us millions", us, "1,234,567.891", "1234567.891", false},
+		{"us accounting", us, "(45.00)", "-45.00", false},
+		{"us accounting currency", us, "($1,000)", "-1000", false},
+		{"us code suffix", us, "99.95 USD", "99.95", false},
+		{"us negative currency", us, "-$5", "-5", false},
+		{"us currency negative", us, "$-5", "-5", false},
+		{"us misplaced group", us, "1,20.00", "", true},
+		{"us short group", us, "12,34", "", true},
+		{"us percent rejected", us, "5%", "", true},
+
+		{"eu grouping", eu, "1.234,56", "1234.56", false},
+		{"eu euro", eu, "€ 1.234,56", "1234.56", false},
+		{"eu euro suffix", eu, "12,5 €", "12.5", false},
+		{"eu percent strip", eu, "12 %", "12", false},
+		{"eu rejects us style", eu, "1,234.56", "", true},
+		{"eu parentheses not negative", eu, "(45,00)", "", true},
+
+		{"fr space grouping", fr, "1 234 567,5", "1234567.5", false},
+		{"fr nbsp grouping", fr, "1\u00a0234,5", "1234.5", false},
+		{"fr narrow nbsp grouping", fr, "1\u202f234", "1234", false},
+		{"fr percent fraction", fr, "12,5 %", "0.125", false},
+		{"fr percent fraction integer", fr, "12%", "0.12", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := tt.format.Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberFormatParseFloat(t *testing.T) {
+	_, value, err := NumberFormat{DecimalSeparator: ",", ThousandsSeparator: "."}.withDefaults().Parse("-1.234,56")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if value != -1234.56 {
+		t.Errorf("value = %v, want -1234.56", value)
+	}
+}
+
+func TestNumberFormatValidate(t *testing.T) {
+	for _, f := range []NumberFormat{
+		{DecimalSeparator: ";"},
+		{DecimalSeparator: ",", ThousandsSeparator: ","},
+		{DecimalSeparator: ".", ThousandsSeparator: "-"},
+		{DecimalSeparator: ".", Percent: "half"},
+	} {
+		if err := f.withDefaults().Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want an error", f)
+		}
+	}
+	if err := (NumberFormat{DecimalSeparator: ",", ThousandsSeparator: "'"}).withDefaults().Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrObjectNotFound is returned by ObjectStore methods when the key does not
+// exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ObjectStore is the subset of S3 operations the worker relies on.
+type ObjectStore interface {
+	// Open returns the content and metadata of the object at key.
+	Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// Put stores size bytes read from r at key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Remove deletes the object at key. Removing a missing key is not an error.
+	Remove(ctx context.Context, key string) error
+	// Copy copies the object at src to dst, replacing any existing object.
+	Copy(ctx context.Context, src, dst string) error
+	// List returns the objects whose key starts with prefix, at any depth.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// ObjectEvent reports an object created in the bucket, or in Err why the
+// subscription failed.
+type ObjectEvent struct {
+	ObjectInfo
+	Err error
+}
+
+// ObjectNotifier is implemented by stores that report objects as they are
+// created.
+type ObjectNotifier interface {
+	// ListenCreated reports the objects created anywhere in the bucket. The
+	// channel is closed when ctx is cancelled or the subscription ends.
+	ListenCreated(ctx context.Context) <-chan ObjectEvent
+}
+
+// minioStore implements ObjectStore on top of a MinIO/S3 bucket.
+type minioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *minioStore) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("get object %s: %w", key, err)
+	}
+	stat, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ObjectInfo{}, fmt.Errorf("object %s: %w", key, ErrObjectNotFound)
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("stat object %s: %w", key, err)
+	}
+	return obj, ObjectInfo{Key: key, Size: stat.Size, ETag: stat.ETag, LastModified: stat.LastModified}, nil
+}
+
+func (s *minioStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("put object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *minioStore) Remove(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("remove object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *minioStore) Copy(ctx context.Context, src, dst string) error {
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return fmt.Errorf("object %s: %w", src, ErrObjectNotFound)
+		}
+		return fmt.Errorf("copy object %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+func (s *minioStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("list objects %s: %w", prefix, obj.Err)
+		}
+		objects = append(objects, ObjectInfo{Key: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified})
+	}
+	return objects, nil
+}
+
+// ListenCreated subscribes to the s3:ObjectCreated:* notifications of the
+// bucket, a MinIO extension that AWS S3 does not support.
+func (s *minioStore) ListenCreated(ctx context.Context) <-chan ObjectEvent {
+	events := make(chan ObjectEvent)
+	go func() {
+		defer close(events)
+		for info := range s.client.ListenBucketNotification(ctx, s.bucket, "", "", []string{"s3:ObjectCreated:*"}) {
+			if info.Err != nil {
+				select {
+				case events <- ObjectEvent{Err: fmt.Errorf("listen for notifications: %w", info.Err)}:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			for _, record := range info.Records {
+				obj := record.S3.Object
+				// Keys are URL-encoded in notifications.
+				key, err := url.QueryUnescape(obj.Key)
+				if err != nil {
+					key = obj.Key
+				}
+				select {
+				case events <- ObjectEvent{ObjectInfo: ObjectInfo{Key: key, Size: obj.Size, ETag: obj.ETag}}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
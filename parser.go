@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// sniffSize is how many leading bytes are inspected to recognise a file
+// whose extension does not say what it is.
+const sniffSize = 512
+
+// Parser turns an uploaded file into rows for the record upsert path.
+type Parser interface {
+	// Name identifies the format in logs.
+	Name() string
+	// Open reads the header of r and returns a reader for the rows after it.
+	// Errors caused by the file's content are wrapped with Permanent.
+	Open(r io.Reader, settings TenantSettings) (RowReader, error)
+}
+
+// RowReader yields the rows of a file as strings aligned with its header.
+type RowReader interface {
+	// Header returns the column names of the file.
+	Header() []string
+	// Next returns the next row, or io.EOF after the last one. A
+	// *rowReadError means the row could not be decoded but reading can
+	// continue; any other error ends the import. io.EOF is only returned
+	// once the whole input has been read, so that checks made at the end of
+	// the stream, such as PGP signatures, fail the import before it commits.
+	Next() (Row, error)
+}
+
+// Row is one record of an uploaded file.
+type Row struct {
+	Fields []string
+	// Line locates the row for error reports: the line number for CSV and
+	// NDJSON, the position in the array for JSON, the sheet row for XLSX.
+	Line int
+	// Raw is the row as written in the file, for error reports.
+	Raw string
+	// Extra holds values of keys that are not in the header, which only
+	// JSON formats produce. They are kept as record attributes.
+	Extra map[string]string
+}
+
+// rowReadError reports a row that could not be decoded.
+type rowReadError struct {
+	line   int
+	reason string
+	raw    string
+}
+
+func (e *rowReadError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.reason)
+}
+
+// parsers maps lower-case file extensions to the parser for the format.
+var parsers = map[string]Parser{
+	".csv":    csvParser{},
+	".tsv":    csvParser{},
+	".json":   jsonParser{},
+	".ndjson": ndjsonParser{},
+	".jsonl":  ndjsonParser{},
+	".xlsx":   xlsxParser{},
+}
+
+// parserFor picks the parser for a file from its name, falling back to the
+// first bytes of its content when the extension is not registered. Workbooks
+// are read within budget. It returns nil for files that are not ingested.
+func parserFor(name string, head []byte, budget *byteBudget) Parser {
+	if p, ok := parsers[strings.ToLower(path.Ext(name))]; ok {
+		if _, ok := p.(xlsxParser); ok {
+			return xlsxParser{budget: budget}
+		}
+		return p
+	}
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, bomUTF8), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return jsonParser{}
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return ndjsonParser{}
+	}
+	return nil
+}
+
+// csvParser reads delimited text in the dialect configured or detected for
+// the tenant.
+type csvParser struct{}
+
+func (csvParser) Name() string { return "csv" }
+
+func (csvParser) Open(r io.Reader, settings TenantSettings) (RowReader, error) {
+	content, dialect, err := openDialect(r, settings)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("worker: reading CSV as %s", dialect)
+
+	raw := &rawRecorder{r: content}
+	reader := csv.NewReader(raw)
+	reader.Comma = dialect.Delimiter
+	// Field counts are checked by the importer according to the ragged rows
+	// policy.
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = settings.LazyQuotes
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, contentError(fmt.Errorf("read CSV header: %w", err))
+	}
+	raw.take(reader.InputOffset())
+	restoreFields(dialect, header)
+	return &csvRows{reader: reader, raw: raw, dialect: dialect, header: header}, nil
+}
+
+type csvRows struct {
+	reader  *csv.Reader
+	raw     *rawRecorder
+	dialect Dialect
+	header  []string
+}
+
+func (c *csvRows) Header() []string { return c.header }
+
+func (c *csvRows) Next() (Row, error) {
+	fields, err := c.reader.Read()
+	if err == io.EOF {
+		return Row{}, io.EOF
+	}
+	rawRow := c.dialect.restore(c.raw.take(c.reader.InputOffset()))
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Row{}, &rowReadError{line: parseErr.StartLine, reason: parseErr.Err.Error(), raw: rawRow}
+		}
+		return Row{}, fmt.Errorf("CSV read error: %w", err)
+	}
+	line, _ := c.reader.FieldPos(0)
+	restoreFields(c.dialect, fields)
+	return Row{Fields: fields, Line: line, Raw: rawRow}, nil
+}
+
+// restoreFields undoes the quote swapping of the dialect in place.
+func restoreFields(d Dialect, row []string) {
+	for i := range row {
+		row[i] = d.restore(row[i])
+	}
+}
+
+// rawRecorder keeps the bytes consumed by a csv.Reader so the original text
+// of each record can be recovered from csv.Reader.InputOffset.
+type rawRecorder struct {
+	r    io.Reader
+	buf  []byte
+	base int64
+}
+
+func (rr *rawRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+// take returns the text between the end of the previous record and offset,
+// without the trailing line break, and forgets it.
+func (rr *rawRecorder) take(offset int64) string {
+	n := int(offset - rr.base)
+	if n > len(rr.buf) {
+		n = len(rr.buf)
+	}
+	text := string(rr.buf[:n])
+	rr.buf = rr.buf[n:]
+	rr.base = offset
+	return strings.TrimRight(text, "\r\n")
+}
+
+// contentError marks err as permanent when it comes from malformed or empty
+// input rather than from the underlying stream.
+func contentError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) || errors.Is(err, io.EOF) {
+		return Permanent(err)
+	}
+	return err
+}
+
+// csvLine renders fields as one CSV line, the raw form of rows that were not
+// read from CSV.
+func csvLine(fields []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(fields)
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonHeaderSample is how many leading objects of a JSON upload are read to
+// collect its columns. Keys that first appear later are kept as attributes.
+const jsonHeaderSample = 100
+
+// jsonParser reads a JSON array of objects. Rows are located by their
+// position in the array.
+type jsonParser struct{}
+
+func (jsonParser) Name() string { return "json" }
+
+func (jsonParser) Open(r io.Reader, settings TenantSettings) (RowReader, error) {
+	dec := json.NewDecoder(bufio.NewReader(skipBOM(r)))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonContentError(fmt.Errorf("read JSON: %w", err))
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, Permanent(errors.New("JSON upload must be an array of objects"))
+	}
+	n, done := 0, false
+	return newJSONRows(func() (json.RawMessage, int, error) {
+		if done {
+			return nil, 0, io.EOF
+		}
+		if !dec.More() {
+			tok, err := dec.Token()
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, 0, jsonContentError(fmt.Errorf("read JSON: %w", err))
+			}
+			if tok != json.Delim(']') {
+				return nil, 0, Permanent(errors.New("JSON array is not terminated"))
+			}
+			if _, err := dec.Token(); err != io.EOF {
+				if err == nil {
+					err = Permanent(errors.New("unexpected data after the JSON array"))
+				}
+				return nil, 0, jsonContentError(err)
+			}
+			done = true
+			return nil, 0, io.EOF
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, 0, jsonContentError(fmt.Errorf("read JSON element %d: %w", n+1, err))
+		}
+		n++
+		return raw, n, nil
+	})
+}
+
+// maxNDJSONLine caps one line of an NDJSON upload. A longer line rejects only
+// its row.
+const maxNDJSONLine = 1 << 20
+
+// ndjsonParser reads one JSON object per line. Blank lines are skipped and a
+// line that is not a valid object rejects only that row.
+type ndjsonParser struct{}
+
+func (ndjsonParser) Name() string { return "ndjson" }
+
+func (ndjsonParser) Open(r io.Reader, settings TenantSettings) (RowReader, error) {
+	sc := bufio.NewScanner(skipBOM(r))
+	sc.Buffer(nil, maxNDJSONLine)
+	// A line that fills the buffer is returned cut to its start, flagged as
+	// long, and the rest of it is skipped.
+	long, skipping := false, false
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				return len(data), nil, nil
+			}
+			skipping = false
+			return i + 1, nil, nil
+		}
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= maxNDJSONLine {
+			long, skipping = true, true
+			return len(data), data[:maxRawRowLength], nil
+		}
+		return advance, token, err
+	})
+	line := 0
+	return newJSONRows(func() (json.RawMessage, int, error) {
+		for sc.Scan() {
+			line++
+			if long {
+				long = false
+				reason := fmt.Sprintf("line is longer than %d bytes", maxNDJSONLine)
+				return nil, line, &rowReadError{line: line, reason: reason, raw: sc.Text()}
+			}
+			if text := bytes.TrimSpace(sc.Bytes()); len(text) > 0 {
+				return append(json.RawMessage(nil), text...), line, nil
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, 0, fmt.Errorf("read NDJSON: %w", err)
+		}
+		return nil, 0, io.EOF
+	})
+}
+
+// jsonRows turns JSON objects into rows aligned with the keys collected from
+// the first objects of the file.
+type jsonRows struct {
+	next    func() (json.RawMessage, int, error)
+	header  []string
+	columns map[string]int
+	pending []jsonItem
+}
+
+type jsonItem struct {
+	raw  json.RawMessage
+	line int
+	err  *rowReadError
+}
+
+// newJSONRows reads up to jsonHeaderSample objects from next to collect the
+// header, keeping them to be returned first.
+func newJSONRows(next func() (json.RawMessage, int, error)) (*jsonRows, error) {
+	j := &jsonRows{next: next, columns: make(map[string]int)}
+	for len(j.pending) < jsonHeaderSample {
+		raw, line, err := next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowReadError
+		if errors.As(err, &rowErr) {
+			j.pending = append(j.pending, jsonItem{line: line, err: rowErr})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		j.pending = append(j.pending, jsonItem{raw: raw, line: line})
+		keys, _, err := decodeObject(raw)
+		if err != nil {
+			continue
+		}
+		for _, k := range keys {
+			if _, ok := j.columns[k]; !ok {
+				j.columns[k] = len(j.header)
+				j.header = append(j.header, k)
+			}
+		}
+	}
+	if len(j.header) == 0 {
+		return nil, Permanent(errors.New("JSON upload has no objects to read columns from"))
+	}
+	return j, nil
+}
+
+func (j *jsonRows) Header() []string { return j.header }
+
+func (j *jsonRows) Next() (Row, error) {
+	var item jsonItem
+	if len(j.pending) > 0 {
+		item, j.pending = j.pending[0], j.pending[1:]
+		if item.err != nil {
+			return Row{}, item.err
+		}
+	} else {
+		var err error
+		if item.raw, item.line, err = j.next(); err != nil {
+			return Row{}, err
+		}
+	}
+
+	keys, values, err := decodeObject(item.raw)
+	if err != nil {
+		return Row{}, &rowReadError{line: item.line, reason: err.Error(), raw: string(item.raw)}
+	}
+	row := Row{Fields: make([]string, len(j.header)), Line: item.line, Raw: string(item.raw)}
+	for _, k := range keys {
+		if i, ok := j.columns[k]; ok {
+			row.Fields[i] = values[k]
+			continue
+		}
+		if row.Extra == nil {
+			row.Extra = make(map[string]string)
+		}
+		row.Extra[strings.ToLower(strings.TrimSpace(k))] = values[k]
+	}
+	return row, nil
+}
+
+// decodeObject returns the keys of a JSON object in document order and their
+// values as text. Strings are unquoted, numbers keep their exact text, null
+// is empty, and nested objects and arrays stay JSON.
+func decodeObject(raw json.RawMessage) ([]string, map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, errors.New("not a JSON object")
+	}
+	var keys []string
+	values := make(map[string]string)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if _, dup := values[key]; !dup {
+			keys = append(keys, key)
+		}
+		values[key] = jsonText(value)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return keys, values, nil
+}
+
+func jsonText(v json.RawMessage) string {
+	switch {
+	case bytes.Equal(v, []byte("null")):
+		return ""
+	case len(v) > 0 && v[0] == '"':
+		var s string
+		_ = json.Unmarshal(v, &s)
+		return s
+	}
+	return string(v)
+}
+
+// jsonContentError marks syntax errors and truncated input as permanent.
+func jsonContentError(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Permanent(err)
+	}
+	return err
+}
+
+// skipBOM drops a UTF-8 byte order mark, which encoding/json rejects.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(len(bomUTF8)); err == nil && bytes.Equal(head, bomUTF8) {
+		_, _ = br.Discard(len(bomUTF8))
+	}
+	return br
+}
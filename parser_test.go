@@ -0,0 +1,438 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParserFor(t *testing.T) {
+	workbook := buildXLSX(t, xlsxSheet{name: "Sheet1", rows: "<row r=\"1\"/>"})
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"data.csv", "key,title,value\n", "csv"},
+		{"DATA.CSV", "key,title,value\n", "csv"},
+		{"data.tsv", "key\ttitle\tvalue\n", "csv"},
+		{"data.json", "[{}]", "json"},
+		{"data.ndjson", "{}\n", "ndjson"},
+		{"data.jsonl", "{}\n", "ndjson"},
+		{"data.xlsx", string(workbook), "xlsx"},
+		{"DATA.XLSX", string(workbook), "xlsx"},
+		// Other zip packages are not workbooks.
+		{"letter.docx", string(workbook), ""},
+		{"app.jar", string(workbook), ""},
+		{"export", string(workbook), ""},
+		{"export", " \n[{\"key\":\"R1\"}]", "json"},
+		{"export.dat", "\ufeff{\"key\":\"R1\"}\n", "ndjson"},
+		{"notes.txt", "key,title,value\n", ""},
+		{"image.png", "\x89PNG\r\n", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p := parserFor(tt.name, []byte(tt.head), nil); p != nil {
+			got = p.Name()
+		}
+		if got != tt.want {
+			t.Errorf("parserFor(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// readAll returns the header and every row of r, failing on any error.
+func readAll(t *testing.T, r RowReader) ([]string, []Row) {
+	t.Helper()
+	var rows []Row
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return r.Header(), rows
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	input := `[
+		{"key": "R1", "title": "First", "value": 1.50},
+		{"key": "R2", "value": -2, "title": null, "tags": ["a", "b"]},
+		{"key": "R3", "title": "Third", "value": "3", "Colour": "red"}
+	]`
+	rows, err := jsonParser{}.Open(strings.NewReader(input), DefaultTenantSettings())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	header, got := readAll(t, rows)
+	if want := []string{"key", "title", "value", "tags", "Colour"}; !reflect.DeepEqual(header, want) {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	want := [][]string{
+		{"R1", "First", "1.50", "", ""},
+		{"R2", "", "-2", `["a", "b"]`, ""},
+		{"R3", "Third", "3", "", "red"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i, row := range got {
+		if !reflect.DeepEqual(row.Fields, want[i]) || row.Line != i+1 {
+			t.Errorf("row %d = %q at %d, want %q at %d", i, row.Fields, row.Line, want[i], i+1)
+		}
+	}
+}
+
+func TestJSONParserKeepsLateKeysAsExtra(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("[")
+	for i := 0; i < jsonHeaderSample; i++ {
+		fmt.Fprintf(&b, `{"key":"R%d","title":"T","value":%d},`, i, i)
+	}
+	b.WriteString(`{"key":"late","title":"T","value":1,"Region":"emea"}]`)
+
+	rows, err := jsonParser{}.Open(strings.NewReader(b.String()), DefaultTenantSettings())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	header, got := readAll(t, rows)
+	if len(header) != 3 {
+		t.Errorf("header = %q, want key, title and value", header)
+	}
+	last := got[len(got)-1]
+	if last.Fields[0] != "late" || last.Extra["region"] != "emea" {
+		t.Errorf("last row = %+v, want late with region emea", last)
+	}
+}
+
+func TestJSONParserErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"object", `{"key":"R1"}`},
+		{"empty", ``},
+		{"empty array", `[]`},
+		{"scalars", `[1, 2]`},
+		{"truncated", `[{"key":"R1","title":"T","value":1}`},
+		{"syntax", `[{"key":"R1",}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := jsonParser{}.Open(strings.NewReader(tt.input), DefaultTenantSettings())
+			for err == nil {
+				_, err = rows.Next()
+				var rowErr *rowReadError
+				if errors.As(err, &rowErr) {
+					err = nil
+				}
+			}
+			if err == io.EOF || !IsPermanent(err) {
+				t.Errorf("expected permanent error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNDJSONParser(t *testing.T) {
+	input := "{\"key\":\"R1\",\"title\":\"First\",\"value\":1}\n\n" +
+		"not json\n" +
+		"[1]\r\n" +
+		"{\"key\":\"R2\",\"title\":\"Second\",\"value\":2}"
+	rows, err := ndjsonParser{}.Open(strings.NewReader(input), DefaultTenantSettings())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var lines, rejected []int
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowReadError
+		if errors.As(err, &rowErr) {
+			rejected = append(rejected, rowErr.line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		lines = append(lines, row.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{1, 5}) || !reflect.DeepEqual(rejected, []int{3, 4}) {
+		t.Errorf("read lines %v and rejected %v, want [1 5] and [3 4]", lines, rejected)
+	}
+}
+
+func TestNDJSONParserLongLine(t *testing.T) {
+	long := `{"key":"R2","title":"` + strings.Repeat("x", maxNDJSONLine) + `"}`
+	input := "{\"key\":\"R1\",\"title\":\"First\"}\n" + long + "\n" +
+		"{\"key\":\"R3\",\"title\":\"Third\"}\n" + long
+	rows, err := ndjsonParser{}.Open(strings.NewReader(input), DefaultTenantSettings())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var keys []string
+	var rejected []int
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowReadError
+		if errors.As(err, &rowErr) {
+			if !strings.Contains(rowErr.reason, "longer than") || len(rowErr.raw) > maxRawRowLength {
+				t.Errorf("line %d rejected with %q and %d raw bytes", rowErr.line, rowErr.reason, len(rowErr.raw))
+			}
+			rejected = append(rejected, rowErr.line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		keys = append(keys, row.Fields[0])
+	}
+	if !reflect.DeepEqual(keys, []string{"R1", "R3"}) || !reflect.DeepEqual(rejected, []int{2, 4}) {
+		t.Errorf("read keys %v and rejected lines %v, want [R1 R3] and [2 4]", keys, rejected)
+	}
+}
+
+// xlsxSheet is a worksheet for buildXLSX; rows is the content of sheetData.
+type xlsxSheet struct {
+	name string
+	rows string
+}
+
+// buildXLSX returns a minimal workbook laid out the way Excel writes one,
+// with a shared string table and a date style at index 1.
+func buildXLSX(t *testing.T, sheets ...xlsxSheet) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		if _, err := io.WriteString(f, content); err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+	}
+
+	var sheetList, rels strings.Builder
+	for i, s := range sheets {
+		fmt.Fprintf(&sheetList, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, s.name, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1),
+			`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+				`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`+
+				s.rows+`</sheetData></worksheet>`)
+	}
+	add("[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`)
+	add("xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" `+
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`+
+		`<workbookPr/><sheets>`+sheetList.String()+`</sheets></workbook>`)
+	add("xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		rels.String()+`</Relationships>`)
+	add("xl/sharedStrings.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+
+		`<si><t>key</t></si><si><t>title</t></si><si><t>value</t></si>`+
+		`<si><r><t>Rich </t></r><r><t>text</t></r><rPh><t>ignored</t></rPh></si></sst>`)
+	add("xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd"/></numFmts>`+
+		`<cellXfs count="3"><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="4"/></cellXfs></styleSheet>`)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// xlsxHeaderRow is a header row of key, title and value from the shared
+// string table of buildXLSX.
+const xlsxHeaderRow = `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c></row>`
+
+func TestXLSXParser(t *testing.T) {
+	data := buildXLSX(t, xlsxSheet{name: "Records", rows: xlsxHeaderRow +
+		`<row r="2"><c r="A2" t="inlineStr"><is><t>R1</t></is></c><c r="B2" t="s"><v>3</v></c><c r="C2" s="2"><v>1234.5</v></c></row>` +
+		`<row r="4"><c r="A4" t="str"><v>R2</v></c><c r="C4" t="b"><v>1</v></c></row>` +
+		`<row r="5"><c r="B5"/></row>` +
+		`<row r="6"><c r="A6" t="inlineStr"><is><t>R3</t></is></c><c r="B6" s="1"><v>45292</v></c><c r="C6"><v>7</v></c></row>`})
+
+	rows, err := xlsxParser{}.Open(bytes.NewReader(data), DefaultTenantSettings())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	header, got := readAll(t, rows)
+	if want := []string{"key", "title", "value"}; !reflect.DeepEqual(header, want) {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	want := []Row{
+		{Fields: []string{"R1", "Rich text", "1234.5"}, Line: 2, Raw: "R1,Rich text,1234.5"},
+		{Fields: []string{"R2", "", "true"}, Line: 4, Raw: "R2,,true"},
+		{Fields: []string{"R3", "2024-01-01", "7"}, Line: 6, Raw: "R3,2024-01-01,7"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %+v, want %+v", got, want)
+	}
+}
+
+func TestXLSXParserSelectsSheet(t *testing.T) {
+	data := buildXLSX(t,
+		xlsxSheet{name: "Summary", rows: `<row r="1"><c r="A1" t="inlineStr"><is><t>total</t></is></c></row>`},
+		xlsxSheet{name: "Records", rows: xlsxHeaderRow +
+			`<row r="2"><c r="A2" t="inlineStr"><is><t>R1</t></is></c><c r="C2"><v>1</v></c></row>`})
+
+	settings := DefaultTenantSettings()
+	rows, err := xlsxParser{}.Open(bytes.NewReader(data), settings)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if header := rows.Header(); !reflect.DeepEqual(header, []string{"total"}) {
+		t.Errorf("first sheet header = %q, want [total]", header)
+	}
+
+	settings.Sheet = "records"
+	if rows, err = (xlsxParser{}).Open(bytes.NewReader(data), settings); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, got := readAll(t, rows); len(got) != 1 || got[0].Fields[0] != "R1" {
+		t.Errorf("rows = %+v, want R1 from the Records sheet", got)
+	}
+
+	settings.Sheet = "Missing"
+	if _, err := (xlsxParser{}).Open(bytes.NewReader(data), settings); !IsPermanent(err) {
+		t.Errorf("expected permanent error for unknown sheet, got %v", err)
+	}
+}
+
+func TestXLSXParserErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a zip", []byte("PK\x03\x04garbage")},
+		{"empty sheet", buildXLSX(t, xlsxSheet{name: "Sheet1"})},
+		{"no workbook", func() []byte {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			_, _ = zw.Create("readme.txt")
+			_ = zw.Close()
+			return buf.Bytes()
+		}()},
+	}
+	for _, tt := range tests {
+		if _, err := (xlsxParser{}).Open(bytes.NewReader(tt.data), DefaultTenantSettings()); !IsPermanent(err) {
+			t.Errorf("%s: expected permanent error, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestXLSXParserBudget(t *testing.T) {
+	// A worksheet that compresses to a few KiB but expands to megabytes.
+	row := `<row r="2"><c r="A2" t="inlineStr"><is><t>R1</t></is></c><c r="C2"><v>1</v></c></row>`
+	data := buildXLSX(t, xlsxSheet{name: "Sheet1", rows: xlsxHeaderRow + strings.Repeat(row, 50000)})
+
+	rows, err := xlsxParser{budget: &byteBudget{limit: 1 << 20}}.Open(bytes.NewReader(data), DefaultTenantSettings())
+	for err == nil {
+		_, err = rows.Next()
+	}
+	if !IsPermanent(err) || !strings.Contains(err.Error(), "expands to more than") {
+		t.Errorf("expected the budget to stop the worksheet, got %v", err)
+	}
+
+	// The shared strings and other parts count too.
+	if _, err := (xlsxParser{budget: &byteBudget{limit: 512}}).Open(bytes.NewReader(data), DefaultTenantSettings()); !IsPermanent(err) || !strings.Contains(err.Error(), "expands to more than") {
+		t.Errorf("expected the budget to stop the workbook parts, got %v", err)
+	}
+	if _, err := (xlsxParser{budget: &byteBudget{limit: 1 << 30}}).Open(bytes.NewReader(data), DefaultTenantSettings()); err != nil {
+		t.Errorf("Open within budget: %v", err)
+	}
+}
+
+func TestExcelDate(t *testing.T) {
+	tests := []struct {
+		serial   float64
+		date1904 bool
+		want     string
+	}{
+		{45292, false, "2024-01-01"},
+		{61, false, "1900-03-01"},
+		{45292.75, false, "2024-01-01T18:00:00"},
+		{0.5, false, "12:00:00"},
+		{0, true, "1904-01-01"},
+	}
+	for _, tt := range tests {
+		if got := excelDate(tt.serial, tt.date1904); got != tt.want {
+			t.Errorf("excelDate(%v, %t) = %q, want %q", tt.serial, tt.date1904, got, tt.want)
+		}
+	}
+}
+
+func TestIsDateFormat(t *testing.T) {
+	tests := map[string]bool{
+		"yyyy-mm-dd":          true,
+		`dd/mm/yyyy\ hh:mm`:   true,
+		"[h]:mm:ss":           true,
+		"#,##0.00":            false,
+		`0.00" days"`:         false,
+		"[Red]#,##0;[Blue]-0": false,
+		`"$"#,##0_);($#,##0)`: false,
+	}
+	for code, want := range tests {
+		if got := isDateFormat(code); got != want {
+			t.Errorf("isDateFormat(%q) = %t, want %t", code, got, want)
+		}
+	}
+}
+
+func TestProcessUploadEventReadsOtherFormats(t *testing.T) {
+	w := newTestWorker(t)
+	if _, err := w.db.CreateTenant("tid1", "user1", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	store := w.store.(memStore)
+	store["tid1/a.json"] = []byte(`[{"key":"J1","title":"From JSON","value":1,"source":"api"}]`)
+	store["tid1/b.ndjson"] = []byte("{\"key\":\"N1\",\"title\":\"From NDJSON\",\"value\":2}\n")
+	store["tid1/c.xlsx"] = buildXLSX(t, xlsxSheet{name: "Sheet1", rows: xlsxHeaderRow +
+		`<row r="2"><c r="A2" t="inlineStr"><is><t>X1</t></is></c><c r="B2" t="inlineStr"><is><t>From XLSX</t></is></c><c r="C2"><v>3</v></c></row>`})
+	store["tid1/notes.txt"] = []byte("key,title,value\nT1,Text,4\n")
+
+	for i, path := range []string{"/a.json", "/b.ndjson", "/c.xlsx", "/notes.txt"} {
+		event := map[string]any{"username": "user1", "virtual_path": path}
+		if err := w.ProcessUploadEvent(context.Background(), int64(i+1), event); err != nil {
+			t.Fatalf("ProcessUploadEvent %s: %v", path, err)
+		}
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	got := make(map[string]Record)
+	for _, r := range records {
+		got[r.RecordKey] = r
+	}
+	if len(got) != 3 || got["J1"].Value != 1 || got["N1"].Value != 2 || got["X1"].Title != "From XLSX" {
+		t.Errorf("records = %+v, want J1, N1 and X1", records)
+	}
+	if got["J1"].Attributes["source"] != "api" {
+		t.Errorf("J1 attributes = %v, want source api", got["J1"].Attributes)
+	}
+	if imports, _ := w.db.ListJobImports(4); len(imports) != 0 {
+		t.Errorf("expected the text file to be skipped, got imports %+v", imports)
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxWorkbookSize caps XLSX uploads, which are read into memory because
+	// the zip format needs random access.
+	maxWorkbookSize = 100 << 20
+	// maxSheetColumns is the column limit of Excel (XFD).
+	maxSheetColumns = 16384
+)
+
+// xlsxParser reads the first worksheet of an Excel workbook, or the one named
+// by the tenant's sheet setting. The first non-empty row is the header, and
+// rows are located by their row number in the sheet.
+type xlsxParser struct {
+	// budget bounds the uncompressed size of the parts read from the
+	// workbook, which is a zip package; nil sets no bound.
+	budget *byteBudget
+}
+
+func (xlsxParser) Name() string { return "xlsx" }
+
+func (x xlsxParser) Open(r io.Reader, settings TenantSettings) (RowReader, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxWorkbookSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read workbook: %w", err)
+	}
+	if len(data) > maxWorkbookSize {
+		return nil, Permanent(fmt.Errorf("workbook is larger than %d MiB", maxWorkbookSize>>20))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("open workbook: %w", err))
+	}
+	budget := x.budget
+	if budget == nil {
+		budget = &byteBudget{}
+	}
+	pkg := workbookPackage{zr: zr, budget: budget}
+
+	wb, err := readWorkbook(pkg)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	sheet, err := wb.sheet(settings.Sheet)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	f, err := pkg.open(sheet)
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("open worksheet %s: %w", sheet, err))
+	}
+
+	rows := &xlsxRows{dec: xml.NewDecoder(f), wb: wb}
+	for {
+		_, fields, err := rows.readRow()
+		if err == io.EOF {
+			return nil, Permanent(errors.New("worksheet is empty"))
+		}
+		if err != nil {
+			return nil, Permanent(err)
+		}
+		if fields = trimEmpty(fields); len(fields) > 0 {
+			rows.header = fields
+			return rows, nil
+		}
+	}
+}
+
+// workbook holds the parts of an XLSX package needed to read cell values.
+type workbook struct {
+	sheets        []workbookSheet
+	sharedStrings []string
+	// dateStyles marks the cell style indexes whose number format is a date.
+	dateStyles map[int]bool
+	date1904   bool
+}
+
+type workbookSheet struct {
+	name string
+	path string
+}
+
+// sheet returns the path of the worksheet called name, or of the first
+// worksheet when name is empty.
+func (wb *workbook) sheet(name string) (string, error) {
+	if len(wb.sheets) == 0 {
+		return "", errors.New("workbook has no worksheets")
+	}
+	if name == "" {
+		return wb.sheets[0].path, nil
+	}
+	for _, s := range wb.sheets {
+		if strings.EqualFold(s.name, name) {
+			return s.path, nil
+		}
+	}
+	return "", fmt.Errorf("workbook has no sheet %q", name)
+}
+
+// workbookPackage opens the parts of a workbook, counting their uncompressed
+// bytes against budget so that a small workbook cannot expand without bound.
+type workbookPackage struct {
+	zr     *zip.Reader
+	budget *byteBudget
+}
+
+func (pkg workbookPackage) open(name string) (io.ReadCloser, error) {
+	f, err := pkg.zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&budgetReader{r: f, budget: pkg.budget}, f}, nil
+}
+
+func readWorkbook(pkg workbookPackage) (*workbook, error) {
+	var doc struct {
+		Pr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(pkg, "xl/workbook.xml", &doc); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(pkg, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Rels))
+	for _, rel := range rels.Rels {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	wb := &workbook{dateStyles: make(map[int]bool)}
+	wb.date1904 = doc.Pr.Date1904 == "1" || doc.Pr.Date1904 == "true"
+	for _, s := range doc.Sheets {
+		for _, a := range s.Attrs {
+			// The relationship id lives in a namespace that differs between
+			// transitional and strict workbooks.
+			if a.Name.Local == "id" {
+				if target, ok := targets[a.Value]; ok {
+					wb.sheets = append(wb.sheets, workbookSheet{name: s.Name, path: target})
+				}
+			}
+		}
+	}
+
+	var err error
+	if wb.sharedStrings, err = readSharedStrings(pkg); err != nil {
+		return nil, err
+	}
+	if err := wb.readStyles(pkg); err != nil {
+		return nil, err
+	}
+	return wb, nil
+}
+
+func decodeZipXML(pkg workbookPackage, name string, v any) error {
+	f, err := pkg.open(name)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := xml.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// readSharedStrings returns the shared string table, which workbooks without
+// text cells may omit.
+func readSharedStrings(pkg workbookPackage) ([]string, error) {
+	f, err := pkg.open("xl/sharedStrings.xml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open shared strings: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var table []string
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return table, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse shared strings: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "si" {
+			text, err := readRichText(dec, start)
+			if err != nil {
+				return nil, fmt.Errorf("parse shared strings: %w", err)
+			}
+			table = append(table, text)
+		}
+	}
+}
+
+// readRichText returns the text of the t elements inside start, skipping
+// phonetic runs.
+func readRichText(dec *xml.Decoder, start xml.StartElement) (string, error) {
+	var b strings.Builder
+	phonetic := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "rPh":
+				phonetic++
+			case "t":
+				if phonetic > 0 {
+					if err := dec.Skip(); err != nil {
+						return "", err
+					}
+					continue
+				}
+				var text string
+				if err := dec.DecodeElement(&text, &t); err != nil {
+					return "", err
+				}
+				b.WriteString(text)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "rPh":
+				phonetic--
+			case start.Name.Local:
+				return b.String(), nil
+			}
+		}
+	}
+}
+
+// readStyles records which cell styles format numbers as dates, so date
+// cells can be read as dates rather than as their serial numbers.
+func (wb *workbook) readStyles(pkg workbookPackage) error {
+	var doc struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := decodeZipXML(pkg, "xl/styles.xml", &doc); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	custom := make(map[int]string, len(doc.NumFmts))
+	for _, f := range doc.NumFmts {
+		custom[f.ID] = f.Code
+	}
+	for i, xf := range doc.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			wb.dateStyles[i] = isDateFormat(code)
+		} else {
+			wb.dateStyles[i] = isBuiltinDateFormat(xf.NumFmtID)
+		}
+	}
+	return nil
+}
+
+// isBuiltinDateFormat reports whether a built-in number format shows a date
+// or time, including the East Asian locale formats.
+func isBuiltinDateFormat(id int) bool {
+	return id >= 14 && id <= 22 || id >= 27 && id <= 36 || id >= 45 && id <= 47 || id >= 50 && id <= 58
+}
+
+// isDateFormat reports whether a custom number format code shows a date or
+// time, ignoring quoted text, escaped characters and bracketed colours or
+// conditions.
+func isDateFormat(code string) bool {
+	var b strings.Builder
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"':
+			for i++; i < len(code) && code[i] != '"'; i++ {
+			}
+		case '\\', '_', '*':
+			i++
+		case '[':
+			j := strings.IndexByte(code[i:], ']')
+			if j < 0 {
+				return false
+			}
+			// Elapsed time such as [h]:mm is a time format.
+			inner := strings.ToLower(code[i+1 : i+j])
+			if strings.Trim(inner, "hms") == "" {
+				b.WriteString(inner)
+			}
+			i += j
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.ContainsAny(strings.ToLower(b.String()), "ymdhs")
+}
+
+// xlsxRows streams the rows of a worksheet.
+type xlsxRows struct {
+	dec     *xml.Decoder
+	wb      *workbook
+	header  []string
+	lastRow int
+}
+
+func (x *xlsxRows) Header() []string { return x.header }
+
+func (x *xlsxRows) Next() (Row, error) {
+	for {
+		line, fields, err := x.readRow()
+		if err == io.EOF {
+			return Row{}, io.EOF
+		}
+		if err != nil {
+			return Row{}, Permanent(err)
+		}
+		fields = trimEmpty(fields)
+		if len(fields) == 0 {
+			continue
+		}
+		// Excel leaves out empty trailing cells, so short rows are padded.
+		for len(fields) < len(x.header) {
+			fields = append(fields, "")
+		}
+		return Row{Fields: fields, Line: line, Raw: csvLine(fields)}, nil
+	}
+}
+
+// readRow returns the number and cell values of the next row element.
+func (x *xlsxRows) readRow() (int, []string, error) {
+	for {
+		tok, err := x.dec.Token()
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("parse worksheet: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		x.lastRow++
+		if n, err := strconv.Atoi(attr(start, "r")); err == nil {
+			x.lastRow = n
+		}
+		fields, err := x.readCells(start)
+		if err != nil {
+			return 0, nil, fmt.Errorf("parse worksheet row %d: %w", x.lastRow, err)
+		}
+		return x.lastRow, fields, nil
+	}
+}
+
+func (x *xlsxRows) readCells(row xml.StartElement) ([]string, error) {
+	var fields []string
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if t.Name.Local == row.Name.Local {
+				return fields, nil
+			}
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			col := len(fields)
+			if ref := attr(t, "r"); ref != "" {
+				if col, err = columnIndex(ref); err != nil {
+					return nil, err
+				}
+			}
+			value, err := x.readCell(t)
+			if err != nil {
+				return nil, err
+			}
+			for len(fields) < col {
+				fields = append(fields, "")
+			}
+			if col < len(fields) {
+				fields[col] = value
+			} else {
+				fields = append(fields, value)
+			}
+		}
+	}
+}
+
+// readCell returns the text of a cell as Excel would display it without
+// number formatting, with dates in ISO 8601.
+func (x *xlsxRows) readCell(c xml.StartElement) (string, error) {
+	var v, inline string
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if end, ok := tok.(xml.EndElement); ok && end.Name.Local == c.Name.Local {
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "v":
+			if err := x.dec.DecodeElement(&v, &start); err != nil {
+				return "", err
+			}
+		case "is":
+			if inline, err = readRichText(x.dec, start); err != nil {
+				return "", err
+			}
+		default:
+			// Formulas and extensions are not needed.
+			if err := x.dec.Skip(); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	switch attr(c, "t") {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || i < 0 || i >= len(x.wb.sharedStrings) {
+			return "", fmt.Errorf("invalid shared string %q", v)
+		}
+		return x.wb.sharedStrings[i], nil
+	case "inlineStr":
+		return inline, nil
+	case "b":
+		if v == "1" {
+			return "true", nil
+		}
+		return "false", nil
+	case "", "n":
+		style, _ := strconv.Atoi(attr(c, "s"))
+		if v != "" && x.wb.dateStyles[style] {
+			if serial, err := strconv.ParseFloat(v, 64); err == nil {
+				return excelDate(serial, x.wb.date1904), nil
+			}
+		}
+	}
+	return v, nil
+}
+
+// excelDate converts a date serial number to ISO 8601: a date, a time of day,
+// or both.
+func excelDate(serial float64, date1904 bool) string {
+	// The 1900 system counts from 1899-12-30 to absorb Excel's fictitious
+	// 29 February 1900.
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days, frac := math.Modf(serial)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(math.Round(frac*86400)) * time.Second)
+	switch {
+	case frac == 0:
+		return t.Format("2006-01-02")
+	case days == 0:
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// columnIndex returns the zero-based column of a cell reference such as C7.
+func columnIndex(ref string) (int, error) {
+	letters := strings.TrimRightFunc(ref, func(r rune) bool { return r >= '0' && r <= '9' })
+	col := 0
+	for _, c := range strings.ToUpper(letters) {
+		if c < 'A' || c > 'Z' {
+			return 0, fmt.Errorf("invalid cell reference %q", ref)
+		}
+		if col = col*26 + int(c-'A') + 1; col > maxSheetColumns {
+			return 0, fmt.Errorf("invalid cell reference %q", ref)
+		}
+	}
+	if col == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return col - 1, nil
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// trimEmpty drops empty trailing fields.
+func trimEmpty(fields []string) []string {
+	for len(fields) > 0 && strings.TrimSpace(fields[len(fields)-1]) == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return fields
+}
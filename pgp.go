@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// keyVault encrypts tenant secrets at rest with AES-256-GCM under the master
+// key from PGP_MASTER_KEY. Each secret is bound to its tenant, so a sealed
+// value copied to another tenant does not open.
+type keyVault struct {
+	aead cipher.AEAD
+}
+
+// newKeyVault creates a vault from a 32-byte master key encoded as hex or
+// base64.
+func newKeyVault(masterKey string) (*keyVault, error) {
+	key, err := hex.DecodeString(masterKey)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(masterKey)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("PGP_MASTER_KEY must be 32 bytes encoded as hex or base64")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("master key cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("master key cipher: %w", err)
+	}
+	return &keyVault{aead: aead}, nil
+}
+
+// seal encrypts plaintext for tenantID and returns it as base64.
+func (v *keyVault) seal(tenantID string, plaintext []byte) (string, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(v.aead.Seal(nonce, nonce, plaintext, []byte(tenantID))), nil
+}
+
+// open decrypts a value sealed for tenantID.
+func (v *keyVault) open(tenantID, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < v.aead.NonceSize() {
+		return nil, errors.New("sealed key is malformed")
+	}
+	nonce, ciphertext := data[:v.aead.NonceSize()], data[v.aead.NonceSize():]
+	plaintext, err := v.aead.Open(nil, nonce, ciphertext, []byte(tenantID))
+	if err != nil {
+		return nil, errors.New("sealed key does not open with the master key")
+	}
+	return plaintext, nil
+}
+
+// PGPKeyRequest is the body of PUT /api/tenants/{id}/pgp.
+type PGPKeyRequest struct {
+	// PrivateKey is the armored secret key that uploads are encrypted to.
+	PrivateKey string `json:"private_key"`
+	// Passphrase unlocks PrivateKey when it is protected. It is only used to
+	// unlock the key and is not stored.
+	Passphrase string `json:"passphrase,omitempty"`
+	// VerifyKey is the partner's armored public key. When set, every upload
+	// must be signed with it.
+	VerifyKey string `json:"verify_key,omitempty"`
+}
+
+// newTenantPGPKeys checks the keys of req and seals the private key for
+// tenantID. The private key is stored unlocked, protected by the vault alone,
+// so the worker needs no passphrase.
+func newTenantPGPKeys(v *keyVault, tenantID string, req PGPKeyRequest) (*TenantPGPKeys, error) {
+	entity, err := readSingleKey(req.PrivateKey, "private_key")
+	if err != nil {
+		return nil, err
+	}
+	if entity.PrivateKey == nil {
+		return nil, errors.New("private_key holds no secret key")
+	}
+	if err := entity.DecryptPrivateKeys([]byte(req.Passphrase)); err != nil {
+		return nil, errors.New("passphrase does not unlock private_key")
+	}
+	if _, ok := entity.EncryptionKey(time.Now()); !ok {
+		return nil, errors.New("private_key has no valid encryption key")
+	}
+
+	var private, public bytes.Buffer
+	if err := writeArmored(&private, openpgp.PrivateKeyType, func(w io.Writer) error {
+		return entity.SerializePrivateWithoutSigning(w, nil)
+	}); err != nil {
+		return nil, fmt.Errorf("encode private key: %w", err)
+	}
+	if err := writeArmored(&public, openpgp.PublicKeyType, entity.Serialize); err != nil {
+		return nil, fmt.Errorf("encode public key: %w", err)
+	}
+	sealed, err := v.seal(tenantID, private.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &TenantPGPKeys{
+		TenantID:    tenantID,
+		PrivateKey:  sealed,
+		PublicKey:   public.String(),
+		Fingerprint: fingerprint(entity),
+	}
+	if strings.TrimSpace(req.VerifyKey) != "" {
+		verify, err := readSingleKey(req.VerifyKey, "verify_key")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := verify.SigningKey(time.Now()); !ok {
+			return nil, errors.New("verify_key has no valid signing key")
+		}
+		keys.VerifyKey = req.VerifyKey
+		keys.VerifyFingerprint = fingerprint(verify)
+	}
+	return keys, nil
+}
+
+func readSingleKey(armored, field string) (*openpgp.Entity, error) {
+	if strings.TrimSpace(armored) == "" {
+		return nil, fmt.Errorf("%s is required", field)
+	}
+	keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an armored PGP key: %v", field, err)
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("%s must hold exactly one key, got %d", field, len(keys))
+	}
+	return keys[0], nil
+}
+
+func writeArmored(w io.Writer, blockType string, serialize func(io.Writer) error) error {
+	aw, err := armor.Encode(w, blockType, nil)
+	if err != nil {
+		return err
+	}
+	if err := serialize(aw); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+func fingerprint(e *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint))
+}
+
+// pgpExtensions are the extensions of PGP-encrypted uploads.
+var pgpExtensions = map[string]bool{".pgp": true, ".gpg": true, ".asc": true}
+
+var pgpArmorHeader = []byte("-----BEGIN PGP MESSAGE-----")
+
+// decrypt returns the plaintext of the file named name when it is a PGP
+// message, recognised by extension or by its armor header, together with the
+// name of the plaintext: data.csv for data.csv.pgp. The message is decrypted
+// as it is read with the tenant's private key and counts against budget, since
+// PGP messages may be compressed. When the tenant registered a verify key the
+// message must be signed with it; a bad signature is only detected at the end
+// of the message, so it surfaces as a permanent read error that makes the
+// import roll back. sealed reports a PGP message, whose integrity is likewise
+// only checked at its end.
+func (w *Worker) decrypt(tenantID, name string, r io.Reader, budget *byteBudget) (_ string, _ io.Reader, sealed bool, err error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(pgpArmorHeader))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return name, nil, false, fmt.Errorf("read %s: %w", name, err)
+	}
+	ext := strings.ToLower(path.Ext(name))
+	armored := bytes.Equal(head, pgpArmorHeader)
+	if !pgpExtensions[ext] && !armored {
+		return name, br, false, nil
+	}
+	if pgpExtensions[ext] {
+		name = name[:len(name)-len(ext)]
+	}
+
+	if w.vault == nil {
+		return name, nil, false, Permanent(errors.New("PGP upload cannot be decrypted: PGP_MASTER_KEY is not configured"))
+	}
+	keys, err := w.db.GetTenantPGPKeys(tenantID)
+	if err != nil {
+		return name, nil, false, err
+	}
+	if keys == nil {
+		return name, nil, false, Permanent(errors.New("PGP upload cannot be decrypted: tenant has no PGP key"))
+	}
+	private, err := w.vault.open(tenantID, keys.PrivateKey)
+	if err != nil {
+		return name, nil, false, Permanent(err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(private))
+	if err != nil {
+		return name, nil, false, Permanent(fmt.Errorf("read tenant PGP key: %w", err))
+	}
+	verifying := keys.VerifyKey != ""
+	if verifying {
+		verify, err := openpgp.ReadArmoredKeyRing(strings.NewReader(keys.VerifyKey))
+		if err != nil {
+			return name, nil, false, Permanent(fmt.Errorf("read tenant verify key: %w", err))
+		}
+		keyring = append(keyring, verify...)
+	}
+
+	var message io.Reader = br
+	if armored {
+		block, err := armor.Decode(br)
+		if err != nil {
+			return name, nil, false, Permanent(fmt.Errorf("read PGP armor: %w", err))
+		}
+		message = block.Body
+	}
+	md, err := openpgp.ReadMessage(message, keyring, nil, nil)
+	if err != nil {
+		return name, nil, false, pgpError(fmt.Errorf("decrypt PGP message: %w", err))
+	}
+	if !md.IsEncrypted {
+		return name, nil, false, Permanent(errors.New("PGP message is not encrypted"))
+	}
+	if verifying {
+		switch {
+		case !md.IsSigned:
+			return name, nil, false, Permanent(errors.New("PGP message is not signed"))
+		case md.SignedBy == nil || fingerprint(md.SignedBy.Entity) != keys.VerifyFingerprint:
+			return name, nil, false, Permanent(fmt.Errorf("PGP message is signed by unknown key %016X", md.SignedByKeyId))
+		}
+	}
+	return name, &budgetReader{r: &pgpReader{md: md, verify: verifying}, budget: budget}, true, nil
+}
+
+// pgpReader reads the plaintext of a message and reports a failed integrity
+// or signature check at its end. Its first error is sticky: the signature
+// check parses the packets after the body, and reading again after the end
+// would parse past them.
+type pgpReader struct {
+	md     *openpgp.MessageDetails
+	verify bool
+	err    error
+}
+
+func (p *pgpReader) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	n, err := p.md.UnverifiedBody.Read(b)
+	switch {
+	case err == io.EOF && p.verify && p.md.SignatureError != nil:
+		err = Permanent(fmt.Errorf("PGP signature check failed: %w", p.md.SignatureError))
+	case err != nil && err != io.EOF:
+		err = pgpError(fmt.Errorf("decrypt PGP message: %w", err))
+	}
+	p.err = err
+	return n, err
+}
+
+// pgpError marks errors caused by the content of a PGP message as permanent.
+func pgpError(err error) error {
+	var structural pgperrors.StructuralError
+	var unsupported pgperrors.UnsupportedError
+	var signature pgperrors.SignatureError
+	var sessionKey pgperrors.DecryptWithSessionKeyError
+	switch {
+	case errors.As(err, &structural), errors.As(err, &unsupported), errors.As(err, &signature),
+		errors.As(err, &sessionKey), errors.Is(err, pgperrors.ErrKeyIncorrect),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return Permanent(err)
+	}
+	return err
+}
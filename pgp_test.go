@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const testMasterKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func newTestVault(t *testing.T) *keyVault {
+	t.Helper()
+	v, err := newKeyVault(testMasterKey)
+	if err != nil {
+		t.Fatalf("newKeyVault: %v", err)
+	}
+	return v
+}
+
+// newTestEntity generates an Ed25519 key with an X25519 encryption subkey.
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEd25519})
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+	return e
+}
+
+func armoredPrivate(t *testing.T, e *openpgp.Entity, passphrase string) string {
+	t.Helper()
+	if passphrase != "" {
+		if err := e.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			t.Fatalf("EncryptPrivateKeys: %v", err)
+		}
+		defer func() { _ = e.DecryptPrivateKeys([]byte(passphrase)) }()
+	}
+	var buf bytes.Buffer
+	if err := writeArmored(&buf, openpgp.PrivateKeyType, func(w io.Writer) error {
+		return e.SerializePrivateWithoutSigning(w, nil)
+	}); err != nil {
+		t.Fatalf("armor private key: %v", err)
+	}
+	return buf.String()
+}
+
+func armoredPublic(t *testing.T, e *openpgp.Entity) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeArmored(&buf, openpgp.PublicKeyType, e.Serialize); err != nil {
+		t.Fatalf("armor public key: %v", err)
+	}
+	return buf.String()
+}
+
+// encryptTo encrypts plaintext to recipient, signed by signer when it is not
+// nil, optionally armored.
+func encryptTo(t *testing.T, plaintext string, recipient, signer *openpgp.Entity, armored bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var aw io.WriteCloser
+	if armored {
+		var err error
+		if aw, err = armor.Encode(&buf, "PGP MESSAGE", nil); err != nil {
+			t.Fatalf("armor: %v", err)
+		}
+		out = aw
+	}
+	w, err := openpgp.Encrypt(out, []*openpgp.Entity{recipient}, signer, nil, nil)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := io.WriteString(w, plaintext); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			t.Fatalf("armor: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestKeyVault(t *testing.T) {
+	v := newTestVault(t)
+	sealed, err := v.seal("tid1", []byte("secret"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if strings.Contains(sealed, "secret") {
+		t.Errorf("sealed value %q contains the plaintext", sealed)
+	}
+	if got, err := v.open("tid1", sealed); err != nil || string(got) != "secret" {
+		t.Errorf("open = %q, %v, want secret", got, err)
+	}
+	if _, err := v.open("tid2", sealed); err == nil {
+		t.Errorf("expected a value sealed for tid1 not to open for tid2")
+	}
+	other, err := newKeyVault("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	if err != nil {
+		t.Fatalf("newKeyVault with base64 key: %v", err)
+	}
+	if _, err := other.open("tid1", sealed); err != nil {
+		t.Errorf("expected the same key in base64 to open the value: %v", err)
+	}
+
+	for _, bad := range []string{"", "short", strings.Repeat("0", 62)} {
+		if _, err := newKeyVault(bad); err == nil {
+			t.Errorf("newKeyVault(%q): expected error", bad)
+		}
+	}
+}
+
+func TestNewTenantPGPKeys(t *testing.T) {
+	v := newTestVault(t)
+	ours := newTestEntity(t, "ours")
+	partner := newTestEntity(t, "partner")
+
+	keys, err := newTenantPGPKeys(v, "tid1", PGPKeyRequest{
+		PrivateKey: armoredPrivate(t, ours, "hunter2"),
+		Passphrase: "hunter2",
+		VerifyKey:  armoredPublic(t, partner),
+	})
+	if err != nil {
+		t.Fatalf("newTenantPGPKeys: %v", err)
+	}
+	if keys.Fingerprint != fingerprint(ours) || keys.VerifyFingerprint != fingerprint(partner) {
+		t.Errorf("fingerprints = %s, %s, want %s, %s", keys.Fingerprint, keys.VerifyFingerprint, fingerprint(ours), fingerprint(partner))
+	}
+	if strings.Contains(keys.PrivateKey, "PRIVATE KEY") || !strings.Contains(keys.PublicKey, "PUBLIC KEY") {
+		t.Errorf("expected a sealed private key and an armored public key, got %+v", keys)
+	}
+	private, err := v.open("tid1", keys.PrivateKey)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if ring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(private)); err != nil || ring[0].PrivateKey.Encrypted {
+		t.Errorf("expected the stored key to be unlocked, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  PGPKeyRequest
+	}{
+		{"missing", PGPKeyRequest{}},
+		{"garbage", PGPKeyRequest{PrivateKey: "not a key"}},
+		{"public only", PGPKeyRequest{PrivateKey: armoredPublic(t, ours)}},
+		{"wrong passphrase", PGPKeyRequest{PrivateKey: armoredPrivate(t, ours, "hunter2"), Passphrase: "nope"}},
+		{"bad verify key", PGPKeyRequest{PrivateKey: armoredPrivate(t, ours, ""), VerifyKey: "junk"}},
+	}
+	for _, tt := range tests {
+		if _, err := newTenantPGPKeys(v, "tid1", tt.req); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+// newPGPTestWorker creates a worker and tenant tid1 holding ours as its
+// decryption key and, when verify is not nil, verify as its signing key.
+func newPGPTestWorker(t *testing.T, ours, verify *openpgp.Entity) *Worker {
+	t.Helper()
+	w := newTenantTestWorker(t)
+	w.vault = newTestVault(t)
+	req := PGPKeyRequest{PrivateKey: armoredPrivate(t, ours, "")}
+	if verify != nil {
+		req.VerifyKey = armoredPublic(t, verify)
+	}
+	keys, err := newTenantPGPKeys(w.vault, "tid1", req)
+	if err != nil {
+		t.Fatalf("newTenantPGPKeys: %v", err)
+	}
+	if err := w.db.SaveTenantPGPKeys(keys); err != nil {
+		t.Fatalf("SaveTenantPGPKeys: %v", err)
+	}
+	return w
+}
+
+func TestProcessUploadEventDecryptsPGP(t *testing.T) {
+	ours := newTestEntity(t, "ours")
+	w := newPGPTestWorker(t, ours, nil)
+	store := w.store.(memStore)
+	store["tid1/a.csv.pgp"] = encryptTo(t, "key,title,value\nA1,Binary,1\n", ours, nil, false)
+	store["tid1/b.csv.asc"] = encryptTo(t, "key,title,value\nB1,Armored,2\n", ours, nil, true)
+	store["tid1/c.json.gpg"] = encryptTo(t, string(gzipBytes(t, `[{"key":"C1","title":"Gzip","value":3}]`)), ours, nil, false)
+
+	for i, name := range []string{"/a.csv.pgp", "/b.csv.asc", "/c.json.gpg"} {
+		if err := w.ProcessUploadEvent(context.Background(), int64(i+1), uploadEvent(name)); err != nil {
+			t.Fatalf("ProcessUploadEvent %s: %v", name, err)
+		}
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("records = %+v, want A1, B1 and C1", records)
+	}
+}
+
+func TestProcessUploadEventVerifiesPGPSignatures(t *testing.T) {
+	ours := newTestEntity(t, "ours")
+	partner := newTestEntity(t, "partner")
+	stranger := newTestEntity(t, "stranger")
+	w := newPGPTestWorker(t, ours, partner)
+	store := w.store.(memStore)
+	csv := "key,title,value\nR1,Signed,1\n"
+	store["tid1/signed.csv.pgp"] = encryptTo(t, csv, ours, partner, false)
+	store["tid1/unsigned.csv.pgp"] = encryptTo(t, csv, ours, nil, false)
+	store["tid1/stranger.csv.pgp"] = encryptTo(t, csv, ours, stranger, false)
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/signed.csv.pgp")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	for i, name := range []string{"/unsigned.csv.pgp", "/stranger.csv.pgp"} {
+		jobID := int64(i + 2)
+		if err := w.ProcessUploadEvent(context.Background(), jobID, uploadEvent(name)); !IsPermanent(err) {
+			t.Errorf("%s: expected permanent error, got %v", name, err)
+		}
+		imports, _ := w.db.ListJobImports(jobID)
+		if len(imports) != 1 || imports[0].Status != ImportFailed || imports[0].RowsInserted != 0 {
+			t.Errorf("%s: imports = %+v, want one failed import", name, imports)
+		}
+	}
+}
+
+func TestPGPReaderFailsOnBadSignatureAtEOF(t *testing.T) {
+	ours := newTestEntity(t, "ours")
+	partner := newTestEntity(t, "partner")
+	w := newPGPTestWorker(t, ours, partner)
+
+	// Sign a message, then corrupt the signature packet at its end. The
+	// payload still decrypts, but reading to EOF must fail.
+	var signed bytes.Buffer
+	sw, err := openpgp.Sign(&signed, partner, nil, nil)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	_, _ = io.WriteString(sw, "key,title,value\nR1,Tampered,1\n")
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	raw := signed.Bytes()
+	raw[len(raw)-10] ^= 0xff
+
+	var buf bytes.Buffer
+	ew, err := openpgp.Encrypt(&buf, []*openpgp.Entity{ours}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	// Encrypt the signed message as-is, so the corrupted signature ends up
+	// inside the encrypted payload.
+	_, _ = ew.Write(raw)
+	if err := ew.Close(); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	w.store.(memStore)["tid1/tampered.csv.pgp"] = buf.Bytes()
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/tampered.csv.pgp")); !IsPermanent(err) {
+		t.Errorf("expected permanent error for a tampered message, got %v", err)
+	}
+	if records, _ := w.db.ListRecords("tid1"); len(records) != 0 {
+		t.Errorf("expected no records from a tampered message, got %+v", records)
+	}
+}
+
+func TestProcessUploadEventPGPWithoutKeys(t *testing.T) {
+	ours := newTestEntity(t, "ours")
+	w := newTenantTestWorker(t)
+	w.store.(memStore)["tid1/a.csv.pgp"] = encryptTo(t, "key,title,value\nA1,X,1\n", ours, nil, false)
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/a.csv.pgp")); !IsPermanent(err) {
+		t.Errorf("without a master key: expected permanent error, got %v", err)
+	}
+	w.vault = newTestVault(t)
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/a.csv.pgp")); !IsPermanent(err) {
+		t.Errorf("without tenant keys: expected permanent error, got %v", err)
+	}
+
+	other := newTestEntity(t, "other")
+	keys, err := newTenantPGPKeys(w.vault, "tid1", PGPKeyRequest{PrivateKey: armoredPrivate(t, other, "")})
+	if err != nil {
+		t.Fatalf("newTenantPGPKeys: %v", err)
+	}
+	if err := w.db.SaveTenantPGPKeys(keys); err != nil {
+		t.Fatalf("SaveTenantPGPKeys: %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 3, uploadEvent("/a.csv.pgp")); !IsPermanent(err) {
+		t.Errorf("with the wrong key: expected permanent error, got %v", err)
+	}
+}
+
+func TestTenantPGPKeysHandlers(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	tenant, err := h.db.CreateTenant("tid1", "user1", "pass", "", "/data/tid1")
+	if err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	url := fmt.Sprintf("/api/tenants/%d/pgp", tenant.ID)
+	ours := newTestEntity(t, "ours")
+	body, _ := json.Marshal(PGPKeyRequest{PrivateKey: armoredPrivate(t, ours, "")})
+
+	rec := httptest.NewRecorder()
+	h.UpdateTenantPGPKeys(rec, httptest.NewRequest(http.MethodPut, url, bytes.NewReader(body)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("PUT without master key: status = %d, want 503", rec.Code)
+	}
+
+	h.vault = newTestVault(t)
+	rec = httptest.NewRecorder()
+	h.GetTenantPGPKeys(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET before PUT: status = %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.UpdateTenantPGPKeys(rec, httptest.NewRequest(http.MethodPut, url, strings.NewReader(`{"private_key":"junk"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT with invalid key: status = %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.UpdateTenantPGPKeys(rec, httptest.NewRequest(http.MethodPut, url, bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "PRIVATE") || strings.Contains(rec.Body.String(), "private_key") {
+		t.Errorf("PUT response leaks the private key: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetTenantPGPKeys(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	var got TenantPGPKeys
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Fingerprint != fingerprint(ours) || got.PublicKey == "" {
+		t.Errorf("GET = %+v, want fingerprint %s and public key", got, fingerprint(ours))
+	}
+
+	rec = httptest.NewRecorder()
+	h.DeleteTenantPGPKeys(rec, httptest.NewRequest(http.MethodDelete, url, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("DELETE: status = %d", rec.Code)
+	}
+	if keys, _ := h.db.GetTenantPGPKeys("tid1"); keys != nil {
+		t.Errorf("expected keys to be deleted, got %+v", keys)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// queuePollInterval is how often idle queue workers look for jobs whose
+// retry delay has elapsed. New jobs wake a worker immediately.
+const queuePollInterval = 2 * time.Second
+
+// JobHandler processes a single job. A returned error schedules a retry
+// unless it is wrapped with Permanent.
+type JobHandler func(ctx context.Context, job *Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying. Jobs failing with a permanent
+// error go straight to the dead-letter state.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Queue runs persisted jobs on a bounded pool of goroutines, retrying failed
+// jobs with exponential backoff.
+type Queue struct {
+	db      *DB
+	handler JobHandler
+	// kinds holds the handlers of jobs of other kinds than ingestion; see
+	// Handle.
+	kinds       map[string]JobHandler
+	workers     int
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+
+	wake chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewQueue creates a Queue that passes jobs to handler, except for the kinds
+// of jobs registered with Handle. handler may be nil when only such jobs are
+// enqueued.
+func NewQueue(db *DB, handler JobHandler, cfg Config) *Queue {
+	workers := cfg.WorkerConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		db:          db,
+		handler:     handler,
+		kinds:       make(map[string]JobHandler),
+		workers:     workers,
+		maxAttempts: cfg.JobMaxAttempts,
+		retryBase:   cfg.JobRetryBase,
+		retryMax:    cfg.JobRetryMax,
+		wake:        make(chan struct{}, workers),
+	}
+}
+
+// Enqueue persists payload as a new job for the default handler and wakes an
+// idle worker.
+func (q *Queue) Enqueue(payload map[string]any) (*Job, error) {
+	return q.EnqueueKind("", payload)
+}
+
+// EnqueueKind persists payload as a new job of the given kind, run by the
+// handler registered for it with Handle, and wakes an idle worker.
+func (q *Queue) EnqueueKind(kind string, payload map[string]any) (*Job, error) {
+	job, err := q.db.EnqueueKindJob(kind, payload, q.maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// EnqueueUnique enqueues payload like Enqueue unless a job with the same
+// dedupeKey was enqueued before, in which case it returns a nil job.
+func (q *Queue) EnqueueUnique(payload map[string]any, dedupeKey string) (*Job, error) {
+	job, err := q.db.EnqueueUniqueJob(payload, q.maxAttempts, dedupeKey)
+	if err != nil || job == nil {
+		return nil, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Handle registers handler for the jobs enqueued with EnqueueKind under
+// kind. It must be called before Start.
+func (q *Queue) Handle(kind string, handler JobHandler) {
+	q.kinds[kind] = handler
+}
+
+// Start requeues jobs interrupted by a previous shutdown or crash and starts
+// the workers. They stop when ctx is cancelled; use Wait to block until the
+// jobs in flight have finished.
+func (q *Queue) Start(ctx context.Context) error {
+	n, err := q.db.RequeueRunningJobs()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("queue: resumed %d interrupted jobs", n)
+	}
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.loop(ctx)
+	}
+	return nil
+}
+
+// Wait blocks until every worker started by Start has returned.
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *Queue) loop(ctx context.Context) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			job, err := q.db.ClaimJob()
+			if err != nil {
+				log.Printf("queue: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context, job *Job) {
+	err := q.call(ctx, job)
+	switch {
+	case err == nil:
+		err = q.db.CompleteJob(job.ID)
+	case ctx.Err() != nil:
+		log.Printf("queue: job %d interrupted by shutdown", job.ID)
+		err = q.db.ReleaseJob(job.ID)
+	case gaveUp(job, err):
+		log.Printf("queue: job %d failed permanently after %d attempts: %v", job.ID, job.Attempts, err)
+		err = q.db.BuryJob(job.ID, err.Error())
+	default:
+		delay := q.backoff(job.Attempts)
+		log.Printf("queue: job %d attempt %d failed, retrying in %s: %v", job.ID, job.Attempts, delay, err)
+		err = q.db.RetryJob(job.ID, delay, err.Error())
+	}
+	if err != nil {
+		log.Printf("queue: update job %d: %v", job.ID, err)
+	}
+}
+
+// gaveUp reports whether a job that failed with err is buried rather than
+// retried: when err is permanent or the job ran out of attempts.
+func gaveUp(job *Job, err error) bool {
+	return IsPermanent(err) || job.Attempts >= job.MaxAttempts
+}
+
+// call runs the handler, converting a panic into a permanent failure of the
+// job so one bad job cannot take the worker pool down.
+func (q *Queue) call(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("queue: job %d panicked: %v\n%s", job.ID, p, debug.Stack())
+			err = Permanent(fmt.Errorf("panic: %v", p))
+		}
+	}()
+	handler := q.handler
+	if job.Kind != "" {
+		handler = q.kinds[job.Kind]
+	}
+	if handler == nil {
+		return Permanent(fmt.Errorf("no handler for job %d", job.ID))
+	}
+	return handler(ctx, job)
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of failed attempts, doubling from retryBase up to retryMax.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.retryBase
+	for i := 1; i < attempts && delay < q.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > q.retryMax {
+		delay = q.retryMax
+	}
+	return delay
+}
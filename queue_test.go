@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, handler JobHandler) *Queue {
+	t.Helper()
+	q := NewQueue(newTestDB(t), handler, Config{WorkerConcurrency: 2, JobMaxAttempts: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		q.Wait()
+	})
+	return q
+}
+
+// waitForStatus polls until the job reaches status or the test times out.
+func waitForStatus(t *testing.T, db *DB, id int64, status string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := db.GetJob(id)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d status = %q, want %q", id, job.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueRunsJob(t *testing.T) {
+	var got atomic.Value
+	q := newTestQueue(t, func(ctx context.Context, job *Job) error {
+		got.Store(job.Payload["virtual_path"])
+		return nil
+	})
+
+	job, err := q.Enqueue(map[string]any{"virtual_path": "/a.csv"})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitForStatus(t, q.db, job.ID, JobDone)
+	if got.Load() != "/a.csv" {
+		t.Errorf("handler saw %v, want /a.csv", got.Load())
+	}
+}
+
+func TestQueueRetriesThenSucceeds(t *testing.T) {
+	var calls atomic.Int32
+	q := newTestQueue(t, func(ctx context.Context, job *Job) error {
+		if calls.Add(1) < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	job, err := q.Enqueue(map[string]any{})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	done := waitForStatus(t, q.db, job.ID, JobDone)
+	if done.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", done.Attempts)
+	}
+}
+
+func TestQueueDeadLettersAfterMaxAttempts(t *testing.T) {
+	q := newTestQueue(t, func(ctx context.Context, job *Job) error {
+		return errors.New("still broken")
+	})
+
+	job, err := q.Enqueue(map[string]any{})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	dead := waitForStatus(t, q.db, job.ID, JobDead)
+	if dead.Attempts != 3 {
+		t.Errorf("attempts = %d, want 3", dead.Attempts)
+	}
+	if dead.LastError != "still broken" {
+		t.Errorf("last error = %q, want %q", dead.LastError, "still broken")
+	}
+}
+
+func TestQueuePermanentErrorSkipsRetries(t *testing.T) {
+	q := newTestQueue(t, func(ctx context.Context, job *Job) error {
+		return Permanent(errors.New("bad file"))
+	})
+
+	job, err := q.Enqueue(map[string]any{})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	dead := waitForStatus(t, q.db, job.ID, JobDead)
+	if dead.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", dead.Attempts)
+	}
+}
+
+func TestQueueResumesInterruptedJobs(t *testing.T) {
+	db := newTestDB(t)
+	job, err := db.EnqueueJob(map[string]any{}, 3)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if _, err := db.ClaimJob(); err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+
+	q := NewQueue(db, func(ctx context.Context, job *Job) error { return nil }, Config{JobMaxAttempts: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		q.Wait()
+	}()
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitForStatus(t, db, job.ID, JobDone)
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := &Queue{retryBase: time.Second, retryMax: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	err := Permanent(errors.New("bad"))
+	if !IsPermanent(err) {
+		t.Error("expected permanent error")
+	}
+	if !IsPermanent(errors.Join(errors.New("context"), err)) {
+		t.Error("expected wrapped permanent error to be detected")
+	}
+	if IsPermanent(errors.New("transient")) {
+		t.Error("plain error should not be permanent")
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) should be nil")
+	}
+}
+
+func TestQueueRecoversFromPanic(t *testing.T) {
+	q := newTestQueue(t, func(ctx context.Context, job *Job) error {
+		if job.Payload["explode"] == true {
+			panic("bad job")
+		}
+		return nil
+	})
+
+	bad, err := q.Enqueue(map[string]any{"explode": true})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	dead := waitForStatus(t, q.db, bad.ID, JobDead)
+	if dead.LastError != "panic: bad job" {
+		t.Errorf("last error = %q, want the panic message", dead.LastError)
+	}
+
+	good, err := q.Enqueue(map[string]any{})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitForStatus(t, q.db, good.ID, JobDone)
+}
+
+func TestQueueRoutesJobKinds(t *testing.T) {
+	var kinds atomic.Int32
+	q := NewQueue(newTestDB(t), nil, Config{JobMaxAttempts: 3})
+	q.Handle("ping", func(ctx context.Context, job *Job) error {
+		kinds.Add(1)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		q.Wait()
+	}()
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ping, err := q.EnqueueKind("ping", map[string]any{})
+	if err != nil {
+		t.Fatalf("EnqueueKind: %v", err)
+	}
+	waitForStatus(t, q.db, ping.ID, JobDone)
+	if kinds.Load() != 1 {
+		t.Errorf("ping handler ran %d times, want 1", kinds.Load())
+	}
+
+	// Without a default handler, other jobs fail at once. A kind in the
+	// payload does not route the job.
+	for _, payload := range []map[string]any{{"virtual_path": "/a.csv"}, {"kind": "ping"}} {
+		job, err := q.Enqueue(payload)
+		if err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if dead := waitForStatus(t, q.db, job.ID, JobDead); dead.Attempts != 1 {
+			t.Errorf("%v: attempts = %d, want 1", payload, dead.Attempts)
+		}
+	}
+	if kinds.Load() != 1 {
+		t.Errorf("ping handler ran %d times, want 1", kinds.Load())
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// scanGracePeriod leaves recent uploads to the upload hook, which normally
+// reports them within seconds.
+const scanGracePeriod = time.Minute
+
+// Scanner periodically lists the prefix of every tenant and enqueues the
+// uploads that were never imported, as a fallback for missed upload hooks and
+// for files put into the bucket without going through SFTPGo.
+type Scanner struct {
+	db       *DB
+	store    ObjectStore
+	queue    *Queue
+	interval time.Duration
+	// include and exclude are glob patterns; see matchGlob.
+	include []string
+	exclude []string
+}
+
+// NewScanner creates a Scanner that enqueues jobs on queue.
+func NewScanner(db *DB, store ObjectStore, queue *Queue, cfg Config) (*Scanner, error) {
+	for _, pattern := range append(append([]string{}, cfg.ScanInclude...), cfg.ScanExclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid scan pattern %q: %w", pattern, err)
+		}
+	}
+	return &Scanner{
+		db:       db,
+		store:    store,
+		queue:    queue,
+		interval: cfg.ScanInterval,
+		include:  cfg.ScanInclude,
+		exclude:  cfg.ScanExclude,
+	}, nil
+}
+
+// Run scans once straight away and then every interval until ctx is
+// cancelled.
+func (s *Scanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.Scan(ctx)
+		if err != nil {
+			log.Printf("scanner: %v", err)
+		}
+		if n > 0 {
+			log.Printf("scanner: queued %d missed uploads", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan enqueues the uploads of every tenant that were never imported and
+// returns how many it enqueued. A failing tenant does not stop the others.
+func (s *Scanner) Scan(ctx context.Context) (int, error) {
+	tenants, err := s.db.ListTenants()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	var firstErr error
+	for _, tenant := range tenants {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		n, err := s.scanTenant(ctx, tenant)
+		total += n
+		if err != nil {
+			log.Printf("scanner: tenant %s: %v", tenant.TenantID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return total, firstErr
+}
+
+// scanTenant enqueues each object under the tenant's prefix that the
+// patterns select, that is older than scanGracePeriod and that no import has
+// seen with its current ETag. Each object version is enqueued at most once,
+// so files that fail or are skipped are not picked up again until they
+// change.
+func (s *Scanner) scanTenant(ctx context.Context, tenant Tenant) (int, error) {
+	settings, err := s.db.GetTenantSettings(tenant.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	prefix := tenant.TenantID + "/"
+	objects, err := s.store.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, prefix)
+		switch {
+		case rel == "" || strings.HasSuffix(rel, "/"),
+			strings.HasSuffix(strings.ToLower(rel), errorReportSuffix),
+			settings.FileDisposition.holds("/" + rel),
+			!s.selects(rel),
+			time.Since(obj.LastModified) < scanGracePeriod:
+			continue
+		}
+		seen, err := s.db.HasImport(tenant.TenantID, obj.Key, obj.ETag)
+		if err != nil {
+			return queued, err
+		}
+		if seen {
+			continue
+		}
+		event := map[string]any{
+			"action":       "upload",
+			"username":     tenant.Username,
+			"virtual_path": "/" + rel,
+			"source":       "scan",
+		}
+		job, err := s.queue.EnqueueUnique(event, uploadDedupeKey(obj))
+		if err != nil {
+			return queued, err
+		}
+		if job != nil {
+			log.Printf("scanner: queued %s as job %d", obj.Key, job.ID)
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// selects reports whether the file at rel, relative to the tenant's home
+// directory, matches an include pattern, or there are none, and no exclude
+// pattern.
+func (s *Scanner) selects(rel string) bool {
+	included := len(s.include) == 0
+	for _, pattern := range s.include {
+		included = included || matchGlob(pattern, rel)
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range s.exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob matches rel against a path.Match pattern. Patterns without a
+// slash match the file name in any folder, so *.csv matches in/data.csv;
+// patterns with one match the whole relative path.
+func matchGlob(pattern, rel string) bool {
+	name := rel
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name)
+	return ok
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recentStore reports the objects listed in recent as just modified.
+type recentStore struct {
+	memStore
+	recent map[string]bool
+}
+
+func (s recentStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects, err := s.memStore.List(ctx, prefix)
+	for i := range objects {
+		if s.recent[objects[i].Key] {
+			objects[i].LastModified = time.Now()
+		}
+	}
+	return objects, err
+}
+
+func newTestScanner(t *testing.T, cfg Config) (*Scanner, *Worker) {
+	t.Helper()
+	w := newTenantTestWorker(t)
+	cfg.JobMaxAttempts = 3
+	s, err := NewScanner(w.db, w.store, NewQueue(w.db, nil, cfg), cfg)
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	return s, w
+}
+
+func TestScannerQueuesMissedUploads(t *testing.T) {
+	s, w := newTestScanner(t, Config{ScanExclude: []string{"tmp/*"}})
+	store := w.store.(memStore)
+	row := []byte("key,title,value\nR1,First,1\n")
+	store["tid1/missed.csv"] = row
+	store["tid1/in/nested.csv"] = row
+	store["tid1/imported.csv"] = row
+	store["tid1/imported.csv.errors.csv"] = row
+	store["tid1/processed/old.csv"] = row
+	store["tid1/tmp/partial.csv"] = row
+	store["tid1/uploading.csv"] = row
+	store["other/unknown.csv"] = row
+	s.store = recentStore{store, map[string]bool{"tid1/uploading.csv": true}}
+
+	if err := w.ProcessUploadEvent(context.Background(), 100, uploadEvent("/imported.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	settings := DefaultTenantSettings()
+	settings.FileDisposition.Mode = DispositionMove
+	if err := w.db.SaveTenantSettings("tid1", settings); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+
+	n, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Scan queued %d uploads, want 2", n)
+	}
+	var paths []string
+	for id := int64(1); id <= 2; id++ {
+		job, err := w.db.GetJob(id)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if job.Payload["username"] != "user1" || job.Payload["source"] != "scan" {
+			t.Errorf("job payload = %v, want a scan event for user1", job.Payload)
+		}
+		paths = append(paths, job.Payload["virtual_path"].(string))
+	}
+	if paths[0] != "/in/nested.csv" || paths[1] != "/missed.csv" {
+		t.Errorf("queued %v, want /in/nested.csv and /missed.csv", paths)
+	}
+
+	// Queued objects are not queued again, even before their jobs ran.
+	if n, err := s.Scan(context.Background()); err != nil || n != 0 {
+		t.Errorf("second Scan = %d, %v, want nothing queued", n, err)
+	}
+
+	// A changed object is.
+	store["tid1/missed.csv"] = []byte("key,title,value\nR1,Changed,2\n")
+	if n, err := s.Scan(context.Background()); err != nil || n != 1 {
+		t.Errorf("Scan after change = %d, %v, want 1", n, err)
+	}
+}
+
+func TestScannerIncludePatterns(t *testing.T) {
+	s, w := newTestScanner(t, Config{ScanInclude: []string{"*.csv", "exports/*.json"}, ScanExclude: []string{"*draft*"}})
+	store := w.store.(memStore)
+	for _, key := range []string{"tid1/a.csv", "tid1/deep/b.csv", "tid1/exports/c.json", "tid1/d.json", "tid1/draft.csv", "tid1/e.txt"} {
+		store[key] = []byte("x")
+	}
+	if n, err := s.Scan(context.Background()); err != nil || n != 3 {
+		t.Errorf("Scan = %d, %v, want a.csv, deep/b.csv and exports/c.json", n, err)
+	}
+}
+
+func TestNewScannerRejectsBadPatterns(t *testing.T) {
+	if _, err := NewScanner(nil, nil, nil, Config{ScanExclude: []string{"[a-"}}); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.csv", "data.csv", true},
+		{"*.csv", "in/data.csv", true},
+		{"in/*.csv", "in/data.csv", true},
+		{"/in/*.csv", "in/data.csv", true},
+		{"in/*.csv", "out/data.csv", false},
+		{"in/*.csv", "in/deep/data.csv", false},
+		{"*.CSV", "data.csv", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Record fields a tenant schema can map source columns to.
+const (
+	FieldKey         = "key"
+	FieldTitle       = "title"
+	FieldDescription = "description"
+	FieldCategory    = "category"
+	FieldValue       = "value"
+)
+
+// recordFields lists the mappable fields in the order they are resolved.
+var recordFields = []string{FieldKey, FieldTitle, FieldDescription, FieldCategory, FieldValue}
+
+// Transform types applied to a source value, in the order they are listed.
+const (
+	TransformTrim  = "trim"
+	TransformUpper = "upper"
+	TransformLower = "lower"
+	// TransformRegexExtract replaces the value with the first capture group
+	// of Pattern, or the whole match when the pattern has no groups. A value
+	// that does not match becomes empty.
+	TransformRegexExtract = "regex_extract"
+)
+
+// Transform is a single step applied to a source value.
+type Transform struct {
+	Type    string `json:"type" enums:"trim,upper,lower,regex_extract"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// SchemaField describes how one record field is read from a file.
+type SchemaField struct {
+	// Source is the header of the column holding the field, matched
+	// case-insensitively. It defaults to the field name.
+	Source string `json:"source,omitempty"`
+	// Required rejects rows whose value is empty after transforms and the
+	// default are applied. A file without the column fails unless Default
+	// is set.
+	Required bool `json:"required"`
+	// Default is used when the column is absent or the value is empty
+	// after transforms.
+	Default    string      `json:"default,omitempty"`
+	Transforms []Transform `json:"transforms,omitempty"`
+}
+
+// TenantSchema maps the columns of a tenant's files to record fields. Fields
+// missing from the map are left empty.
+type TenantSchema struct {
+	Fields map[string]SchemaField `json:"fields"`
+}
+
+// DefaultTenantSchema returns the schema used for tenants that have not
+// configured one: every field is read from the column of the same name and
+// trimmed, and key, title and value are required.
+func DefaultTenantSchema() TenantSchema {
+	trim := []Transform{{Type: TransformTrim}}
+	return TenantSchema{Fields: map[string]SchemaField{
+		FieldKey:         {Required: true, Transforms: trim},
+		FieldTitle:       {Required: true, Transforms: trim},
+		FieldDescription: {Transforms: trim},
+		FieldCategory:    {Transforms: trim},
+		FieldValue:       {Required: true, Transforms: trim},
+	}}
+}
+
+// Validate reports whether the schema maps known fields with supported
+// transforms. The key and value fields must always be mapped, and the key
+// must be required.
+func (s TenantSchema) Validate() error {
+	for name, f := range s.Fields {
+		if !isRecordField(name) {
+			return fmt.Errorf("unknown field %q, must be one of %s", name, strings.Join(recordFields, ", "))
+		}
+		if _, err := compileTransforms(f.Transforms); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	for _, name := range []string{FieldKey, FieldValue} {
+		if _, ok := s.Fields[name]; !ok {
+			return fmt.Errorf("field %s must be mapped", name)
+		}
+	}
+	if !s.Fields[FieldKey].Required {
+		return fmt.Errorf("field %s must be required", FieldKey)
+	}
+	return nil
+}
+
+func isRecordField(name string) bool {
+	for _, f := range recordFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnMapping is a schema resolved against the header of one file.
+type columnMapping struct {
+	fields []boundField
+	extras []extraColumn
+}
+
+// extraColumn is a header column no field is mapped to. Its values are kept
+// as record attributes.
+type extraColumn struct {
+	name  string
+	index int
+}
+
+type boundField struct {
+	name       string
+	column     string
+	index      int // -1 when the file has no such column
+	spec       SchemaField
+	transforms []func(string) string
+}
+
+// bind resolves the schema against header. It fails when a required field
+// without a default has no column in the file.
+func (s TenantSchema) bind(header []string) (*columnMapping, error) {
+	colIndex := make(map[string]int, len(header))
+	for i, h := range header {
+		name := strings.TrimSpace(strings.ToLower(h))
+		if _, dup := colIndex[name]; !dup {
+			colIndex[name] = i
+		}
+	}
+
+	m := &columnMapping{}
+	for _, name := range recordFields {
+		spec, ok := s.Fields[name]
+		if !ok {
+			continue
+		}
+		column := spec.Source
+		if column == "" {
+			column = name
+		}
+		index, ok := colIndex[strings.TrimSpace(strings.ToLower(column))]
+		if !ok {
+			index = -1
+			if spec.Required && spec.Default == "" {
+				return nil, fmt.Errorf("CSV missing required column: %s", column)
+			}
+		}
+		transforms, err := compileTransforms(spec.Transforms)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		m.fields = append(m.fields, boundField{name: name, column: column, index: index, spec: spec, transforms: transforms})
+	}
+
+	mapped := make(map[int]bool, len(m.fields))
+	for _, f := range m.fields {
+		mapped[f.index] = true
+	}
+	for i, h := range header {
+		name := strings.TrimSpace(strings.ToLower(h))
+		if name == "" || mapped[i] || colIndex[name] != i {
+			continue
+		}
+		m.extras = append(m.extras, extraColumn{name: name, index: i})
+	}
+	return m, nil
+}
+
+// fieldError describes why a row could not be mapped.
+type fieldError struct {
+	column string
+	reason string
+}
+
+// apply maps row to record field values. Fields the schema does not map
+// are absent from the result and read as empty. Every mapped field is
+// resolved even when an earlier one fails, so the key of a rejected row is
+// still known; the error describes the first failure.
+func (m *columnMapping) apply(row []string) (map[string]string, *fieldError) {
+	values := make(map[string]string, len(m.fields))
+	var ferr *fieldError
+	for _, f := range m.fields {
+		v := field(row, f.index)
+		for _, t := range f.transforms {
+			v = t(v)
+		}
+		if v == "" {
+			v = f.spec.Default
+		}
+		// An empty key is rejected even under schemas saved before the key
+		// had to be required.
+		if v == "" && (f.spec.Required || f.name == FieldKey) && ferr == nil {
+			ferr = &fieldError{column: f.column, reason: fmt.Sprintf("required field %s is empty", f.name)}
+		}
+		values[f.name] = v
+	}
+	return values, ferr
+}
+
+// attributes returns the trimmed values of the unmapped columns of row,
+// keyed by lower-cased header. Empty values are left out.
+func (m *columnMapping) attributes(row []string) map[string]string {
+	attrs := make(map[string]string, len(m.extras))
+	for _, c := range m.extras {
+		if v := strings.TrimSpace(field(row, c.index)); v != "" {
+			attrs[c.name] = v
+		}
+	}
+	return attrs
+}
+
+// column returns the source column of a mapped field, or the field name.
+func (m *columnMapping) column(name string) string {
+	for _, f := range m.fields {
+		if f.name == name {
+			return f.column
+		}
+	}
+	return name
+}
+
+func compileTransforms(ts []Transform) ([]func(string) string, error) {
+	funcs := make([]func(string) string, 0, len(ts))
+	for _, t := range ts {
+		switch t.Type {
+		case TransformTrim:
+			funcs = append(funcs, strings.TrimSpace)
+		case TransformUpper:
+			funcs = append(funcs, strings.ToUpper)
+		case TransformLower:
+			funcs = append(funcs, strings.ToLower)
+		case TransformRegexExtract:
+			if t.Pattern == "" {
+				return nil, fmt.Errorf("regex_extract requires a pattern")
+			}
+			re, err := regexp.Compile(t.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", t.Pattern, err)
+			}
+			funcs = append(funcs, func(s string) string {
+				match := re.FindStringSubmatch(s)
+				switch {
+				case match == nil:
+					return ""
+				case len(match) > 1:
+					return match[1]
+				default:
+					return match[0]
+				}
+			})
+		default:
+			return nil, fmt.Errorf("unknown transform %q, must be one of %s, %s, %s or %s",
+				t.Type, TransformTrim, TransformUpper, TransformLower, TransformRegexExtract)
+		}
+	}
+	return funcs, nil
+}
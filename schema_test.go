@@ -0,0 +1,123 @@
+package main
+
+import "testing"
+
+func TestTenantSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  TenantSchema
+		wantErr bool
+	}{
+		{"default", DefaultTenantSchema(), false},
+		{"key and value only", TenantSchema{Fields: map[string]SchemaField{FieldKey: {Required: true}, FieldValue: {}}}, false},
+		{"missing key", TenantSchema{Fields: map[string]SchemaField{FieldValue: {}}}, true},
+		{"optional key", TenantSchema{Fields: map[string]SchemaField{FieldKey: {}, FieldValue: {}}}, true},
+		{"missing value", TenantSchema{Fields: map[string]SchemaField{FieldKey: {Required: true}}}, true},
+		{"unknown field", TenantSchema{Fields: map[string]SchemaField{FieldKey: {Required: true}, FieldValue: {}, "price": {}}}, true},
+		{"unknown transform", TenantSchema{Fields: map[string]SchemaField{
+			FieldKey:   {Required: true, Transforms: []Transform{{Type: "reverse"}}},
+			FieldValue: {},
+		}}, true},
+		{"regex without pattern", TenantSchema{Fields: map[string]SchemaField{
+			FieldKey:   {Required: true, Transforms: []Transform{{Type: TransformRegexExtract}}},
+			FieldValue: {},
+		}}, true},
+		{"invalid regex", TenantSchema{Fields: map[string]SchemaField{
+			FieldKey:   {Required: true, Transforms: []Transform{{Type: TransformRegexExtract, Pattern: "(["}}},
+			FieldValue: {},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schema.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestColumnMappingApply(t *testing.T) {
+	schema := TenantSchema{Fields: map[string]SchemaField{
+		FieldKey: {Source: "SKU", Required: true, Transforms: []Transform{
+			{Type: TransformTrim},
+			{Type: TransformUpper},
+		}},
+		FieldTitle: {Source: "name", Transforms: []Transform{{Type: TransformTrim}}},
+		FieldCategory: {Source: "ref", Default: "misc", Transforms: []Transform{
+			{Type: TransformRegexExtract, Pattern: `^([A-Z]+)-\d+$`},
+		}},
+		FieldValue: {Source: "price", Default: "0"},
+	}}
+
+	m, err := schema.bind([]string{"sku", " Name ", "ref", "price"})
+	if err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+
+	tests := []struct {
+		row        []string
+		want       map[string]string
+		wantColumn string
+	}{
+		{
+			row:  []string{" ab-1 ", " Widget ", "TOOLS-42", "9.5"},
+			want: map[string]string{FieldKey: "AB-1", FieldTitle: "Widget", FieldCategory: "TOOLS", FieldValue: "9.5"},
+		},
+		{
+			row:  []string{"ab-2", "Gadget", "not a ref", ""},
+			want: map[string]string{FieldKey: "AB-2", FieldTitle: "Gadget", FieldCategory: "misc", FieldValue: "0"},
+		},
+		{
+			row:        []string{"  ", "Nameless", "X-1", "1"},
+			want:       map[string]string{FieldKey: "", FieldTitle: "Nameless", FieldCategory: "X", FieldValue: "1"},
+			wantColumn: "SKU",
+		},
+	}
+	for _, tt := range tests {
+		values, ferr := m.apply(tt.row)
+		for name, want := range tt.want {
+			if values[name] != want {
+				t.Errorf("row %q: %s = %q, want %q", tt.row, name, values[name], want)
+			}
+		}
+		switch {
+		case tt.wantColumn == "" && ferr != nil:
+			t.Errorf("row %q: unexpected error %+v", tt.row, ferr)
+		case tt.wantColumn != "" && (ferr == nil || ferr.column != tt.wantColumn):
+			t.Errorf("row %q: error = %+v, want one on column %s", tt.row, ferr, tt.wantColumn)
+		}
+	}
+}
+
+func TestColumnMappingApplyRejectsEmptyKey(t *testing.T) {
+	// A schema saved before the key had to be required.
+	schema := TenantSchema{Fields: map[string]SchemaField{FieldKey: {}, FieldValue: {}}}
+	m, err := schema.bind([]string{"key", "value"})
+	if err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	if _, ferr := m.apply([]string{"", "1"}); ferr == nil || ferr.column != FieldKey {
+		t.Errorf("error = %+v, want one on column key", ferr)
+	}
+}
+
+func TestBindMissingColumn(t *testing.T) {
+	schema := TenantSchema{Fields: map[string]SchemaField{
+		FieldKey:   {Source: "sku", Required: true},
+		FieldValue: {Source: "price", Required: true, Default: "0"},
+		FieldTitle: {Source: "name"},
+	}}
+
+	m, err := schema.bind([]string{"sku"})
+	if err != nil {
+		t.Fatalf("bind with defaults and optional fields: %v", err)
+	}
+	values, ferr := m.apply([]string{"A1"})
+	if ferr != nil || values[FieldValue] != "0" || values[FieldTitle] != "" {
+		t.Errorf("values = %v, err = %+v, want value 0 and empty title", values, ferr)
+	}
+
+	if _, err := schema.bind([]string{"name", "price"}); err == nil {
+		t.Error("expected error when the required key column is missing")
+	}
+}
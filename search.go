@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ensureSearchIndex creates records_fts, the FTS5 index over the title and
+// description of records, and the triggers that keep it in sync. It stores
+// no copy of the text, and is filled from the existing records when it is
+// first created.
+func ensureSearchIndex(conn *sql.DB) error {
+	var exists bool
+	if err := conn.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'records_fts')").Scan(&exists); err != nil {
+		return fmt.Errorf("migrate records_fts: %w", err)
+	}
+	if _, err := conn.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS records_fts USING fts5(
+			title, description,
+			content='records', content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2'
+		);
+		CREATE TRIGGER IF NOT EXISTS records_fts_insert AFTER INSERT ON records BEGIN
+			INSERT INTO records_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+		END;
+		CREATE TRIGGER IF NOT EXISTS records_fts_delete AFTER DELETE ON records BEGIN
+			INSERT INTO records_fts (records_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+		END;
+		CREATE TRIGGER IF NOT EXISTS records_fts_update AFTER UPDATE OF title, description ON records BEGIN
+			INSERT INTO records_fts (records_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+			INSERT INTO records_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+		END;
+	`); err != nil {
+		return fmt.Errorf("migrate records_fts: %w", err)
+	}
+	if !exists {
+		if _, err := conn.Exec("INSERT INTO records_fts (records_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("migrate records_fts: %w", err)
+		}
+	}
+	return nil
+}
+
+// ftsQuery turns a search typed by a user into an FTS5 query. Words are
+// matched anywhere in the title or description and must all be present;
+// "double quotes" match a phrase, and a trailing * matches words starting
+// with the text before it, as in invoic* or "annual rep"*. Everything else
+// is taken literally, so the search cannot inject FTS5 operators.
+func ftsQuery(search string) (string, error) {
+	var terms []string
+	rest := strings.TrimSpace(search)
+	for rest != "" {
+		var term string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return "", errors.New("unterminated phrase")
+			}
+			term, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			term, rest = rest[:end], rest[end:]
+		}
+		prefix := strings.HasSuffix(term, "*")
+		if prefix {
+			term = strings.TrimRight(term, "*")
+		} else if strings.HasPrefix(rest, "*") {
+			prefix, rest = true, rest[1:]
+		}
+		if term = strings.TrimSpace(term); term != "" {
+			term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+			if prefix {
+				term += "*"
+			}
+			terms = append(terms, term)
+		}
+		rest = strings.TrimLeft(rest, " \t\n")
+	}
+	if len(terms) == 0 {
+		return "", errors.New("empty search")
+	}
+	return strings.Join(terms, " "), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"invoice", `"invoice"`},
+		{"  annual   invoice ", `"annual" "invoice"`},
+		{"invoic*", `"invoic"*`},
+		{`"annual report"`, `"annual report"`},
+		{`"annual rep"* emea`, `"annual rep"* "emea"`},
+		{`e-mail OR title:x NEAR(a)`, `"e-mail" "OR" "title:x" "NEAR(a)"`},
+		{`say"hi"`, `"say""hi"""`},
+	}
+	for _, tt := range tests {
+		got, err := ftsQuery(tt.search)
+		if err != nil || got != tt.want {
+			t.Errorf("ftsQuery(%q) = %q, %v, want %q", tt.search, got, err, tt.want)
+		}
+	}
+	for _, search := range []string{"", "  ", `"unterminated`, "*", `""`} {
+		if got, err := ftsQuery(search); err == nil {
+			t.Errorf("ftsQuery(%q) = %q, expected error", search, got)
+		}
+	}
+}
+
+func upsertSearchRecords(t *testing.T, db *DB, records ...Record) {
+	t.Helper()
+	tx, err := db.BeginImport("tid1")
+	if err != nil {
+		t.Fatalf("BeginImport: %v", err)
+	}
+	for _, r := range records {
+		if _, err := tx.UpsertRecord(r); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+}
+
+func searchKeys(t *testing.T, db *DB, search string) []string {
+	t.Helper()
+	q, err := ftsQuery(search)
+	if err != nil {
+		t.Fatalf("ftsQuery(%q): %v", search, err)
+	}
+	records, err := db.FindRecords("tid1", RecordFilter{Query: q})
+	if err != nil {
+		t.Fatalf("FindRecords(%q): %v", search, err)
+	}
+	var keys []string
+	for _, r := range records {
+		keys = append(keys, r.RecordKey)
+	}
+	return keys
+}
+
+func TestFindRecordsSearch(t *testing.T) {
+	db := newTestDB(t)
+	upsertSearchRecords(t, db,
+		Record{RecordKey: "R1", Title: "Annual report", Description: "Invoices for the EMEA region"},
+		Record{RecordKey: "R2", Title: "Invoice", Description: "Invoice of an invoice, about the annual report"},
+		Record{RecordKey: "R3", Title: "Café menu", Description: "Report on the report of the annual"},
+	)
+	if err := db.UpsertRecord("tid2", "R9", "Annual report", "", "", 1); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"annual", "R1 R2 R3"},
+		{`"annual report"`, "R1 R2"},
+		{"invoic*", "R2 R1"},
+		{"cafe", "R3"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(searchKeys(t, db, tt.search), " "); got != tt.want {
+			t.Errorf("search %s = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+
+	// Updates and deletes keep the index in sync.
+	upsertSearchRecords(t, db, Record{RecordKey: "R3", Title: "Menu", Description: "Nothing"})
+	if got := searchKeys(t, db, "annual"); strings.Join(got, " ") != "R1 R2" {
+		t.Errorf("search after update = %v, want R1 R2", got)
+	}
+	if _, err := db.conn.Exec("DELETE FROM records WHERE record_key = 'R1'"); err != nil {
+		t.Fatalf("delete R1: %v", err)
+	}
+	if got := searchKeys(t, db, "annual"); strings.Join(got, " ") != "R2" {
+		t.Errorf("search after delete = %v, want R2", got)
+	}
+
+	records, err := db.FindRecords("tid2", RecordFilter{Query: `"annual"`})
+	if err != nil || len(records) != 1 || records[0].Snippet != "<mark>Annual</mark> report" {
+		t.Errorf("FindRecords = %+v, %v, want R9 with a highlighted snippet", records, err)
+	}
+}
+
+func TestNewDBBuildsSearchIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	upsertSearchRecords(t, db, Record{RecordKey: "R1", Title: "Annual report"})
+	// A database from before the index existed.
+	if _, err := db.conn.Exec("DROP TABLE records_fts; DROP TRIGGER records_fts_insert"); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	_ = db.Close()
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if got := searchKeys(t, db, "annual"); len(got) != 1 {
+		t.Errorf("search = %v, want R1 indexed on migration", got)
+	}
+}
+
+func TestListTenantRecordsHandlerSearch(t *testing.T) {
+	h := newTestHandlers(t, nil)
+	if _, err := h.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	upsertSearchRecords(t, h.db,
+		Record{RecordKey: "R1", Title: "Annual report", Attributes: map[string]string{"region": "emea"}},
+		Record{RecordKey: "R2", Title: "Annual report", Attributes: map[string]string{"region": "apac"}},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tenants/1/records?q=annu*&attr.region=apac", nil)
+	rec := httptest.NewRecorder()
+	h.ListTenantRecords(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var records []Record
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "R2" || !strings.Contains(records[0].Snippet, "<mark>Annual</mark>") {
+		t.Errorf("records = %+v, want R2 with a snippet", records)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, `/api/tenants/1/records?q=%22annual`, nil)
+	rec = httptest.NewRecorder()
+	h.ListTenantRecords(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d for an unterminated phrase", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ingestion modes control what happens to existing records that are absent
+// from an uploaded file.
+const (
+	// IngestIncremental upserts the rows of each file and leaves every other
+	// record untouched.
+	IngestIncremental = "incremental"
+	// IngestSnapshot treats each file as the complete record set for the
+	// tenant: records missing from the file are deleted in the same
+	// transaction that applies the upserts.
+	IngestSnapshot = "snapshot"
+)
+
+// Import policies control how an import reacts to rows it cannot apply.
+const (
+	// ImportAtomic rolls the whole file back on the first bad row.
+	ImportAtomic = "atomic"
+	// ImportBestEffort skips bad rows and commits everything else.
+	ImportBestEffort = "best_effort"
+)
+
+// Ragged row policies control rows whose field count differs from the header.
+const (
+	// RaggedReject rejects the row like any other invalid row.
+	RaggedReject = "reject"
+	// RaggedPad reads missing trailing fields as empty and ignores extra ones.
+	RaggedPad = "pad"
+	// RaggedFail fails the whole file, regardless of the import policy.
+	RaggedFail = "fail"
+)
+
+// DialectAuto makes the worker detect the delimiter, quote character or
+// encoding of each file from its first bytes.
+const DialectAuto = "auto"
+
+// Supported file encodings. Files are transcoded to UTF-8 before parsing.
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+	EncodingISO88591    = "iso-8859-1"
+)
+
+// TenantSettings holds the per-tenant options that control file ingestion.
+type TenantSettings struct {
+	IngestMode   string `json:"ingest_mode" enums:"incremental,snapshot"`
+	ImportPolicy string `json:"import_policy" enums:"atomic,best_effort"`
+	RaggedRows   string `json:"ragged_rows" enums:"reject,pad,fail"`
+	// LazyQuotes accepts stray quotes inside unquoted fields as literal text
+	// instead of rejecting the row.
+	LazyQuotes bool `json:"lazy_quotes"`
+	// Delimiter separates fields: one of , ; | or a tab, or "auto".
+	Delimiter string `json:"delimiter" example:"auto"`
+	// Quote encloses fields: a double or single quote, or "auto".
+	Quote    string `json:"quote" example:"auto"`
+	Encoding string `json:"encoding" enums:"auto,utf-8,utf-16le,utf-16be,windows-1252,iso-8859-1"`
+	// NumberFormat describes how the value column writes numbers.
+	NumberFormat NumberFormat `json:"number_format"`
+	// ExactDecimals also stores each value as the decimal text it was written
+	// as, since the REAL value column cannot hold every amount exactly.
+	ExactDecimals bool `json:"exact_decimals"`
+	// Sheet names the worksheet read from Excel uploads, matched without
+	// regard to case; empty reads the first sheet.
+	Sheet string `json:"sheet"`
+	// FileDisposition moves uploads out of the way once they are ingested.
+	FileDisposition FileDisposition `json:"file_disposition"`
+	// RetractOnDelete deletes the records an upload wrote when the partner
+	// deletes the file over SFTP.
+	RetractOnDelete bool `json:"retract_on_delete"`
+}
+
+// DefaultTenantSettings returns the settings used for tenants that have not
+// configured anything.
+func DefaultTenantSettings() TenantSettings {
+	return TenantSettings{
+		IngestMode:      IngestIncremental,
+		ImportPolicy:    ImportAtomic,
+		RaggedRows:      RaggedReject,
+		Delimiter:       DialectAuto,
+		Quote:           DialectAuto,
+		Encoding:        DialectAuto,
+		NumberFormat:    DefaultNumberFormat(),
+		FileDisposition: DefaultFileDisposition(),
+	}
+}
+
+// withDefaults fills unset fields with their default values.
+func (s TenantSettings) withDefaults() TenantSettings {
+	def := DefaultTenantSettings()
+	if s.IngestMode == "" {
+		s.IngestMode = def.IngestMode
+	}
+	if s.ImportPolicy == "" {
+		s.ImportPolicy = def.ImportPolicy
+	}
+	if s.RaggedRows == "" {
+		s.RaggedRows = def.RaggedRows
+	}
+	if s.Delimiter == "" {
+		s.Delimiter = def.Delimiter
+	}
+	if s.Quote == "" {
+		s.Quote = def.Quote
+	}
+	if s.Encoding == "" {
+		s.Encoding = def.Encoding
+	}
+	s.NumberFormat = s.NumberFormat.withDefaults()
+	s.FileDisposition = s.FileDisposition.withDefaults()
+	return s
+}
+
+// Validate reports whether every field holds a supported value.
+func (s TenantSettings) Validate() error {
+	switch s.IngestMode {
+	case IngestIncremental, IngestSnapshot:
+	default:
+		return fmt.Errorf("ingest_mode must be %q or %q", IngestIncremental, IngestSnapshot)
+	}
+	switch s.ImportPolicy {
+	case ImportAtomic, ImportBestEffort:
+	default:
+		return fmt.Errorf("import_policy must be %q or %q", ImportAtomic, ImportBestEffort)
+	}
+	switch s.RaggedRows {
+	case RaggedReject, RaggedPad, RaggedFail:
+	default:
+		return fmt.Errorf("ragged_rows must be %q, %q or %q", RaggedReject, RaggedPad, RaggedFail)
+	}
+	switch {
+	case s.Delimiter == DialectAuto:
+	case len(s.Delimiter) == 1 && strings.Contains(delimiterCandidates, s.Delimiter):
+	default:
+		return fmt.Errorf("delimiter must be %q or one of , ; | and tab", DialectAuto)
+	}
+	switch s.Quote {
+	case DialectAuto, `"`, "'":
+	default:
+		return fmt.Errorf(`quote must be %q, '"' or "'"`, DialectAuto)
+	}
+	switch s.Encoding {
+	case DialectAuto, EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingWindows1252, EncodingISO88591:
+	default:
+		return fmt.Errorf("encoding must be %q, %q, %q, %q, %q or %q", DialectAuto,
+			EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingWindows1252, EncodingISO88591)
+	}
+	if err := s.NumberFormat.Validate(); err != nil {
+		return err
+	}
+	return s.FileDisposition.Validate()
+}
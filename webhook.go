@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventImportCompleted = "import.completed"
+	EventImportFailed    = "import.failed"
+	EventTenantCreated   = "tenant.created"
+	EventTenantDeleted   = "tenant.deleted"
+)
+
+// webhookEvents lists the events webhooks can subscribe to.
+var webhookEvents = []string{EventImportCompleted, EventImportFailed, EventTenantCreated, EventTenantDeleted}
+
+// JobKindWebhook is the kind of the jobs that deliver webhook events.
+const JobKindWebhook = "webhook"
+
+// webhookTimeout bounds one delivery attempt, response included.
+const webhookTimeout = 10 * time.Second
+
+// Webhook delivery headers. The signature is "sha256=" followed by the hex
+// HMAC-SHA256, keyed with the webhook's secret, of the timestamp, a dot and
+// the request body.
+const (
+	headerWebhookEvent     = "X-Webhook-Event"
+	headerWebhookDelivery  = "X-Webhook-Delivery"
+	headerWebhookTimestamp = "X-Webhook-Timestamp"
+	headerWebhookSignature = "X-Webhook-Signature"
+)
+
+// Webhooks sends events to the webhooks subscribed to them. Each delivery is
+// logged and posted by a job of the queue, so failed deliveries are retried
+// with the queue's backoff and survive restarts.
+type Webhooks struct {
+	db     *DB
+	queue  *Queue
+	client *http.Client
+}
+
+// NewWebhooks creates a Webhooks that delivers through queue. Register its
+// Deliver method on the queue for JobKindWebhook jobs.
+func NewWebhooks(db *DB, queue *Queue) *Webhooks {
+	return &Webhooks{db: db, queue: queue, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Emit queues a delivery of event, which concerns the tenant tenantID, to
+// every webhook subscribed to it. data is the "data" member of the payload.
+// Failures are logged: an event that cannot be queued must not fail the
+// import or request that raised it. Emit does nothing on a nil Webhooks.
+func (wh *Webhooks) Emit(event, tenantID string, data any) {
+	if wh == nil {
+		return
+	}
+	if err := wh.emit(event, tenantID, data); err != nil {
+		log.Printf("webhooks: %s: %v", event, err)
+	}
+}
+
+func (wh *Webhooks) emit(event, tenantID string, data any) error {
+	hooks, err := wh.db.WebhooksFor(event, tenantID)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"event":       event,
+		"tenant_id":   tenantID,
+		"occurred_at": time.Now().UTC(),
+		"data":        data,
+	})
+	if err != nil {
+		return fmt.Errorf("encode payload: %w", err)
+	}
+	for _, hook := range hooks {
+		d := &WebhookDelivery{WebhookID: hook.ID, Event: event, Payload: payload}
+		if err := wh.db.CreateDelivery(d); err != nil {
+			return err
+		}
+		if _, err := wh.queue.EnqueueKind(JobKindWebhook, map[string]any{"delivery_id": d.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deliver is the JobHandler for JobKindWebhook jobs. It posts the delivery
+// named by the job to its webhook and logs the outcome. Responses other than
+// 2xx are errors, so the queue retries them until the job runs out of
+// attempts; the delivery is then marked failed.
+func (wh *Webhooks) Deliver(ctx context.Context, job *Job) error {
+	id, _ := job.Payload["delivery_id"].(float64)
+	d, err := wh.db.GetDelivery(int64(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		// The webhook was deleted together with its deliveries.
+		return Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	hook, err := wh.db.GetWebhook(d.WebhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.ResponseStatus, err = wh.post(ctx, hook, d)
+	d.Attempts = job.Attempts
+	d.Status, d.Error = DeliveryDelivered, ""
+	if err != nil {
+		d.Status, d.Error = DeliveryPending, err.Error()
+		if gaveUp(job, err) {
+			d.Status = DeliveryFailed
+		}
+	}
+	if updateErr := wh.db.UpdateDelivery(d); updateErr != nil {
+		log.Printf("webhooks: %v", updateErr)
+	}
+	// The webhooks of a deleted tenant are kept until their last delivery
+	// is done.
+	if d.Status != DeliveryPending && hook.TenantID != "" {
+		if pruneErr := wh.db.DeleteRetiredWebhook(hook.ID); pruneErr != nil {
+			log.Printf("webhooks: %v", pruneErr)
+		}
+	}
+	return err
+}
+
+// post sends d to hook and returns the HTTP status of the response.
+func (wh *Webhooks) post(ctx context.Context, hook *Webhook, d *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, Permanent(fmt.Errorf("webhook %d: %w", hook.ID, err))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerWebhookEvent, d.Event)
+	req.Header.Set(headerWebhookDelivery, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(headerWebhookTimestamp, timestamp)
+	req.Header.Set(headerWebhookSignature, "sha256="+signWebhook(hook.Secret, timestamp, d.Payload))
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook %d: %w", hook.ID, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook %d: %s responded %s", hook.ID, hook.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of the timestamp and body of a
+// delivery, keyed with secret.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret generates a random 64-char hex signing secret.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// tenantEvent is the data of tenant events: the tenant without its
+// password.
+func tenantEvent(t *Tenant) map[string]any {
+	return map[string]any{
+		"id":         t.ID,
+		"tenant_id":  t.TenantID,
+		"username":   t.Username,
+		"home_dir":   t.HomeDir,
+		"created_at": t.CreatedAt,
+	}
+}
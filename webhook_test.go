@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the deliveries posted to it, answering with the
+// status returned by respond.
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, respond func(n int) int) *webhookReceiver {
+	t.Helper()
+	recv := &webhookReceiver{}
+	recv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		recv.mu.Lock()
+		recv.requests = append(recv.requests, r)
+		recv.bodies = append(recv.bodies, body)
+		n := len(recv.requests)
+		recv.mu.Unlock()
+		w.WriteHeader(respond(n))
+	}))
+	t.Cleanup(recv.Close)
+	return recv
+}
+
+func (recv *webhookReceiver) count() int {
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	return len(recv.requests)
+}
+
+// newTestWebhooks returns Webhooks delivering through a running queue that
+// retries straight away.
+func newTestWebhooks(t *testing.T, db *DB) *Webhooks {
+	t.Helper()
+	q := NewQueue(db, nil, Config{WorkerConcurrency: 2, JobMaxAttempts: 3, JobRetryBase: time.Millisecond, JobRetryMax: time.Millisecond})
+	wh := NewWebhooks(db, q)
+	q.Handle(JobKindWebhook, wh.Deliver)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := q.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		q.Wait()
+	})
+	return wh
+}
+
+// waitForDelivery polls until the only delivery of webhook id reaches status.
+func waitForDelivery(t *testing.T, db *DB, id int64, status string) WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := db.ListDeliveries(id, 10)
+		if err != nil {
+			t.Fatalf("ListDeliveries: %v", err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status == status {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries of webhook %d = %+v, want one %s", id, deliveries, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDeliversSignedEvents(t *testing.T) {
+	db := newTestDB(t)
+	wh := newTestWebhooks(t, db)
+	recv := newWebhookReceiver(t, func(int) int { return http.StatusNoContent })
+
+	global := &Webhook{URL: recv.URL, Secret: "s3cret", Events: []string{EventImportCompleted}}
+	other := &Webhook{TenantID: "tid2", URL: recv.URL, Secret: "other", Events: []string{EventImportCompleted}}
+	failures := &Webhook{URL: recv.URL, Secret: "s3cret", Events: []string{EventImportFailed}}
+	for _, hook := range []*Webhook{global, other, failures} {
+		if err := db.CreateWebhook(hook); err != nil {
+			t.Fatalf("CreateWebhook: %v", err)
+		}
+	}
+
+	wh.Emit(EventImportCompleted, "tid1", map[string]any{"id": 7})
+	d := waitForDelivery(t, db, global.ID, DeliveryDelivered)
+	if d.Attempts != 1 || d.ResponseStatus != http.StatusNoContent || d.Error != "" {
+		t.Errorf("delivery = %+v, want one successful attempt", d)
+	}
+	for _, hook := range []*Webhook{other, failures} {
+		if deliveries, _ := db.ListDeliveries(hook.ID, 10); len(deliveries) != 0 {
+			t.Errorf("webhook %d got deliveries %+v", hook.ID, deliveries)
+		}
+	}
+
+	if recv.count() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", recv.count())
+	}
+	req, body := recv.requests[0], recv.bodies[0]
+	timestamp := req.Header.Get(headerWebhookTimestamp)
+	if got, want := req.Header.Get(headerWebhookSignature), "sha256="+signWebhook("s3cret", timestamp, body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if req.Header.Get(headerWebhookEvent) != EventImportCompleted || req.Header.Get(headerWebhookDelivery) != strconv.FormatInt(d.ID, 10) {
+		t.Errorf("headers = %v", req.Header)
+	}
+	var payload struct {
+		Event    string         `json:"event"`
+		TenantID string         `json:"tenant_id"`
+		Data     map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("decode payload %s: %v", body, err)
+	}
+	if payload.Event != EventImportCompleted || payload.TenantID != "tid1" || payload.Data["id"] != float64(7) {
+		t.Errorf("payload = %s", body)
+	}
+	if string(d.Payload) != string(body) {
+		t.Errorf("logged payload = %s, want %s", d.Payload, body)
+	}
+}
+
+func TestWebhookRetriesFailedDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	wh := newTestWebhooks(t, db)
+	flaky := newWebhookReceiver(t, func(n int) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	down := newWebhookReceiver(t, func(int) int { return http.StatusInternalServerError })
+
+	recovers := &Webhook{URL: flaky.URL, Secret: "a", Events: []string{EventTenantCreated}}
+	fails := &Webhook{URL: down.URL, Secret: "b", Events: []string{EventTenantCreated}}
+	for _, hook := range []*Webhook{recovers, fails} {
+		if err := db.CreateWebhook(hook); err != nil {
+			t.Fatalf("CreateWebhook: %v", err)
+		}
+	}
+
+	wh.Emit(EventTenantCreated, "tid1", nil)
+	if d := waitForDelivery(t, db, recovers.ID, DeliveryDelivered); d.Attempts != 2 || d.ResponseStatus != http.StatusOK {
+		t.Errorf("delivery = %+v, want delivered on the second attempt", d)
+	}
+	d := waitForDelivery(t, db, fails.ID, DeliveryFailed)
+	if d.Attempts != 3 || d.ResponseStatus != http.StatusInternalServerError || !strings.Contains(d.Error, "500") {
+		t.Errorf("delivery = %+v, want failed after 3 attempts", d)
+	}
+	if down.count() != 3 {
+		t.Errorf("receiver got %d requests, want 3", down.count())
+	}
+}
+
+func TestWebhookDeliveryOfDeletedWebhook(t *testing.T) {
+	db := newTestDB(t)
+	recv := newWebhookReceiver(t, func(int) int { return http.StatusOK })
+	hook := &Webhook{URL: recv.URL, Secret: "s", Events: []string{EventTenantDeleted}}
+	if err := db.CreateWebhook(hook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	d := &WebhookDelivery{WebhookID: hook.ID, Event: EventTenantDeleted, Payload: json.RawMessage(`{}`)}
+	if err := db.CreateDelivery(d); err != nil {
+		t.Fatalf("CreateDelivery: %v", err)
+	}
+	if err := db.DeleteWebhook(hook.ID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+
+	wh := NewWebhooks(db, nil)
+	job := &Job{Kind: JobKindWebhook, Payload: map[string]any{"delivery_id": float64(d.ID)}, Attempts: 1, MaxAttempts: 3}
+	if err := wh.Deliver(context.Background(), job); !IsPermanent(err) {
+		t.Errorf("Deliver: expected permanent error, got %v", err)
+	}
+	if recv.count() != 0 {
+		t.Errorf("receiver got %d requests, want none", recv.count())
+	}
+}
+
+func TestProcessUploadEventEmitsImportEvents(t *testing.T) {
+	w := newTenantTestWorker(t)
+	w.webhooks = NewWebhooks(w.db, NewQueue(w.db, nil, Config{JobMaxAttempts: 3}))
+	hook := &Webhook{TenantID: "tid1", URL: "http://example.com", Secret: "s", Events: webhookEvents}
+	if err := w.db.CreateWebhook(hook); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	store := w.store.(memStore)
+	store["tid1/good.csv"] = []byte("key,title,value\nR1,First,1\n")
+	store["tid1/bad.csv"] = []byte("key,title,value\nR2,Second,oops\n")
+
+	if err := w.ProcessUploadEvent(context.Background(), 1, uploadEvent("/good.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if err := w.ProcessUploadEvent(context.Background(), 2, uploadEvent("/bad.csv")); !IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	// A duplicate upload imports nothing and sends no event.
+	if err := w.ProcessUploadEvent(context.Background(), 3, uploadEvent("/good.csv")); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	deliveries, err := w.db.ListDeliveries(hook.ID, 10)
+	if err != nil {
+		t.Fatalf("ListDeliveries: %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[1].Event != EventImportCompleted || deliveries[0].Event != EventImportFailed {
+		t.Fatalf("deliveries = %+v, want import.completed then import.failed", deliveries)
+	}
+	var payload struct {
+		Data Import `json:"data"`
+	}
+	if err := json.Unmarshal(deliveries[0].Payload, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Data.JobID != 2 || payload.Data.Status != ImportFailed || payload.Data.RowsRejected != 1 || payload.Data.FinishedAt == nil {
+		t.Errorf("import.failed data = %+v", payload.Data)
+	}
+}
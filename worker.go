@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"runtime/debug"
 	"strconv"
 	"strings"
 
@@ -13,15 +19,28 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-// Worker downloads CSV files from S3 and upserts the rows into the records table.
+// Worker downloads uploaded files from S3 and upserts the rows into the records table.
 type Worker struct {
-	db     *DB
-	minio  *minio.Client
-	bucket string
+	db    *DB
+	store ObjectStore
+	// archiveMaxFiles and archiveMaxBytes protect against decompression
+	// bombs; see Config.
+	archiveMaxFiles int
+	archiveMaxBytes int64
+	// vault opens the tenants' PGP keys; nil when no master key is set.
+	vault *keyVault
+	// locks keeps two jobs from importing the same object at once.
+	locks objectLocks
+	// webhooks is told when imports complete or fail; nil sends nothing.
+	webhooks *Webhooks
+	// broker receives the progress of imports, with their row counts every
+	// progressRows rows; nil publishes nothing.
+	broker       *Broker
+	progressRows int
 }
 
 // NewWorker creates a Worker backed by the given MinIO/S3 configuration.
-func NewWorker(db *DB, cfg Config) (*Worker, error) {
+func NewWorker(db *DB, cfg Config, vault *keyVault) (*Worker, error) {
 	if cfg.S3Endpoint == "" {
 		return nil, fmt.Errorf("S3_ENDPOINT is required for worker")
 	}
@@ -35,97 +54,593 @@ func NewWorker(db *DB, cfg Config) (*Worker, error) {
 	if err != nil {
 		return nil, fmt.Errorf("minio client: %w", err)
 	}
-	return &Worker{db: db, minio: client, bucket: cfg.S3Bucket}, nil
+	return &Worker{
+		db:              db,
+		store:           &minioStore{client: client, bucket: cfg.S3Bucket},
+		archiveMaxFiles: cfg.ArchiveMaxFiles,
+		archiveMaxBytes: cfg.ArchiveMaxBytes,
+		vault:           vault,
+		progressRows:    cfg.ProgressRows,
+	}, nil
 }
 
-// ProcessUploadEvent handles an SFTPGo upload event by downloading the CSV
-// from S3 and upserting each row into the records table.
+const (
+	// errorReportSuffix is appended to an object key to name the report of
+	// its rejected rows. Objects with this suffix are never ingested.
+	errorReportSuffix = ".errors.csv"
+	// maxImportErrors caps how many rejected rows are kept per import;
+	// rows_rejected still counts all of them.
+	maxImportErrors = 1000
+	// maxRawRowLength caps the raw text stored for a rejected row.
+	maxRawRowLength = 1024
+	// importBatchSize is how many rows of a best-effort import are written
+	// per transaction.
+	importBatchSize = 500
+)
+
+// ImportStats counts what an import did with the rows of a file.
+type ImportStats struct {
+	Read     int
+	Inserted int
+	Updated  int
+	Rejected int
+	Deleted  int
+	Errors   []ImportError
+}
+
+// ProcessJob is the JobHandler for ingestion jobs, whose payload is the
+// SFTPGo event that triggered them. Events are routed by their action;
+// events without one are uploads. The status of the job after the attempt
+// is published to the clients following it.
+func (w *Worker) ProcessJob(ctx context.Context, job *Job) error {
+	err := w.processJob(ctx, job)
+	status := JobStatus{JobID: job.ID, Status: JobDone, Attempts: job.Attempts}
+	if err != nil {
+		status.Status, status.Error = JobPending, err.Error()
+		if gaveUp(job, err) {
+			status.Status = JobDead
+		}
+	}
+	w.broker.Publish(job.ID, ImportEventStatus, status)
+	return err
+}
+
+func (w *Worker) processJob(ctx context.Context, job *Job) error {
+	switch action, _ := job.Payload["action"].(string); action {
+	case "", TransferUpload:
+		err := w.ProcessUploadEvent(ctx, job.ID, job.Payload)
+		if err != nil && !IsPermanent(err) && job.Attempts >= job.MaxAttempts {
+			w.emitLastFailure(job.ID)
+		}
+		return err
+	case TransferDelete:
+		return w.ProcessDeleteEvent(ctx, job.ID, job.Payload)
+	case TransferRename:
+		return w.ProcessRenameEvent(ctx, job.ID, job.Payload)
+	default:
+		log.Printf("worker: ignoring %s event", action)
+		return nil
+	}
+}
+
+// ProcessUploadEvent handles an SFTPGo upload event by downloading the file
+// from S3 and upserting its rows into the records table, in one transaction
+// or, for best-effort imports, in batches. Tenants in snapshot mode
+// additionally lose every record that is absent from the file. The attempt is
+// recorded in the imports table under jobID, and rejected rows are stored
+// with it and written back next to the upload as <file>.errors.csv. Errors
+// that a retry cannot fix are wrapped with Permanent.
+//
+// The format is chosen from the file extension, or from the content when the
+// extension is not registered; files in no supported format are skipped.
+// Columns are mapped to record fields by the tenant's schema. Without one the
+// expected columns are: key, title, description, category, value. Any other
+// column is kept in the record's attributes.
+//
+// Every successful import records the ETag and SHA-256 of the object, and
+// events for an object whose content has not changed since are recorded as
+// skipped imports, unless the event carries "force": true, which only the
+// management API sets: the upload hook drops it from events. Events for the
+// same object are processed one at a time, so an upload reported by both the
+// hook and a bucket notification is imported once.
+//
+// Once the import succeeded or failed for good, tenants whose settings say so
+// get the upload moved into their processed or failed folder; uploads already
+// in those folders are skipped unless forced. The import.completed or
+// import.failed webhook event is sent then too.
 //
-// Expected CSV columns: key, title, description, category, value
-func (w *Worker) ProcessUploadEvent(event map[string]any) {
+// An event with "dry_run": true, which the management API sets too, parses
+// and validates the file and records the import with what it would have
+// done, but writes no records, error report or ingestion state and leaves
+// the upload in place.
+func (w *Worker) ProcessUploadEvent(ctx context.Context, jobID int64, event map[string]any) error {
 	username, _ := event["username"].(string)
 	virtualPath, _ := event["virtual_path"].(string)
 
 	if username == "" || virtualPath == "" {
-		log.Printf("worker: missing username or virtual_path in event")
-		return
+		return Permanent(fmt.Errorf("missing username or virtual_path in event"))
 	}
 
-	if !strings.HasSuffix(strings.ToLower(virtualPath), ".csv") {
-		log.Printf("worker: skipping non-CSV file %s", virtualPath)
-		return
+	if strings.HasSuffix(strings.ToLower(virtualPath), errorReportSuffix) {
+		log.Printf("worker: skipping error report %s", virtualPath)
+		return nil
 	}
 
 	tenant, err := w.db.GetTenantByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Permanent(fmt.Errorf("tenant %s not found", username))
+	}
 	if err != nil {
-		log.Printf("worker: tenant %s not found: %v", username, err)
-		return
+		return err
+	}
+
+	settings, err := w.db.GetTenantSettings(tenant.TenantID)
+	if err != nil {
+		return err
+	}
+	schema, err := w.db.GetTenantSchema(tenant.TenantID)
+	if err != nil {
+		return err
+	}
+
+	force, _ := event["force"].(bool)
+	dryRun, _ := event["dry_run"].(bool)
+	moved := settings.FileDisposition.holds(virtualPath)
+	if moved && !force && !dryRun {
+		log.Printf("worker: skipping %s in a processed or failed folder", virtualPath)
+		return nil
 	}
 
 	objectKey := tenant.TenantID + "/" + strings.TrimPrefix(virtualPath, "/")
+	defer w.locks.lock(objectKey)()
 
-	obj, err := w.minio.GetObject(context.Background(), w.bucket, objectKey, minio.GetObjectOptions{})
+	obj, info, err := w.store.Open(ctx, objectKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		// A repeated event may arrive after the upload was moved into the
+		// processed folder.
+		if prev, dbErr := w.db.GetIngestedObject(tenant.TenantID, objectKey); dbErr == nil && prev != nil && !force && !dryRun {
+			log.Printf("worker: skipping %s, ingested by import %d and since removed", objectKey, prev.ImportID)
+			return nil
+		}
+		return Permanent(err)
+	}
 	if err != nil {
-		log.Printf("worker: failed to get object %s: %v", objectKey, err)
-		return
+		return err
 	}
 	defer func() { _ = obj.Close() }()
 
-	reader := csv.NewReader(obj)
+	file := upload{
+		jobID:     jobID,
+		tenantID:  tenant.TenantID,
+		name:      virtualPath,
+		objectKey: objectKey,
+		reportKey: objectKey + errorReportSuffix,
+		size:      info.Size,
+		etag:      info.ETag,
+		dryRun:    dryRun,
+	}
+	if !force && !dryRun {
+		var duplicateOf int64
+		duplicateOf, obj, err = w.checkDuplicate(ctx, &file, obj)
+		if err != nil {
+			return err
+		}
+		if duplicateOf != 0 {
+			if _, err := w.db.SkipImport(jobID, file.tenantID, objectKey, file.size, file.etag, duplicateOf); err != nil {
+				return err
+			}
+			log.Printf("worker: skipping %s, content unchanged since import %d", objectKey, duplicateOf)
+			w.dispose(ctx, file, settings.FileDisposition, false)
+			return nil
+		}
+	}
+
+	digest := sha256.New()
+	content := io.TeeReader(obj, digest)
+	budget := &byteBudget{limit: w.archiveMaxBytes}
+	var imp *Import
+	if isArchive(virtualPath) {
+		imp, err = w.importArchive(ctx, jobID, file, content, budget, settings, schema)
+	} else {
+		imp, err = w.importFile(ctx, jobID, nil, file, content, budget, settings, schema)
+	}
+	if dryRun {
+		return err
+	}
+	if err == nil && imp != nil {
+		w.recordIngested(file, imp, content, digest)
+	}
+	// Uploads stay in place while a retry may still need them, when they
+	// were skipped as unsupported, and when they were already moved and
+	// imported again by hand.
+	if (err == nil || IsPermanent(err)) && imp != nil && !moved {
+		w.dispose(ctx, file, settings.FileDisposition, err != nil)
+	}
+	if err == nil && imp != nil {
+		w.webhooks.Emit(EventImportCompleted, file.tenantID, imp)
+	} else if IsPermanent(err) && imp != nil {
+		w.webhooks.Emit(EventImportFailed, file.tenantID, imp)
+	}
+	return err
+}
 
-	header, err := reader.Read()
+// emitLastFailure sends the import.failed event for the last import of job
+// jobID, which ran out of attempts on an error that was not permanent.
+func (w *Worker) emitLastFailure(jobID int64) {
+	imports, err := w.db.ListJobImports(jobID)
 	if err != nil {
-		log.Printf("worker: failed to read CSV header: %v", err)
+		log.Printf("worker: %v", err)
 		return
 	}
+	for i := len(imports) - 1; i >= 0; i-- {
+		if imp := imports[i]; imp.ParentID == nil {
+			if imp.Status == ImportFailed && !imp.DryRun {
+				w.webhooks.Emit(EventImportFailed, imp.TenantID, imp)
+			}
+			return
+		}
+	}
+}
+
+// upload is a file to import: an uploaded object or a file extracted from an
+// uploaded archive.
+type upload struct {
+	// jobID is the ingestion job importing the file.
+	jobID    int64
+	tenantID string
+	// name picks the parser by its extension.
+	name      string
+	objectKey string
+	// reportKey is where the rejected rows are written back.
+	reportKey string
+	size      int64
+	etag      string
+	// dryRun validates the file without writing records, reports or
+	// anything else.
+	dryRun bool
+	// sealed is set for PGP messages, which are only verified once read to
+	// the end, so none of their rows may be committed before that.
+	sealed bool
+}
 
-	colIndex := make(map[string]int, len(header))
-	for i, h := range header {
-		colIndex[strings.TrimSpace(strings.ToLower(h))] = i
+// importFile imports the rows of file from r and records the attempt, as a
+// child of parent when the file comes from an archive. PGP messages are
+// decrypted and gzip-compressed files decompressed as they are read, within
+// budget. It returns a nil import
+// for files in no supported format.
+func (w *Worker) importFile(ctx context.Context, jobID int64, parent *Import, file upload, r io.Reader, budget *byteBudget, settings TenantSettings, schema TenantSchema) (*Import, error) {
+	name, plain, sealed, err := w.decrypt(file.tenantID, file.name, r, budget)
+	file.sealed = sealed
+	var content *bufio.Reader
+	if err == nil {
+		name, content, err = decompress(name, plain, budget)
 	}
-	for _, required := range []string{"key", "title", "value"} {
-		if _, ok := colIndex[required]; !ok {
-			log.Printf("worker: CSV missing required column: %s", required)
-			return
+	var parser Parser
+	if err == nil {
+		var head []byte
+		head, err = content.Peek(sniffSize)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		if parser = parserFor(name, head, budget); err == nil && parser == nil {
+			log.Printf("worker: skipping unsupported file %s", file.objectKey)
+			return nil, nil
 		}
 	}
 
-	count := 0
+	var imp *Import
+	var startErr error
+	if parent != nil {
+		imp, startErr = w.db.StartChildImport(parent, file.objectKey, file.size, file.etag)
+	} else {
+		imp, startErr = w.db.StartImport(jobID, file.tenantID, file.objectKey, file.size, file.etag, file.dryRun)
+	}
+	if startErr != nil {
+		return nil, startErr
+	}
+
+	var stats ImportStats
+	if err == nil {
+		stats, err = w.safeImport(file, parser, content, settings, schema)
+	}
+	imp.DryRun = file.dryRun
+	imp.RowsRead, imp.RowsInserted, imp.RowsUpdated = stats.Read, stats.Inserted, stats.Updated
+	imp.RowsRejected, imp.RowsDeleted = stats.Rejected, stats.Deleted
+	imp.Status = ImportCompleted
+	if err != nil {
+		imp.Status, imp.Error = ImportFailed, err.Error()
+	}
+	if finishErr := w.db.FinishImport(imp); finishErr != nil {
+		log.Printf("worker: %v", finishErr)
+	}
+	if saveErr := w.db.AddImportErrors(imp.ID, stats.Errors); saveErr != nil {
+		log.Printf("worker: %v", saveErr)
+	}
+	for _, rowErr := range stats.Errors {
+		rowErr.ImportID = imp.ID
+		w.broker.Publish(jobID, ImportEventError, rowErr)
+	}
+	w.broker.Publish(jobID, ImportEventImport, *imp)
+	if (err == nil || IsPermanent(err)) && !file.dryRun {
+		if reportErr := w.writeErrorReport(ctx, file.reportKey, stats.Errors); reportErr != nil {
+			log.Printf("worker: %v", reportErr)
+		}
+	}
+	if err != nil {
+		return imp, fmt.Errorf("import %s: %w", file.objectKey, err)
+	}
+
+	if file.dryRun {
+		log.Printf("worker: dry run %d of %s (%s): read %d, would insert %d, update %d, reject %d, delete %d",
+			imp.ID, file.objectKey, parser.Name(), stats.Read, stats.Inserted, stats.Updated, stats.Rejected, stats.Deleted)
+		return imp, nil
+	}
+	log.Printf("worker: import %d of %s (%s): read %d, inserted %d, updated %d, rejected %d, deleted %d",
+		imp.ID, file.objectKey, parser.Name(), stats.Read, stats.Inserted, stats.Updated, stats.Rejected, stats.Deleted)
+	return imp, nil
+}
+
+// importRows maps the columns of rows to record fields with schema and
+// applies them to the records of file's tenant, noting file's object key, job
+// and line as their source. Every rejected row is reported in stats.Errors.
+// Under the atomic import policy the file is applied in a single transaction
+// and any rejected row rolls the whole file back, although the rest of the
+// file is still validated so all problems are reported at once. Under best
+// effort rejected rows are skipped and the rest is written in batches of
+// importBatchSize rows, each committed in its own transaction once it has been
+// read, so the database is not locked while the file is streamed; dry runs,
+// sealed files and snapshots still use a single transaction. In snapshot mode
+// records missing from the file are deleted in that transaction. Errors
+// caused by the file's content are permanent; failures reading the stream or
+// writing the database are not. When the import fails, the returned stats
+// report only the rows of batches already committed. A dry run is always
+// rolled back; its stats say what the import would have written.
+func (w *Worker) importRows(file upload, rows RowReader, settings TenantSettings, schema TenantSchema) (ImportStats, error) {
+	var stats ImportStats
+	bestEffort := settings.ImportPolicy == ImportBestEffort
+	batched := bestEffort && !file.dryRun && !file.sealed && settings.IngestMode != IngestSnapshot
+	header := rows.Header()
+
+	mapping, err := schema.bind(header)
+	if err != nil {
+		return stats, Permanent(err)
+	}
+
+	var tx *ImportTx
+	if !batched {
+		if tx, err = w.db.BeginImport(file.tenantID); err != nil {
+			return stats, err
+		}
+		defer func() { _ = tx.Rollback() }()
+	}
+
+	var inserted, updated int // rows of the batches already committed
+	fail := func(err error) (ImportStats, error) {
+		stats.Inserted, stats.Updated, stats.Deleted = inserted, updated, 0
+		return stats, err
+	}
+	reject := func(line int, column, reason, rawRow string) {
+		stats.Rejected++
+		if len(stats.Errors) < maxImportErrors {
+			stats.Errors = append(stats.Errors, ImportError{Line: line, Column: column, Reason: reason, Raw: truncate(rawRow, maxRawRowLength)})
+		}
+	}
+	upsert := func(tx *ImportTx, r Record) error {
+		isNew, err := tx.UpsertRecord(r)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			stats.Inserted++
+		} else {
+			stats.Updated++
+		}
+		return nil
+	}
+
+	var batch []Record
+	// flush writes the batch in its own transaction.
+	flush := func() error {
+		tx, err := w.db.BeginImport(file.tenantID)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback() }()
+		for _, r := range batch {
+			if err := upsert(tx, r); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		inserted, updated = stats.Inserted, stats.Updated
+		return nil
+	}
+
+	seen := make(map[string]struct{})
+	unreadable := 0
 	for {
-		row, err := reader.Read()
+		if w.progressRows > 0 && stats.Read > 0 && stats.Read%w.progressRows == 0 {
+			// The rows read so far are written first so that the event
+			// counts them.
+			if batched && len(batch) > 0 {
+				if err := flush(); err != nil {
+					return fail(err)
+				}
+			}
+			w.broker.Publish(file.jobID, ImportEventProgress, ImportProgress{
+				ObjectKey: file.objectKey,
+				RowsRead:  stats.Read, RowsInserted: stats.Inserted, RowsUpdated: stats.Updated, RowsRejected: stats.Rejected,
+			})
+		}
+		row, err := rows.Next()
 		if err == io.EOF {
 			break
 		}
+		stats.Read++
 		if err != nil {
-			log.Printf("worker: CSV read error: %v", err)
-			return
+			var readErr *rowReadError
+			if !errors.As(err, &readErr) {
+				return fail(err)
+			}
+			reject(readErr.line, "", readErr.reason, readErr.raw)
+			unreadable++
+			continue
 		}
+		line, rawRow := row.Line, row.Raw
 
-		recordKey := strings.TrimSpace(row[colIndex["key"]])
-		title := strings.TrimSpace(row[colIndex["title"]])
+		values, fieldErr := mapping.apply(row.Fields)
+		recordKey := values[FieldKey]
+		// A row that fails validation still counts as present so that a typo
+		// in a snapshot file does not delete the existing record.
+		seen[recordKey] = struct{}{}
 
-		var description string
-		if idx, ok := colIndex["description"]; ok {
-			description = strings.TrimSpace(row[idx])
+		// Under RaggedPad missing fields read as empty and extra ones are
+		// ignored, both through field.
+		if len(row.Fields) != len(header) && settings.RaggedRows != RaggedPad {
+			reason := fmt.Sprintf("row has %d fields, header has %d", len(row.Fields), len(header))
+			if settings.RaggedRows == RaggedFail {
+				return fail(Permanent(fmt.Errorf("line %d: %s", line, reason)))
+			}
+			reject(line, "", reason, rawRow)
+			continue
 		}
-
-		var category string
-		if idx, ok := colIndex["category"]; ok {
-			category = strings.TrimSpace(row[idx])
+		if fieldErr != nil {
+			reject(line, fieldErr.column, fieldErr.reason, rawRow)
+			continue
 		}
 
-		value, err := strconv.ParseFloat(strings.TrimSpace(row[colIndex["value"]]), 64)
+		rawValue := values[FieldValue]
+		decimal, value, err := settings.NumberFormat.Parse(rawValue)
 		if err != nil {
-			log.Printf("worker: invalid value in row: %v", err)
+			reject(line, mapping.column(FieldValue), fmt.Sprintf("invalid number %q", rawValue), rawRow)
 			continue
 		}
+		if !settings.ExactDecimals {
+			decimal = ""
+		}
 
-		if err := w.db.UpsertRecord(tenant.TenantID, recordKey, title, description, category, value); err != nil {
-			log.Printf("worker: upsert record error: %v", err)
+		// Once an atomic import has rejected a row it will be rolled back, so
+		// the remaining rows are only validated.
+		if !bestEffort && stats.Rejected > 0 {
+			continue
+		}
+		attrs := mapping.attributes(row.Fields)
+		for name, v := range row.Extra {
+			if _, ok := attrs[name]; !ok && v != "" {
+				attrs[name] = v
+			}
+		}
+		record := Record{
+			RecordKey:    recordKey,
+			Title:        values[FieldTitle],
+			Description:  values[FieldDescription],
+			Category:     values[FieldCategory],
+			Value:        value,
+			ValueDecimal: decimal,
+			Attributes:   attrs,
+			SourceKey:    file.objectKey,
+			JobID:        file.jobID,
+			SourceLine:   line,
+		}
+		if !batched {
+			if err := upsert(tx, record); err != nil {
+				return fail(err)
+			}
 			continue
 		}
-		count++
+		if batch = append(batch, record); len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	if !bestEffort && stats.Rejected > 0 {
+		first := stats.Errors[0]
+		return fail(Permanent(fmt.Errorf("%d rows rejected, first on line %d: %s", stats.Rejected, first.Line, first.Reason)))
+	}
+	if batched {
+		if err := flush(); err != nil {
+			return fail(err)
+		}
+		return stats, nil
+	}
+
+	if settings.IngestMode == IngestSnapshot {
+		// Keys of unreadable rows are unknown, so the snapshot cannot tell
+		// which records are really missing from the file.
+		if unreadable > 0 {
+			log.Printf("worker: snapshot has %d unreadable rows, keeping records missing from the file", unreadable)
+		} else {
+			deleted, err := tx.DeleteMissing(seen, file.jobID)
+			if err != nil {
+				return fail(err)
+			}
+			stats.Deleted = int(deleted)
+		}
+	}
+	if file.dryRun {
+		return stats, nil
 	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+	return stats, nil
+}
 
-	log.Printf("worker: processed %d records for tenant %s", count, tenant.TenantID)
+// field returns row[i], or an empty string when the row is too short.
+func field(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// safeImport parses r with p and imports its rows, turning a panic into a
+// permanent error so a pathological file fails its own import instead of
+// crashing the server.
+func (w *Worker) safeImport(file upload, p Parser, r io.Reader, settings TenantSettings, schema TenantSchema) (stats ImportStats, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("worker: panic importing for tenant %s: %v\n%s", file.tenantID, rec, debug.Stack())
+			stats.Inserted, stats.Updated, stats.Deleted = 0, 0, 0
+			err = Permanent(fmt.Errorf("internal error while parsing file: %v", rec))
+		}
+	}()
+	rows, err := p.Open(r, settings)
+	if err != nil {
+		return stats, err
+	}
+	return w.importRows(file, rows, settings, schema)
+}
+
+// writeErrorReport stores the rejected rows of an import at reportKey, next
+// to the source object, so the tenant can see them over SFTP. A clean import
+// removes the report left by an earlier upload of the same file.
+func (w *Worker) writeErrorReport(ctx context.Context, reportKey string, rowErrors []ImportError) error {
+	if len(rowErrors) == 0 {
+		return w.store.Remove(ctx, reportKey)
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	_ = cw.Write([]string{"line", "column", "reason", "raw"})
+	for _, e := range rowErrors {
+		_ = cw.Write([]string{strconv.Itoa(e.Line), e.Column, e.Reason, e.Raw})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("encode error report: %w", err)
+	}
+	return w.store.Put(ctx, reportKey, &buf, int64(buf.Len()), "text/csv")
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "..."
 }
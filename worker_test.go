@@ -0,0 +1,798 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memStore is an in-memory ObjectStore keyed by object key.
+type memStore map[string][]byte
+
+func (m memStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+func (m memStore) Remove(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memStore) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, ObjectInfo{}, fmt.Errorf("object %s: %w", key, ErrObjectNotFound)
+	}
+	info := ObjectInfo{Key: key, Size: int64(len(data)), ETag: fmt.Sprintf("etag-%d", len(data))}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+func (m memStore) Copy(ctx context.Context, src, dst string) error {
+	data, ok := m[src]
+	if !ok {
+		return fmt.Errorf("object %s: %w", src, ErrObjectNotFound)
+	}
+	m[dst] = data
+	return nil
+}
+
+func (m memStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for key, data := range m {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: int64(len(data)), ETag: fmt.Sprintf("etag-%d", len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func newTestWorker(t *testing.T) *Worker {
+	t.Helper()
+	return &Worker{db: newTestDB(t), store: memStore{}, archiveMaxFiles: 10, archiveMaxBytes: 1 << 20}
+}
+
+// newTenantTestWorker returns a test worker with tenant tid1 registered as
+// user1, for tests that go through SFTPGo events.
+func newTenantTestWorker(t *testing.T) *Worker {
+	t.Helper()
+	w := newTestWorker(t)
+	if _, err := w.db.CreateTenant("tid1", "user1", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	return w
+}
+
+// importCSV imports the CSV in r; see importRows.
+func (w *Worker) importCSV(tenantID string, r io.Reader, settings TenantSettings, schema TenantSchema) (ImportStats, error) {
+	rows, err := csvParser{}.Open(r, settings)
+	if err != nil {
+		return ImportStats{}, err
+	}
+	return w.importRows(upload{tenantID: tenantID}, rows, settings, schema)
+}
+
+func TestImportCSVIncremental(t *testing.T) {
+	w := newTestWorker(t)
+
+	if err := w.db.UpsertRecord("tid1", "OLD", "Old record", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	csv := "key,title,description,category,value\nR1,First,Desc,cat-a,10.5\nR2,Second,,cat-b,20\n"
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), DefaultTenantSettings(), DefaultTenantSchema())
+	if err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	if stats.Read != 2 || stats.Inserted != 2 {
+		t.Errorf("stats = %+v, want 2 read and inserted", stats)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("expected 3 records in incremental mode, got %d", len(records))
+	}
+}
+
+func TestImportCSVSnapshotDeletesMissing(t *testing.T) {
+	w := newTestWorker(t)
+
+	for _, key := range []string{"R1", "R2", "R3"} {
+		if err := w.db.UpsertRecord("tid1", key, "Title", "", "", 1.0); err != nil {
+			t.Fatalf("UpsertRecord: %v", err)
+		}
+	}
+
+	csv := "key,title,value\nR1,Kept,5\nR3,Kept too,not-a-number\nR4,New,7\n"
+	settings := TenantSettings{IngestMode: IngestSnapshot, ImportPolicy: ImportBestEffort}
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema())
+	if err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	stats.Errors = nil
+	want := ImportStats{Read: 3, Inserted: 1, Updated: 1, Rejected: 1, Deleted: 1}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	got := make(map[string]string)
+	for _, r := range records {
+		got[r.RecordKey] = r.Title
+	}
+	if _, ok := got["R2"]; ok {
+		t.Error("R2 should have been deleted by the snapshot")
+	}
+	if got["R1"] != "Kept" {
+		t.Errorf("R1 title = %q, want %q", got["R1"], "Kept")
+	}
+	if got["R3"] != "Title" {
+		t.Errorf("R3 with invalid value should be left unchanged, got title %q", got["R3"])
+	}
+	if _, ok := got["R4"]; !ok {
+		t.Error("R4 should have been inserted")
+	}
+}
+
+func TestImportCSVSnapshotRollsBackOnReadError(t *testing.T) {
+	w := newTestWorker(t)
+
+	if err := w.db.UpsertRecord("tid1", "R1", "Original", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	csv := "key,title,value\nR2,New,5\nR3,\"broken,6\n"
+	settings := TenantSettings{IngestMode: IngestSnapshot}
+	if _, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema()); err == nil {
+		t.Fatal("expected read error")
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "R1" {
+		t.Errorf("records = %+v, want untouched R1 only", records)
+	}
+}
+
+func TestImportCSVMissingRequiredColumn(t *testing.T) {
+	w := newTestWorker(t)
+
+	if _, err := w.importCSV("tid1", strings.NewReader("key,title\nR1,T\n"), DefaultTenantSettings(), DefaultTenantSchema()); err == nil {
+		t.Error("expected error for missing value column")
+	}
+}
+
+func TestImportCSVAtomicRollsBackOnInvalidRow(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key,title,value\nR1,First,1\nR2,Second,oops\nR3,Third,3\n"
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), DefaultTenantSettings(), DefaultTenantSchema())
+	if err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+	if !IsPermanent(err) {
+		t.Errorf("invalid value should be a permanent error: %v", err)
+	}
+	if stats.Inserted != 0 || stats.Rejected != 1 {
+		t.Errorf("stats = %+v, want nothing inserted and 1 rejected", stats)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records after rollback, got %d", len(records))
+	}
+}
+
+func TestImportCSVBestEffortSkipsBadRows(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key,title,value\nR1,First,1\nR2,Second,oops\nR3,Third\nR4,Fourth,4\n"
+	settings := TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema())
+	if err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	if stats.Inserted != 2 || stats.Rejected != 2 || stats.Read != 4 {
+		t.Errorf("stats = %+v, want 4 read, 2 inserted, 2 rejected", stats)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected 2 records, got %d", len(records))
+	}
+}
+
+func TestImportCSVSnapshotBestEffortKeepsRecordsOnUnreadableRows(t *testing.T) {
+	w := newTestWorker(t)
+
+	if err := w.db.UpsertRecord("tid1", "R9", "Existing", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+
+	csv := "key,title,value\nR1,First,1\nR9,Short\n"
+	settings := TenantSettings{IngestMode: IngestSnapshot, ImportPolicy: ImportBestEffort}
+	if _, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema()); err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected R9 to survive alongside R1, got %d records", len(records))
+	}
+}
+
+func TestProcessUploadEventRecordsImport(t *testing.T) {
+	w := newTestWorker(t)
+
+	if _, err := w.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	if err := w.db.UpsertRecord("tid1", "R1", "Old", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	w.store.(memStore)["tid1/data.csv"] = []byte("key,title,value\nR1,Updated,2\nR2,New,3\n")
+
+	event := map[string]any{"action": "upload", "username": "testuser", "virtual_path": "/data.csv"}
+	if err := w.ProcessUploadEvent(context.Background(), 7, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	imports, err := w.db.ListJobImports(7)
+	if err != nil {
+		t.Fatalf("ListJobImports: %v", err)
+	}
+	if len(imports) != 1 {
+		t.Fatalf("expected 1 import, got %d", len(imports))
+	}
+	imp := imports[0]
+	if imp.Status != ImportCompleted || imp.ObjectKey != "tid1/data.csv" || imp.TenantID != "tid1" {
+		t.Errorf("import = %+v, want completed import of tid1/data.csv", imp)
+	}
+	if imp.RowsRead != 2 || imp.RowsInserted != 1 || imp.RowsUpdated != 1 {
+		t.Errorf("import counts = %+v, want 2 read, 1 inserted, 1 updated", imp)
+	}
+	if imp.Size == 0 || imp.ETag == "" || imp.FinishedAt == nil {
+		t.Errorf("import = %+v, want size, etag and finish time", imp)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	for i, r := range records {
+		if r.SourceKey != "tid1/data.csv" || r.JobID != 7 || r.SourceLine != i+2 {
+			t.Errorf("record %s = %+v, want it from line %d of tid1/data.csv in job 7", r.RecordKey, r, i+2)
+		}
+	}
+	if versions, _ := w.db.ListRecordHistory("tid1", "R1"); len(versions) != 1 || versions[0].Title != "Old" {
+		t.Errorf("history of R1 = %+v, want the old version", versions)
+	}
+}
+
+func TestProcessUploadEventRecordsFailure(t *testing.T) {
+	w := newTestWorker(t)
+
+	if _, err := w.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	w.store.(memStore)["tid1/bad.csv"] = []byte("key,title\nR1,No value\n")
+
+	event := map[string]any{"username": "testuser", "virtual_path": "/bad.csv"}
+	err := w.ProcessUploadEvent(context.Background(), 1, event)
+	if !IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+
+	imports, err := w.db.ListJobImports(1)
+	if err != nil {
+		t.Fatalf("ListJobImports: %v", err)
+	}
+	if len(imports) != 1 || imports[0].Status != ImportFailed || imports[0].Error == "" {
+		t.Errorf("imports = %+v, want one failed import with an error", imports)
+	}
+}
+
+func TestProcessUploadEventMissingObjectIsPermanent(t *testing.T) {
+	w := newTestWorker(t)
+
+	if _, err := w.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+
+	event := map[string]any{"username": "testuser", "virtual_path": "/gone.csv"}
+	if err := w.ProcessUploadEvent(context.Background(), 1, event); !IsPermanent(err) {
+		t.Errorf("expected permanent error for missing object, got %v", err)
+	}
+}
+
+func TestProcessUploadEventUnknownTenantIsPermanent(t *testing.T) {
+	w := newTestWorker(t)
+
+	event := map[string]any{"username": "nobody", "virtual_path": "/data.csv"}
+	if err := w.ProcessUploadEvent(context.Background(), 1, event); !IsPermanent(err) {
+		t.Errorf("expected permanent error for unknown tenant, got %v", err)
+	}
+}
+
+func TestImportCSVReportsRowErrors(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key,title,value\nR1,First,1\nR2,Second,abc\nR3,\"bad\"quote,3\nR4,Fourth,4\n"
+	settings := TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema())
+	if err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	if len(stats.Errors) != 2 {
+		t.Fatalf("errors = %+v, want 2", stats.Errors)
+	}
+
+	valueErr := stats.Errors[0]
+	if valueErr.Line != 3 || valueErr.Column != "value" || valueErr.Raw != "R2,Second,abc" {
+		t.Errorf("value error = %+v, want line 3, column value, raw row", valueErr)
+	}
+	if !strings.Contains(valueErr.Reason, "abc") {
+		t.Errorf("reason = %q, want it to mention the bad value", valueErr.Reason)
+	}
+	quoteErr := stats.Errors[1]
+	if quoteErr.Line != 4 || quoteErr.Raw != `R3,"bad"quote,3` {
+		t.Errorf("quote error = %+v, want line 4 with raw row", quoteErr)
+	}
+}
+
+func TestImportCSVAtomicReportsEveryError(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key,title,value\nR1,First,x\nR2,Second,2\nR3,Third,y\n"
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), DefaultTenantSettings(), DefaultTenantSchema())
+	if err == nil {
+		t.Fatal("expected atomic import to fail")
+	}
+	if len(stats.Errors) != 2 || stats.Errors[0].Line != 2 || stats.Errors[1].Line != 4 {
+		t.Errorf("errors = %+v, want lines 2 and 4", stats.Errors)
+	}
+}
+
+func TestProcessUploadEventWritesErrorReport(t *testing.T) {
+	w := newTestWorker(t)
+	store := w.store.(memStore)
+
+	if _, err := w.db.CreateTenant("tid1", "testuser", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	if err := w.db.SaveTenantSettings("tid1", TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()); err != nil {
+		t.Fatalf("SaveTenantSettings: %v", err)
+	}
+	store["tid1/data.csv"] = []byte("key,title,value\nR1,First,1\nR2,Second,abc\n")
+
+	event := map[string]any{"username": "testuser", "virtual_path": "/data.csv"}
+	if err := w.ProcessUploadEvent(context.Background(), 1, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+
+	report, ok := store["tid1/data.csv.errors.csv"]
+	if !ok {
+		t.Fatal("expected error report next to the upload")
+	}
+	want := "line,column,reason,raw\n3,value,\"invalid number \"\"abc\"\"\",\"R2,Second,abc\"\n"
+	if string(report) != want {
+		t.Errorf("report = %q, want %q", report, want)
+	}
+	rowErrors, err := w.db.ListJobImportErrors(1)
+	if err != nil {
+		t.Fatalf("ListJobImportErrors: %v", err)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 3 {
+		t.Errorf("stored errors = %+v, want one error on line 3", rowErrors)
+	}
+
+	store["tid1/data.csv"] = []byte("key,title,value\nR1,First,1\nR2,Second,2\n")
+	if err := w.ProcessUploadEvent(context.Background(), 2, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	if _, ok := store["tid1/data.csv.errors.csv"]; ok {
+		t.Error("clean import should remove the stale error report")
+	}
+}
+
+func TestProcessUploadEventSkipsErrorReports(t *testing.T) {
+	w := newTestWorker(t)
+
+	event := map[string]any{"username": "testuser", "virtual_path": "/data.csv.errors.csv"}
+	if err := w.ProcessUploadEvent(context.Background(), 1, event); err != nil {
+		t.Errorf("expected error report to be skipped, got %v", err)
+	}
+}
+
+func TestImportCSVRaggedRows(t *testing.T) {
+	csv := "key,title,description,value\nR1,First,desc,1\nR2,Short\nR3,Long,desc,3,extra\n"
+
+	tests := []struct {
+		policy       string
+		wantErr      bool
+		wantInserted int
+		wantRejected int
+	}{
+		{RaggedReject, false, 1, 2},
+		{RaggedPad, false, 2, 1}, // R2 is padded but its empty value is still invalid
+		{RaggedFail, true, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			w := newTestWorker(t)
+			settings := TenantSettings{ImportPolicy: ImportBestEffort, RaggedRows: tt.policy}.withDefaults()
+			stats, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("importCSV error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !IsPermanent(err) {
+					t.Errorf("ragged fail should be permanent: %v", err)
+				}
+				return
+			}
+			if stats.Inserted != tt.wantInserted || stats.Rejected != tt.wantRejected {
+				t.Errorf("stats = %+v, want %d inserted and %d rejected", stats, tt.wantInserted, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestImportCSVPadsShortRows(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key,title,value,category\nR1,First,1\n"
+	settings := TenantSettings{RaggedRows: RaggedPad}.withDefaults()
+	if _, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema()); err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].Category != "" || records[0].Value != 1 {
+		t.Errorf("records = %+v, want R1 with empty category", records)
+	}
+}
+
+func TestImportCSVMalformedInputs(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		settings     TenantSettings
+		wantErr      bool
+		wantInserted int
+		wantRejected int
+	}{
+		{
+			name:         "utf-8 bom before header",
+			input:        "\ufeffkey,title,value\nR1,First,1\n",
+			wantInserted: 1,
+		},
+		{
+			name:         "stray quote rejected",
+			input:        "key,title,value\nR1,Say \"hi\",1\nR2,Fine,2\n",
+			settings:     TenantSettings{ImportPolicy: ImportBestEffort},
+			wantInserted: 1,
+			wantRejected: 1,
+		},
+		{
+			name:         "stray quote with lazy quotes",
+			input:        "key,title,value\nR1,Say \"hi\",1\nR2,Fine,2\n",
+			settings:     TenantSettings{LazyQuotes: true},
+			wantInserted: 2,
+		},
+		{
+			name:    "unterminated quote fails atomic import",
+			input:   "key,title,value\nR1,\"open,1\nR2,Fine,2\n",
+			wantErr: true,
+		},
+		{
+			name:         "blank lines are skipped",
+			input:        "key,title,value\n\nR1,First,1\n\n",
+			wantInserted: 1,
+		},
+		{
+			name:         "crlf line endings",
+			input:        "key,title,value\r\nR1,First,1\r\nR2,Second,2\r\n",
+			wantInserted: 2,
+		},
+		{
+			name:    "empty file",
+			input:   "",
+			wantErr: true,
+		},
+		{
+			name:         "header only",
+			input:        "key,title,value\n",
+			wantInserted: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorker(t)
+			stats, err := w.safeImport(upload{tenantID: "tid1"}, csvParser{}, strings.NewReader(tt.input), tt.settings.withDefaults(), DefaultTenantSchema())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("import error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if stats.Inserted != tt.wantInserted || stats.Rejected != tt.wantRejected {
+				t.Errorf("stats = %+v, want %d inserted and %d rejected", stats, tt.wantInserted, tt.wantRejected)
+			}
+		})
+	}
+}
+
+// panicReader panics on the first read, standing in for a parser bug.
+type panicReader struct{}
+
+func (panicReader) Read(p []byte) (int, error) { panic("boom") }
+
+func TestSafeImportCSVRecoversFromPanic(t *testing.T) {
+	w := newTestWorker(t)
+
+	_, err := w.safeImport(upload{tenantID: "tid1"}, csvParser{}, panicReader{}, DefaultTenantSettings(), DefaultTenantSchema())
+	if err == nil || !IsPermanent(err) {
+		t.Fatalf("expected permanent error from panic, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error = %q, want it to mention the panic", err)
+	}
+}
+
+// failingRows yields rows numbered from 1 and fails once n rows were read.
+// When db is set it is written to before each row, which only succeeds while
+// no import transaction holds the write lock.
+type failingRows struct {
+	db   *DB
+	n    int
+	read int
+}
+
+func (f *failingRows) Header() []string { return []string{"key", "title", "value"} }
+
+func (f *failingRows) Next() (Row, error) {
+	if f.read == f.n {
+		return Row{}, errors.New("connection reset")
+	}
+	if f.db != nil {
+		if _, err := f.db.EnqueueJob(map[string]any{"row": f.read}, 1); err != nil {
+			return Row{}, err
+		}
+	}
+	f.read++
+	key := fmt.Sprintf("R%d", f.read)
+	return Row{Fields: []string{key, "Title", "1"}, Line: f.read + 1, Raw: key + ",Title,1"}, nil
+}
+
+func TestImportRowsBestEffortCommitsBatches(t *testing.T) {
+	w := newTestWorker(t)
+
+	n := importBatchSize + 10
+	settings := TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()
+	stats, err := w.importRows(upload{tenantID: "tid1"}, &failingRows{db: w.db, n: n}, settings, DefaultTenantSchema())
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("importRows error = %v, want the read error", err)
+	}
+	if stats.Read != n+1 || stats.Inserted != importBatchSize {
+		t.Errorf("stats = %+v, want %d rows read and the %d rows of the first batch inserted", stats, n+1, importBatchSize)
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != importBatchSize {
+		t.Errorf("kept %d records, want the %d of the committed batch", len(records), importBatchSize)
+	}
+}
+
+func TestImportRowsSnapshotBestEffortRollsBack(t *testing.T) {
+	w := newTestWorker(t)
+
+	if err := w.db.UpsertRecord("tid1", "OLD", "Old record", "", "", 1.0); err != nil {
+		t.Fatalf("UpsertRecord: %v", err)
+	}
+	rows := &failingRows{n: importBatchSize + 10}
+	settings := TenantSettings{IngestMode: IngestSnapshot, ImportPolicy: ImportBestEffort}.withDefaults()
+	if _, err := w.importRows(upload{tenantID: "tid1"}, rows, settings, DefaultTenantSchema()); err == nil {
+		t.Fatal("expected read error")
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "OLD" {
+		t.Errorf("kept %d records, want only the untouched OLD record", len(records))
+	}
+}
+
+func TestImportRowsSealedBestEffortRollsBack(t *testing.T) {
+	w := newTestWorker(t)
+
+	rows := &failingRows{n: importBatchSize + 10}
+	settings := TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()
+	_, err := w.importRows(upload{tenantID: "tid1", sealed: true}, rows, settings, DefaultTenantSchema())
+	if err == nil {
+		t.Fatal("expected read error")
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("kept %d records of a sealed file that failed, want none", len(records))
+	}
+}
+
+func TestImportCSVWithSchema(t *testing.T) {
+	w := newTestWorker(t)
+
+	schema := TenantSchema{Fields: map[string]SchemaField{
+		FieldKey:   {Source: "sku", Required: true, Transforms: []Transform{{Type: TransformTrim}, {Type: TransformUpper}}},
+		FieldTitle: {Source: "name", Required: true, Transforms: []Transform{{Type: TransformTrim}}},
+		FieldValue: {Source: "price", Transforms: []Transform{{Type: TransformRegexExtract, Pattern: `[\d.]+`}}},
+	}}
+	csv := "sku,name,price\nab-1,Widget,$9.50\nab-2,,3\nab-3,Gizmo,n/a\n"
+	settings := TenantSettings{ImportPolicy: ImportBestEffort}.withDefaults()
+
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), settings, schema)
+	if err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	if stats.Inserted != 1 || stats.Rejected != 2 {
+		t.Fatalf("stats = %+v, want 1 inserted and 2 rejected", stats)
+	}
+	if stats.Errors[0].Column != "name" || stats.Errors[1].Column != "price" {
+		t.Errorf("errors = %+v, want them on the name and price columns", stats.Errors)
+	}
+
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "AB-1" || records[0].Title != "Widget" || records[0].Value != 9.5 {
+		t.Errorf("records = %+v, want AB-1 Widget 9.5", records)
+	}
+}
+
+func TestProcessUploadEventUsesTenantSchema(t *testing.T) {
+	w := newTestWorker(t)
+	if _, err := w.db.CreateTenant("tid1", "user1", "pass", "", "/data/tid1"); err != nil {
+		t.Fatalf("CreateTenant: %v", err)
+	}
+	schema := TenantSchema{Fields: map[string]SchemaField{
+		FieldKey:   {Source: "sku", Required: true},
+		FieldValue: {Source: "price", Required: true},
+	}}
+	if err := w.db.SaveTenantSchema("tid1", schema); err != nil {
+		t.Fatalf("SaveTenantSchema: %v", err)
+	}
+	w.store.(memStore)["tid1/data.csv"] = []byte("sku,price\nA1,4\n")
+
+	event := map[string]any{"username": "user1", "virtual_path": "/data.csv"}
+	if err := w.ProcessUploadEvent(context.Background(), 0, event); err != nil {
+		t.Fatalf("ProcessUploadEvent: %v", err)
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(records) != 1 || records[0].RecordKey != "A1" || records[0].Value != 4 {
+		t.Errorf("records = %+v, want A1 with value 4", records)
+	}
+}
+
+func TestImportCSVKeepsUnmappedColumnsAsAttributes(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key,title,value,Region,tier,notes\nR1,First,1, emea ,gold,\nR2,Second,2,apac,,\n"
+	if _, err := w.importCSV("tid1", strings.NewReader(csv), DefaultTenantSettings(), DefaultTenantSchema()); err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	want := []map[string]string{
+		{"region": "emea", "tier": "gold"},
+		{"region": "apac"},
+	}
+	for i, r := range records {
+		if !reflect.DeepEqual(r.Attributes, want[i]) {
+			t.Errorf("%s attributes = %v, want %v", r.RecordKey, r.Attributes, want[i])
+		}
+	}
+
+	// A re-upload replaces the attributes rather than merging them.
+	csv = "key,title,value,tier\nR1,First,1,silver\n"
+	if _, err := w.importCSV("tid1", strings.NewReader(csv), DefaultTenantSettings(), DefaultTenantSchema()); err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	records, err = w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if !reflect.DeepEqual(records[0].Attributes, map[string]string{"tier": "silver"}) {
+		t.Errorf("attributes after re-upload = %v, want only tier silver", records[0].Attributes)
+	}
+}
+
+func TestImportCSVNumberFormat(t *testing.T) {
+	w := newTestWorker(t)
+
+	csv := "key;title;value\nR1;Price;\"€ 1.234,56\"\nR2;Refund;-0,10\nR3;Bad;1,2,3\nR4;Huge;9e308\n"
+	settings := TenantSettings{
+		ImportPolicy:  ImportBestEffort,
+		NumberFormat:  NumberFormat{DecimalSeparator: ",", ThousandsSeparator: ".", StripCurrency: true},
+		ExactDecimals: true,
+	}.withDefaults()
+
+	stats, err := w.importCSV("tid1", strings.NewReader(csv), settings, DefaultTenantSchema())
+	if err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	if stats.Inserted != 2 || stats.Rejected != 2 {
+		t.Fatalf("stats = %+v, want 2 inserted and 2 rejected", stats)
+	}
+	if e := stats.Errors[1]; e.Line != 5 || e.Column != "value" {
+		t.Errorf("error = %+v, want the out of range value on line 5", e)
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	want := []struct {
+		value   float64
+		decimal string
+	}{{1234.56, "1234.56"}, {-0.1, "-0.10"}}
+	for i, r := range records {
+		if r.Value != want[i].value || r.ValueDecimal != want[i].decimal {
+			t.Errorf("%s = %v (%q), want %v (%q)", r.RecordKey, r.Value, r.ValueDecimal, want[i].value, want[i].decimal)
+		}
+	}
+}
+
+func TestImportCSVWithoutExactDecimals(t *testing.T) {
+	w := newTestWorker(t)
+
+	if _, err := w.importCSV("tid1", strings.NewReader("key,title,value\nR1,T,10.50\n"), DefaultTenantSettings(), DefaultTenantSchema()); err != nil {
+		t.Fatalf("importCSV: %v", err)
+	}
+	records, err := w.db.ListRecords("tid1")
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if records[0].ValueDecimal != "" {
+		t.Errorf("value_decimal = %q, want it empty when exact decimals are off", records[0].ValueDecimal)
+	}
+}